@@ -8,6 +8,8 @@ import (
 
 	"backend/internal/db"
 	"backend/internal/handlers"
+	"backend/internal/mail"
+	"backend/internal/models"
 
 	"backend/internal/middleware"
 
@@ -20,30 +22,115 @@ func main() {
 	}
 
 	db.InitDB()
+	mail.Init()
+	mail.StartOutbox()
+	handlers.StartDisposeJob()
+	handlers.StartExpiryMonitor()
+
+	// Role sets used by the routes below. The demo account may look at
+	// every screen but is left out of anything that writes.
+	var (
+		anyone    = []string{models.RoleCustomer, models.RoleWorker, models.RoleAdmin, models.RoleDemo}
+		customers = []string{models.RoleCustomer, models.RoleAdmin}
+		shoppers  = []string{models.RoleCustomer, models.RoleAdmin, models.RoleDemo}
+		workers   = []string{models.RoleWorker, models.RoleAdmin}
+		staff     = []string{models.RoleWorker, models.RoleAdmin, models.RoleDemo}
+		admins    = []string{models.RoleAdmin}
+		viewers   = []string{models.RoleAdmin, models.RoleDemo}
+	)
+	auth := middleware.WithAuth
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/login", middleware.WithCORS(handlers.LoginHandler))
+	mux.HandleFunc("/api/logout", middleware.WithCORS(handlers.LogoutHandler))
 	mux.HandleFunc("/api/register", middleware.WithCORS(handlers.RegisterHandler))
-	mux.HandleFunc("/api/products/with-stock", middleware.WithCORS(handlers.GetProductsWithStock))
+	mux.HandleFunc("/api/products/with-stock", middleware.WithCORS(auth(handlers.GetProductsWithStock, anyone...)))
 	mux.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir("./assets"))))
-	mux.HandleFunc("/api/purchase", middleware.WithCORS(handlers.CreatePurchaseRequest))
-	mux.HandleFunc("/api/purchase-requests", middleware.WithCORS(handlers.GetAllPurchaseRequests))
-	mux.HandleFunc("/api/purchase-requests/user/", middleware.WithCORS(handlers.GetPurchaseRequestsByUser))
-	mux.HandleFunc("/api/worker/rapports", middleware.WithCORS(handlers.GetWorkerRapports))
+	mux.HandleFunc("/api/purchase", middleware.WithCORS(auth(handlers.CreatePurchaseRequest, customers...)))
+	mux.HandleFunc("/api/cart", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			auth(handlers.GetCart, customers...)(w, r)
+		case http.MethodDelete:
+			auth(handlers.ClearCart, customers...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	mux.HandleFunc("/api/cart/items", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			auth(handlers.AddCartItem, customers...)(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	mux.HandleFunc("/api/cart/items/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			auth(handlers.UpdateCartItem, customers...)(w, r)
+		case http.MethodDelete:
+			auth(handlers.RemoveCartItem, customers...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	mux.HandleFunc("/api/cart/checkout", middleware.WithCORS(auth(handlers.CheckoutCart, customers...)))
+	mux.HandleFunc("/api/purchase-requests", middleware.WithCORS(auth(handlers.GetAllPurchaseRequests, viewers...)))
+	mux.HandleFunc("/api/purchase-requests/user/", middleware.WithCORS(auth(handlers.GetPurchaseRequestsByUser, shoppers...)))
+	mux.HandleFunc("/api/worker/rapports", middleware.WithCORS(auth(handlers.GetWorkerRapports, staff...)))
 
 	mux.HandleFunc("/api/products/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/image") {
-			handlers.GetProductImageHandler(w, r)
+			auth(handlers.GetProductImageHandler, anyone...)(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/reorder-settings") && r.Method == http.MethodPut {
+			auth(handlers.UpdateReorderSettings, admins...)(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/batches") {
+			auth(handlers.GetBatchesForProduct, viewers...)(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			auth(handlers.GetProduct, anyone...)(w, r)
+		case http.MethodPut:
+			auth(handlers.UpdateProduct, admins...)(w, r)
+		case http.MethodDelete:
+			auth(handlers.ArchiveProduct, admins...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	mux.HandleFunc("/api/categories", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			auth(handlers.GetCategories, anyone...)(w, r)
+		case http.MethodPost:
+			auth(handlers.CreateCategory, admins...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	mux.HandleFunc("/api/categories/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			auth(handlers.UpdateCategory, admins...)(w, r)
+		case http.MethodDelete:
+			auth(handlers.DeleteCategory, admins...)(w, r)
+		default:
+			http.NotFound(w, r)
 		}
-		handlers.GetBatchesForProduct(w, r)
 	}))
 
 	mux.HandleFunc("/api/products", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			handlers.GetProductsBySupplier(w, r)
+			auth(handlers.GetProductsBySupplier, viewers...)(w, r)
 		case http.MethodPost:
-			handlers.CreateProduct(w, r)
+			auth(handlers.CreateProduct, admins...)(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -51,9 +138,9 @@ func main() {
 	mux.Handle("/api/rapports", middleware.WithCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case "GET":
-			handlers.GetAllRapports(w, r)
+			auth(handlers.GetAllRapports, viewers...)(w, r)
 		case "POST":
-			handlers.CreateRapport(w, r)
+			auth(handlers.CreateRapport, workers...)(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -66,9 +153,9 @@ func main() {
 		}
 		switch {
 		case strings.HasSuffix(r.URL.Path, "/status") && r.Method == "POST":
-			handlers.UpdateRapportStatus(w, r)
+			auth(handlers.UpdateRapportStatus, admins...)(w, r)
 		case strings.HasSuffix(r.URL.Path, "/respond") && r.Method == "POST":
-			handlers.RespondRapport(w, r)
+			auth(handlers.RespondRapport, admins...)(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -76,32 +163,85 @@ func main() {
 
 	mux.HandleFunc("/api/purchase-requests/", func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/accept") || strings.HasSuffix(r.URL.Path, "/deny") {
-			middleware.WithCORS(handlers.HandleRequestStatus)(w, r)
+			middleware.WithCORS(auth(handlers.HandleRequestStatus, admins...))(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/cancel") {
+			middleware.WithCORS(auth(handlers.CancelPurchaseRequest, customers...))(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/confirm-delivery") {
+			middleware.WithCORS(auth(handlers.ConfirmDelivery, customers...))(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/status") {
+			middleware.WithCORS(auth(handlers.UpdatePurchaseRequestStatus, workers...))(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/history") {
+			middleware.WithCORS(auth(handlers.GetPurchaseRequestHistory, shoppers...))(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/auto-assign") {
+			middleware.WithCORS(auth(handlers.AutoAssignBatches, admins...))(w, r)
 			return
 		}
 		if strings.HasSuffix(r.URL.Path, "/assign-batches") {
-			middleware.WithCORS(handlers.AssignBatches)(w, r)
+			middleware.WithCORS(auth(handlers.AssignBatches, admins...))(w, r)
 			return
 		}
 		if strings.HasSuffix(r.URL.Path, "/details") {
-			middleware.WithCORS(handlers.GetPurchaseRequestDetails)(w, r)
+			middleware.WithCORS(auth(handlers.GetPurchaseRequestDetails, viewers...))(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/invoice.pdf") {
+			middleware.WithCORS(auth(handlers.GetInvoicePDF, shoppers...))(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/packing-slip.pdf") {
+			middleware.WithCORS(auth(handlers.GetPackingSlipPDF, anyone...))(w, r)
 			return
 		}
 
 		if r.Method == "GET" {
-			middleware.WithCORS(handlers.GetPurchaseRequestByID)(w, r)
+			middleware.WithCORS(auth(handlers.GetPurchaseRequestByID, viewers...))(w, r)
 			return
 		}
 
 		http.NotFound(w, r)
 	})
 
-	mux.HandleFunc("/api/worker/tasks", middleware.WithCORS(handlers.GetWorkerTasks))
-	mux.HandleFunc("/api/worker/tasks/", middleware.WithCORS(handlers.CompleteWorkerTask))
+	mux.HandleFunc("/api/worker/tasks", middleware.WithCORS(auth(handlers.GetWorkerTasks, staff...)))
+	mux.HandleFunc("/api/worker/tasks/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/worker/tasks/claim":
+			auth(handlers.ClaimNextTask, workers...)(w, r)
+			return
+		case strings.HasSuffix(r.URL.Path, "/release"):
+			auth(handlers.ReleaseTask, workers...)(w, r)
+			return
+		case strings.HasSuffix(r.URL.Path, "/pick-list") && r.Method == http.MethodGet:
+			auth(handlers.GetTaskPickList, staff...)(w, r)
+			return
+		case strings.HasSuffix(r.URL.Path, "/putaway") && r.Method == http.MethodGet:
+			auth(handlers.GetPutawaySuggestion, workers...)(w, r)
+			return
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			auth(handlers.StartWorkerTask, workers...)(w, r)
+			return
+		case strings.HasSuffix(r.URL.Path, "/confirm-pick") && r.Method == http.MethodPost:
+			auth(handlers.ConfirmPick, workers...)(w, r)
+			return
+		}
+		auth(handlers.CompleteWorkerTask, workers...)(w, r)
+	}))
+
+	mux.HandleFunc("/api/scan", middleware.WithCORS(auth(handlers.ScanCode, staff...)))
+	mux.HandleFunc("/api/labels/", middleware.WithCORS(auth(handlers.GetLabel, staff...)))
 
 	mux.HandleFunc("/api/ordered-products/", middleware.WithCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodDelete {
-			handlers.DeleteOrderedProduct(w, r)
+			auth(handlers.DeleteOrderedProduct, admins...)(w, r)
 			return
 		}
 		http.NotFound(w, r)
@@ -110,41 +250,194 @@ func main() {
 	mux.HandleFunc("/api/ordered-products", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			handlers.GetAllOrderedProducts(w, r)
+			auth(handlers.GetAllOrderedProducts, staff...)(w, r)
 		case http.MethodPost:
-			handlers.CreateOrderedProduct(w, r)
+			auth(handlers.CreateOrderedProduct, admins...)(w, r)
 		default:
 			http.NotFound(w, r)
 		}
 	}))
 
+	mux.HandleFunc("/api/reorder/suggestions", middleware.WithCORS(auth(handlers.GetReorderSuggestions, viewers...)))
+	mux.HandleFunc("/api/reorder/approve", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			auth(handlers.ApproveReorderSuggestions, admins...)(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+
+	mux.HandleFunc("/api/purchase-orders", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			auth(handlers.GetPurchaseOrders, staff...)(w, r)
+		case http.MethodPost:
+			auth(handlers.CreatePurchaseOrder, admins...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	mux.HandleFunc("/api/purchase-orders/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			auth(handlers.GetPurchaseOrder, staff...)(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+
+	mux.HandleFunc("/api/suppliers/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/prices/") && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+			auth(handlers.SupplierPriceHandler, admins...)(w, r)
+		case r.Method == http.MethodGet:
+			auth(handlers.GetSupplier, viewers...)(w, r)
+		case r.Method == http.MethodPut:
+			auth(handlers.UpdateSupplier, admins...)(w, r)
+		case r.Method == http.MethodDelete:
+			auth(handlers.DeleteSupplier, admins...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
 	mux.HandleFunc("/api/suppliers", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			handlers.GetSuppliers(w, r)
+			auth(handlers.GetSuppliers, viewers...)(w, r)
 		case http.MethodPost:
-			handlers.CreateSupplier(w, r)
+			auth(handlers.CreateSupplier, admins...)(w, r)
 		default:
 			http.NotFound(w, r)
 		}
 	}))
 
-	// mux.HandleFunc("/api/verify", middleware.WithCORS(handlers.VerifyHandler))
+	mux.HandleFunc("/api/verify", middleware.WithCORS(handlers.VerifyHandler))
+	mux.HandleFunc("/api/verify/resend", middleware.WithCORS(handlers.ResendVerificationHandler))
+	mux.HandleFunc("/api/password/forgot", middleware.WithCORS(handlers.ForgotPasswordHandler))
+	mux.HandleFunc("/api/password/reset", middleware.WithCORS(handlers.ResetPasswordHandler))
+
+	mux.HandleFunc("/api/locations", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			auth(handlers.GetLocations, staff...)(w, r)
+		case http.MethodPost:
+			auth(handlers.CreateLocation, admins...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	mux.HandleFunc("/api/locations/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			auth(handlers.GetLocation, staff...)(w, r)
+		case http.MethodPut:
+			auth(handlers.UpdateLocation, admins...)(w, r)
+		case http.MethodDelete:
+			auth(handlers.DeleteLocation, admins...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	mux.HandleFunc("/api/inventory/movements", middleware.WithCORS(auth(handlers.GetInventoryMovements, viewers...)))
+	mux.HandleFunc("/api/inventory/reconcile", middleware.WithCORS(auth(handlers.ReconcileInventory, viewers...)))
+	mux.HandleFunc("/api/expiry-alerts", middleware.WithCORS(auth(handlers.GetExpiryAlerts, viewers...)))
+	mux.HandleFunc("/api/expiry-alerts/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/expiry-alerts/scan":
+			auth(handlers.RunExpiryMonitor, admins...)(w, r)
+		case strings.HasSuffix(r.URL.Path, "/acknowledge") && r.Method == http.MethodPost:
+			auth(handlers.AcknowledgeExpiryAlert, admins...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	mux.HandleFunc("/api/dispose/generate", middleware.WithCORS(auth(handlers.GenerateDisposeTasksHandler, admins...)))
+	mux.HandleFunc("/api/trace", middleware.WithCORS(auth(handlers.TraceLot, viewers...)))
+	mux.HandleFunc("/api/recalls", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			auth(handlers.GetRecalls, viewers...)(w, r)
+		case http.MethodPost:
+			auth(handlers.CreateRecall, admins...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	mux.HandleFunc("/api/recalls/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/close") && r.Method == http.MethodPost:
+			auth(handlers.CloseRecall, admins...)(w, r)
+		case r.Method == http.MethodGet:
+			auth(handlers.GetRecall, viewers...)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	mux.HandleFunc("/api/reports/stock-value", middleware.WithCORS(auth(handlers.GetStockValueReport, viewers...)))
+	mux.HandleFunc("/api/reports/expiry-aging", middleware.WithCORS(auth(handlers.GetExpiryAgingReport, viewers...)))
+	mux.HandleFunc("/api/reports/slow-moving", middleware.WithCORS(auth(handlers.GetSlowMovingReport, viewers...)))
+
+	mux.HandleFunc("/api/tasks/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/reassign") && r.Method == http.MethodPost {
+			auth(handlers.ReassignTask, admins...)(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}))
 
 	mux.HandleFunc("/api/tasks", middleware.WithCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
-			handlers.CreateTask(w, r)
+			auth(handlers.CreateTask, admins...)(w, r)
 			return
 		}
 		http.NotFound(w, r)
 	})))
 
+	mux.HandleFunc("/api/counts", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			auth(handlers.ScheduleCount, admins...)(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	mux.HandleFunc("/api/counts/variances", middleware.WithCORS(auth(handlers.GetCountVariances, viewers...)))
+	mux.HandleFunc("/api/count-items/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			auth(handlers.ReviewCountItem, admins...)(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+
+	mux.HandleFunc("/api/transfers/replenish", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			auth(handlers.RunReplenishment, admins...)(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+
+	mux.HandleFunc("/api/waves", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			auth(handlers.CreateWave, admins...)(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	mux.HandleFunc("/api/waves/", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/pick-list") && r.Method == http.MethodGet {
+			auth(handlers.GetWavePickList, staff...)(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+
 	mux.HandleFunc("/api/users", middleware.WithCORS(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			handlers.GetAllUsers(w, r) // optional
+			auth(handlers.GetAllUsers, viewers...)(w, r) // optional
 		case http.MethodPost:
-			handlers.CreateUser(w, r)
+			auth(handlers.CreateUser, admins...)(w, r)
 		default:
 			http.NotFound(w, r)
 		}
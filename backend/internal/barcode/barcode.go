@@ -0,0 +1,80 @@
+// Package barcode validates product barcodes and encodes and renders the
+// Code 128 and QR symbols printed on batch and bin labels.
+package barcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Symbologies a product barcode can be stored as.
+const (
+	EAN13   = "ean13"
+	UPC     = "upc"
+	Code128 = "code128"
+)
+
+// ErrUnknownSymbology is returned for anything but EAN13, UPC and Code128.
+var ErrUnknownSymbology = errors.New("symbology must be ean13, upc or code128")
+
+// Validate checks code against the rules of its symbology, including the
+// check digit of EAN-13 and UPC-A codes.
+func Validate(code, symbology string) error {
+	switch symbology {
+	case EAN13:
+		return checkDigits(code, 13)
+	case UPC:
+		return checkDigits(code, 12)
+	case Code128:
+		if code == "" || len(code) > 48 {
+			return errors.New("code128 barcode must be 1 to 48 characters")
+		}
+		for _, c := range code {
+			if c < 32 || c > 126 {
+				return errors.New("code128 barcode must be printable ASCII")
+			}
+		}
+		return nil
+	}
+	return ErrUnknownSymbology
+}
+
+// Detect guesses the symbology of a scanned code: 13 digits with a valid
+// check digit are EAN-13, 12 are UPC-A, anything else printable is Code128.
+func Detect(code string) string {
+	if checkDigits(code, 13) == nil {
+		return EAN13
+	}
+	if checkDigits(code, 12) == nil {
+		return UPC
+	}
+	return Code128
+}
+
+// checkDigits validates a GTIN of the given length. Counting from the check
+// digit, digits alternate between weight 1 and 3.
+func checkDigits(code string, length int) error {
+	if len(code) != length {
+		return fmt.Errorf("barcode must be %d digits", length)
+	}
+	sum := 0
+	for i := 0; i < length-1; i++ {
+		c := code[i]
+		if c < '0' || c > '9' {
+			return fmt.Errorf("barcode must be %d digits", length)
+		}
+		d := int(c - '0')
+		if (length-1-i)%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	last := code[length-1]
+	if last < '0' || last > '9' {
+		return fmt.Errorf("barcode must be %d digits", length)
+	}
+	if want := (10 - sum%10) % 10; int(last-'0') != want {
+		return fmt.Errorf("invalid check digit, expected %d", want)
+	}
+	return nil
+}
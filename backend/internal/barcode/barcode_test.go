@@ -0,0 +1,294 @@
+package barcode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		code, symbology string
+		ok              bool
+	}{
+		{"4006381333931", EAN13, true},
+		{"5901234123457", EAN13, true},
+		{"9780201379624", EAN13, true},
+		{"4006381333932", EAN13, false}, // wrong check digit
+		{"400638133393", EAN13, false},  // too short
+		{"400638133393A", EAN13, false},
+		{"036000291452", UPC, true},
+		{"012345678905", UPC, true},
+		{"036000291453", UPC, false},
+		{"03600029145", UPC, false},
+		{"ABC-123", Code128, true},
+		{"", Code128, false},
+		{strings.Repeat("x", 48), Code128, true},
+		{strings.Repeat("x", 49), Code128, false},
+		{"tab\there", Code128, false},
+		{"café", Code128, false},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.code, tt.symbology)
+		if (err == nil) != tt.ok {
+			t.Errorf("Validate(%q, %s) = %v, want ok %v", tt.code, tt.symbology, err, tt.ok)
+		}
+	}
+
+	if err := Validate("4006381333931", "qr"); !errors.Is(err, ErrUnknownSymbology) {
+		t.Errorf("Validate with unknown symbology = %v, want ErrUnknownSymbology", err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct{ code, want string }{
+		{"4006381333931", EAN13},
+		{"036000291452", UPC},
+		{"4006381333932", Code128},
+		{"BATCH-42", Code128},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.code); got != tt.want {
+			t.Errorf("Detect(%q) = %s, want %s", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestCode128Widths(t *testing.T) {
+	for i, w := range code128Widths {
+		want := 11
+		if i == code128Stop {
+			want = 13
+		}
+		sum := 0
+		for _, c := range w {
+			sum += int(c - '0')
+		}
+		if sum != want {
+			t.Errorf("symbol %d is %d modules wide, want %d", i, sum, want)
+		}
+	}
+}
+
+// decodeCode128 turns modules back into symbol values.
+func decodeCode128(t *testing.T, modules []bool) []int {
+	t.Helper()
+	lookup := make(map[string]int)
+	for i, w := range code128Widths {
+		lookup[w] = i
+	}
+	var symbols []int
+	var widths strings.Builder
+	run := 1
+	for i := 1; i <= len(modules); i++ {
+		if i < len(modules) && modules[i] == modules[i-1] {
+			run++
+			continue
+		}
+		widths.WriteByte(byte('0' + run))
+		run = 1
+		w := widths.String()
+		if s, ok := lookup[w]; ok && (len(w) == 6 && s != code128Stop || len(w) == 7) {
+			symbols = append(symbols, s)
+			widths.Reset()
+		}
+	}
+	if widths.Len() > 0 {
+		t.Fatalf("trailing modules %q don't form a symbol", widths.String())
+	}
+	return symbols
+}
+
+func TestEncodeCode128(t *testing.T) {
+	tests := []struct {
+		data    string
+		symbols []int // start code, data and check symbol; the stop code is implied
+	}{
+		// Code set B throughout: the digit run is too short for C.
+		{"PJJ123C", []int{104, 48, 42, 42, 17, 18, 19, 35, 55}},
+		// Leading run of six digits starts in C.
+		{"123456", []int{105, 12, 34, 56, 44}},
+		// An odd leading run starts in B and switches to C for the pairs.
+		{"12345", []int{104, 17, 99, 23, 45, 53}},
+		// Four trailing digits switch to C.
+		{"AB1234", []int{104, 33, 34, 99, 12, 34, 102}},
+	}
+	for _, tt := range tests {
+		modules, err := EncodeCode128(tt.data)
+		if err != nil {
+			t.Errorf("EncodeCode128(%q): %v", tt.data, err)
+			continue
+		}
+		if !modules[0] || !modules[len(modules)-1] {
+			t.Errorf("EncodeCode128(%q) doesn't start and end with a bar", tt.data)
+		}
+		got := decodeCode128(t, modules)
+		want := append(append([]int(nil), tt.symbols...), code128Stop)
+		if !equalInts(got, want) {
+			t.Errorf("EncodeCode128(%q) = symbols %v, want %v", tt.data, got, want)
+		}
+	}
+
+	for _, bad := range []string{"", "naïve", strings.Repeat("9", 49)} {
+		if _, err := EncodeCode128(bad); err == nil {
+			t.Errorf("EncodeCode128(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReedSolomon(t *testing.T) {
+	// Version 1-M "HELLO WORLD" from the worked example of the QR standard.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	got := rsRemainder(data, rsDivisor(len(want)))
+	if string(got) != string(want) {
+		t.Errorf("error correction = %v, want %v", got, want)
+	}
+}
+
+// formatBitsM are the 15-bit format strings for level M by mask.
+var formatBitsM = [8]int{
+	0b101010000010010, 0b101000100100101, 0b101111001111100, 0b101101101001011,
+	0b100010111111001, 0b100000011001110, 0b100111110010111, 0b100101010100000,
+}
+
+func TestEncodeQR(t *testing.T) {
+	tests := []struct {
+		data string
+		size int
+	}{
+		{"B-42", 21},
+		{strings.Repeat("x", 14), 21}, // largest version 1
+		{strings.Repeat("x", 15), 25},
+		{strings.Repeat("x", 107), 45}, // version 7 carries version information
+		{strings.Repeat("x", 213), 57}, // largest version 10
+	}
+	for _, tt := range tests {
+		m, err := EncodeQR(tt.data)
+		if err != nil {
+			t.Errorf("EncodeQR(%d bytes): %v", len(tt.data), err)
+			continue
+		}
+		if len(m) != tt.size || len(m[0]) != tt.size {
+			t.Errorf("EncodeQR(%d bytes) is %dx%d, want %dx%d", len(tt.data), len(m), len(m[0]), tt.size, tt.size)
+			continue
+		}
+		checkFinders(t, m)
+		checkFormat(t, m)
+		if tt.size >= 45 {
+			checkVersion(t, m, (tt.size-17)/4)
+		}
+	}
+
+	if _, err := EncodeQR(strings.Repeat("x", 214)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("EncodeQR(214 bytes) = %v, want ErrTooLong", err)
+	}
+}
+
+func checkFinders(t *testing.T, m [][]bool) {
+	t.Helper()
+	n := len(m)
+	for _, c := range [][2]int{{0, 0}, {n - 7, 0}, {0, n - 7}} {
+		for dy := 0; dy < 7; dy++ {
+			for dx := 0; dx < 7; dx++ {
+				d := max(abs(dx-3), abs(dy-3))
+				if want := d != 2; m[c[1]+dy][c[0]+dx] != want {
+					t.Fatalf("finder pattern at %v is wrong at %d,%d", c, dx, dy)
+				}
+			}
+		}
+	}
+	for i := 8; i < n-8; i++ {
+		if m[6][i] != (i%2 == 0) || m[i][6] != (i%2 == 0) {
+			t.Fatalf("timing pattern is wrong at %d", i)
+		}
+	}
+	if !m[n-8][8] {
+		t.Fatal("dark module missing")
+	}
+}
+
+// checkFormat reads both copies of the format information back and checks
+// them against the table for level M.
+func checkFormat(t *testing.T, m [][]bool) {
+	t.Helper()
+	n := len(m)
+	at := func(x, y int) int {
+		if m[y][x] {
+			return 1
+		}
+		return 0
+	}
+	var a, b int
+	for i := 0; i <= 5; i++ {
+		a |= at(8, i) << i
+	}
+	a |= at(8, 7)<<6 | at(8, 8)<<7 | at(7, 8)<<8
+	for i := 9; i < 15; i++ {
+		a |= at(14-i, 8) << i
+	}
+	for i := 0; i < 8; i++ {
+		b |= at(n-1-i, 8) << i
+	}
+	for i := 8; i < 15; i++ {
+		b |= at(8, n-15+i) << i
+	}
+	if a != b {
+		t.Fatalf("format copies differ: %015b and %015b", a, b)
+	}
+	mask := (a ^ 0x5412) >> 10 & 7
+	if a != formatBitsM[mask] {
+		t.Fatalf("format bits %015b, want %015b for mask %d", a, formatBitsM[mask], mask)
+	}
+}
+
+func checkVersion(t *testing.T, m [][]bool, version int) {
+	t.Helper()
+	// Version information from the table of the QR standard.
+	want := map[int]int{7: 0x07C94, 8: 0x085BC, 9: 0x09A99, 10: 0x0A4D3}[version]
+	n := len(m)
+	var a, b int
+	for i := 0; i < 18; i++ {
+		x, y := n-11+i%3, i/3
+		if m[y][x] {
+			a |= 1 << i
+		}
+		if m[x][y] {
+			b |= 1 << i
+		}
+	}
+	if a != want || b != want {
+		t.Fatalf("version %d information %018b and %018b, want %018b", version, a, b, want)
+	}
+}
+
+func TestImages(t *testing.T) {
+	img, err := Code128Image("B-42", 2, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modules, _ := EncodeCode128("B-42")
+	if w := img.Bounds().Dx(); w != (len(modules)+2*Code128QuietZone)*2 {
+		t.Errorf("Code128Image width %d", w)
+	}
+
+	img, err = QRImage("B-42", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != (21+2*QRQuietZone)*3 || b.Dy() != b.Dx() {
+		t.Errorf("QRImage bounds %v", b)
+	}
+}
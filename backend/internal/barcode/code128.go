@@ -0,0 +1,100 @@
+package barcode
+
+import "errors"
+
+// code128Widths holds the bar/space widths of every Code 128 symbol, starting
+// with a bar. 103-105 are the start codes, 106 the stop code.
+var code128Widths = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128CodeC  = 99
+	code128CodeB  = 100
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// EncodeCode128 encodes data and returns its modules, true for a bar, without the
+// quiet zone. Runs of digits are packed in pairs using code set C.
+func EncodeCode128(data string) ([]bool, error) {
+	if err := Validate(data, Code128); err != nil {
+		return nil, err
+	}
+
+	digitRun := func(i int) int {
+		n := 0
+		for i+n < len(data) && data[i+n] >= '0' && data[i+n] <= '9' {
+			n++
+		}
+		return n
+	}
+
+	var symbols []int
+	set := 0
+	for i := 0; i < len(data); {
+		// Code set C pays off for 4 digits at either end or 6 in the middle.
+		if run := digitRun(i); set != code128StartC &&
+			(run >= 6 || run >= 4 && (i == 0 || i+run == len(data))) {
+			if run%2 == 1 && i == 0 {
+				// An odd leading run starts in B so the pairs line up at the end.
+				symbols = append(symbols, code128StartB, int(data[0])-32)
+				set = code128StartB
+				i++
+				continue
+			}
+			if set == 0 {
+				symbols = append(symbols, code128StartC)
+			} else {
+				symbols = append(symbols, code128CodeC)
+			}
+			set = code128StartC
+		}
+
+		if set == code128StartC {
+			if digitRun(i) >= 2 {
+				symbols = append(symbols, int(data[i]-'0')*10+int(data[i+1]-'0'))
+				i += 2
+				continue
+			}
+			symbols = append(symbols, code128CodeB)
+			set = code128StartB
+		}
+		if set == 0 {
+			symbols = append(symbols, code128StartB)
+			set = code128StartB
+		}
+		symbols = append(symbols, int(data[i])-32)
+		i++
+	}
+
+	sum := symbols[0]
+	for i, s := range symbols[1:] {
+		sum += (i + 1) * s
+	}
+	symbols = append(symbols, sum%103, code128Stop)
+
+	var modules []bool
+	for _, s := range symbols {
+		for i, width := range code128Widths[s] {
+			for n := 0; n < int(width-'0'); n++ {
+				modules = append(modules, i%2 == 0)
+			}
+		}
+	}
+	if len(modules) != len(symbols)*11+2 {
+		return nil, errors.New("code128: internal encoding error")
+	}
+	return modules, nil
+}
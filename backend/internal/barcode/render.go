@@ -0,0 +1,68 @@
+package barcode
+
+import (
+	"image"
+	"image/color"
+)
+
+// Quiet zones required around each symbol, in modules.
+const (
+	Code128QuietZone = 10
+	QRQuietZone      = 4
+)
+
+// Code128Image renders data as a Code 128 symbol with its quiet zone, each
+// module scale pixels wide and the bars height pixels tall.
+func Code128Image(data string, scale, height int) (image.Image, error) {
+	modules, err := EncodeCode128(data)
+	if err != nil {
+		return nil, err
+	}
+	width := (len(modules) + 2*Code128QuietZone) * scale
+	img := blank(width, height)
+	for i, bar := range modules {
+		if !bar {
+			continue
+		}
+		x0 := (i + Code128QuietZone) * scale
+		for y := 0; y < height; y++ {
+			for x := x0; x < x0+scale; x++ {
+				img.SetGray(x, y, color.Gray{})
+			}
+		}
+	}
+	return img, nil
+}
+
+// QRImage renders data as a QR code with its quiet zone, each module a
+// scale × scale pixel square.
+func QRImage(data string, scale int) (image.Image, error) {
+	matrix, err := EncodeQR(data)
+	if err != nil {
+		return nil, err
+	}
+	side := (len(matrix) + 2*QRQuietZone) * scale
+	img := blank(side, side)
+	for row, line := range matrix {
+		for col, dark := range line {
+			if !dark {
+				continue
+			}
+			x0, y0 := (col+QRQuietZone)*scale, (row+QRQuietZone)*scale
+			for y := y0; y < y0+scale; y++ {
+				for x := x0; x < x0+scale; x++ {
+					img.SetGray(x, y, color.Gray{})
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+func blank(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	return img
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/internal/db"
+)
+
+// allocatedLine is one batch picked for an item by the FEFO allocator.
+type allocatedLine struct {
+	batchLine
+	ProductID      int     `json:"productID"`
+	ProductName    string  `json:"productName"`
+	ExpirationDate *string `json:"expirationDate"`
+}
+
+// shortfall is the part of an item the allocator could not cover.
+type shortfall struct {
+	ItemID      int     `json:"itemID"`
+	ProductID   int     `json:"productID"`
+	ProductName string  `json:"productName"`
+	Required    float64 `json:"required"`
+	Allocated   float64 `json:"allocated"`
+	Missing     float64 `json:"missing"`
+}
+
+type allocation struct {
+	Lines      []allocatedLine `json:"lines"`
+	Shortfalls []shortfall     `json:"shortfalls"`
+}
+
+// allocateFEFO proposes batches for every item of the request, earliest
+// expiration first. Expired batches and batches inside the product's
+// shortExpirationDate window are skipped; batches without an expiration date
+// come last. The stock rows are locked but nothing is written.
+func allocateFEFO(tx *sql.Tx, requestID int) (allocation, error) {
+	var out allocation
+
+	type item struct {
+		id, productID int
+		productName   string
+		required      float64
+		shortDays     int
+	}
+	var items []item
+	rows, err := tx.Query(`
+		SELECT i.id, i.productID, p.productName, i.quantity, COALESCE(p.shortExpirationDate, 0)
+		FROM purchase_items i
+		JOIN products p ON p.id = i.productID
+		WHERE i.requestID = ?
+		ORDER BY i.id
+	`, requestID)
+	if err != nil {
+		return out, err
+	}
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.id, &it.productID, &it.productName, &it.required, &it.shortDays); err != nil {
+			rows.Close()
+			return out, err
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return out, err
+	}
+
+	// Remaining quantity per batch, shared by items of the same product.
+	remaining := make(map[int]float64)
+
+	for _, it := range items {
+		batchRows, err := tx.Query(`
+			SELECT batchID, quantity, expirationDate
+			FROM stock
+			WHERE productID = ?
+			  AND quantity > 0
+			  AND blocked = FALSE
+			  AND (expirationDate IS NULL OR expirationDate > DATE_ADD(CURDATE(), INTERVAL ? DAY))
+			ORDER BY (expirationDate IS NULL), expirationDate, batchID
+			FOR UPDATE
+		`, it.productID, it.shortDays)
+		if err != nil {
+			return out, err
+		}
+
+		need := it.required
+		for need > quantityEpsilon && batchRows.Next() {
+			var batchID int
+			var qty float64
+			var exp sql.NullTime
+			if err := batchRows.Scan(&batchID, &qty, &exp); err != nil {
+				batchRows.Close()
+				return out, err
+			}
+			if _, ok := remaining[batchID]; !ok {
+				remaining[batchID] = qty
+			}
+			take := remaining[batchID]
+			if take <= quantityEpsilon {
+				continue
+			}
+			if take > need {
+				take = need
+			}
+			remaining[batchID] -= take
+			need -= take
+
+			line := allocatedLine{
+				batchLine:   batchLine{ItemID: it.id, BatchID: batchID, Quantity: take},
+				ProductID:   it.productID,
+				ProductName: it.productName,
+			}
+			if exp.Valid {
+				str := exp.Time.Format("2006-01-02")
+				line.ExpirationDate = &str
+			}
+			out.Lines = append(out.Lines, line)
+		}
+		batchRows.Close()
+		if err := batchRows.Err(); err != nil {
+			return out, err
+		}
+
+		if need > quantityEpsilon {
+			out.Shortfalls = append(out.Shortfalls, shortfall{
+				ItemID:      it.id,
+				ProductID:   it.productID,
+				ProductName: it.productName,
+				Required:    it.required,
+				Allocated:   it.required - need,
+				Missing:     need,
+			})
+		}
+	}
+	return out, nil
+}
+
+// POST /api/purchase-requests/{id}/auto-assign[?dryRun=true]
+func AutoAssignBatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/purchase-requests/")
+	idStr = strings.TrimSuffix(idStr, "/auto-assign")
+	requestID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM purchase_requests WHERE id = ? FOR UPDATE`, requestID).Scan(&status); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to load request", http.StatusInternalServerError)
+		return
+	}
+	if status != "pending" {
+		http.Error(w, "Only pending requests can be reassigned", http.StatusConflict)
+		return
+	}
+
+	// The proposal replaces the current assignment, so its stock counts as
+	// available. On a dry run the rollback puts everything back.
+	if err := releaseReservations(tx, requestID, actorID(r)); err != nil {
+		log.Printf("AutoAssignBatches release error: %v", err)
+		http.Error(w, "Failed to clear previous assignments", http.StatusInternalServerError)
+		return
+	}
+
+	proposal, err := allocateFEFO(tx, requestID)
+	if err != nil {
+		log.Printf("AutoAssignBatches allocate error: %v", err)
+		http.Error(w, "Failed to allocate batches", http.StatusInternalServerError)
+		return
+	}
+
+	if !dryRun {
+		lines := make([]batchLine, len(proposal.Lines))
+		for i, l := range proposal.Lines {
+			lines[i] = l.batchLine
+		}
+		problems, err := reserveBatches(tx, requestID, lines, actorID(r))
+		if err != nil || len(problems) > 0 {
+			log.Printf("AutoAssignBatches reserve error: %v %v", err, problems)
+			http.Error(w, "Failed to assign batches", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to commit assignments", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"requestID":  requestID,
+		"dryRun":     dryRun,
+		"lines":      proposal.Lines,
+		"shortfalls": proposal.Shortfalls,
+	})
+}
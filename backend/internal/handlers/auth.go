@@ -3,13 +3,20 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"backend/internal/db"
 	"backend/internal/mail"
+	"backend/internal/middleware"
 	"backend/internal/models"
+	"backend/internal/session"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -51,20 +58,85 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, expires, err := session.Create(userID)
+	if err != nil {
+		log.Printf("Session create failed: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
 	json.NewEncoder(w).Encode(struct {
-		Message string `json:"message"`
-		Role    string `json:"role"`
-		UserID  int    `json:"userId"`
-		Email   string `json:"email"`
+		Message   string `json:"message"`
+		Role      string `json:"role"`
+		UserID    int    `json:"userId"`
+		Email     string `json:"email"`
+		Token     string `json:"token"`
+		ExpiresAt string `json:"expiresAt"`
 	}{
-		Message: "Login successful",
-		Role:    role,
-		UserID:  userID,
-		Email:   creds.Email,
+		Message:   "Login successful",
+		Role:      role,
+		UserID:    userID,
+		Email:     creds.Email,
+		Token:     token,
+		ExpiresAt: expires.Format(time.RFC3339),
+	})
+
+}
+
+// POST /api/logout
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if token := middleware.TokenFromRequest(r); token != "" {
+		if err := session.Delete(token); err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
 	})
+	json.NewEncoder(w).Encode(models.Response{Message: "Logged out"})
+}
+
+// canAccessUser reports whether the caller may read or act on data owned by
+// userID. Admins and the read-only demo account may see everyone's data.
+func canAccessUser(r *http.Request, userID int) bool {
+	id, ok := middleware.CurrentUser(r)
+	if !ok {
+		return false
+	}
+	if id.Role == models.RoleAdmin || (id.Role == models.RoleDemo && r.Method == http.MethodGet) {
+		return true
+	}
+	return id.UserID == userID
+}
 
+// countsBlind reports whether the caller is a worker. Workers count stock
+// blind, so stock quantities are left out of what they can look up.
+func countsBlind(r *http.Request) bool {
+	id, _ := middleware.CurrentUser(r)
+	return id.Role == models.RoleWorker
 }
 
+// POST /api/register
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
@@ -76,6 +148,10 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	if creds.Email == "" || creds.Password == "" {
+		http.Error(w, "Missing fields", http.StatusBadRequest)
+		return
+	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -83,19 +159,156 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The user row and its verification email are committed together through
+	// the mail outbox, so a duplicate never gets a link and an SMTP outage
+	// only delays delivery instead of failing registration.
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	token := uuid.New().String()
-	if err := mail.SendVerificationEmail(creds.Email, token); err != nil {
+	_, err = tx.Exec(
+		`INSERT INTO users (email, password, verification_token, verification_expires_at)
+		 VALUES (?, ?, ?, ?)`,
+		creds.Email, hash, session.HashToken(token), time.Now().Add(verificationTTL()),
+	)
+	if err != nil {
+		if isDuplicateKey(err) {
+			http.Error(w, "User already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := queueVerificationEmail(tx, creds.Email, token); err != nil {
 		log.Printf("Email failed: %v", err)
 		http.Error(w, "Failed to send verification", http.StatusInternalServerError)
 		return
 	}
 
-	_, err = db.DB.Exec("INSERT INTO users (email, password, verification_token) VALUES (?, ?, ?)",
-		creds.Email, hash, token)
-	if err != nil {
-		http.Error(w, "User exists or DB error", http.StatusBadRequest)
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
 	json.NewEncoder(w).Encode(models.Response{Message: "Check your email to verify your account."})
 }
+
+// GET /api/verify?token={token}
+func VerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+
+	// Only the token's hash is stored, like session and reset tokens.
+	// Clearing it in the same statement makes the link single-use.
+	res, err := db.DB.Exec(`
+		UPDATE users
+		   SET verified = TRUE,
+		       verification_token = NULL,
+		       verification_expires_at = NULL
+		 WHERE verification_token = ?
+		   AND verified = FALSE
+		   AND verification_expires_at > NOW()
+	`, session.HashToken(token))
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Email verified, you can now log in."})
+}
+
+// POST /api/verify/resend
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	// Same answer whether or not the account exists or is already verified.
+	resp := models.Response{Message: "If the account is awaiting verification, a new link has been sent."}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	token := uuid.New().String()
+	res, err := tx.Exec(`
+		UPDATE users
+		   SET verification_token = ?, verification_expires_at = ?
+		 WHERE email = ? AND verified = FALSE
+	`, session.HashToken(token), time.Now().Add(verificationTTL()), req.Email)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if err := queueVerificationEmail(tx, req.Email, token); err != nil {
+		log.Printf("Email failed: %v", err)
+		http.Error(w, "Failed to send verification", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func queueVerificationEmail(tx *sql.Tx, email, token string) error {
+	return mail.Enqueue(tx, "verification", email, map[string]interface{}{
+		"Token":   token,
+		"Expires": humanDuration(verificationTTL()),
+	})
+}
+
+func verificationTTL() time.Duration {
+	if h, err := strconv.Atoi(os.Getenv("VERIFICATION_TTL_HOURS")); err == nil && h > 0 {
+		return time.Duration(h) * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func isDuplicateKey(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == 1062
+}
+
+// isMissingReference reports a foreign key pointing at a row that doesn't exist.
+func isMissingReference(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == 1452
+}
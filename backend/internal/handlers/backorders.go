@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"strconv"
+
+	"backend/internal/inventory"
+	"backend/internal/money"
+)
+
+// createBackorders records the uncovered remainder of every item of an
+// accepted request as an open backorder.
+func createBackorders(tx *sql.Tx, requestID int, coverage []itemCoverage) error {
+	for _, c := range coverage {
+		missing := c.Required - c.Assigned
+		if missing <= quantityEpsilon {
+			continue
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO backorders (requestID, itemID, productID, quantity) VALUES (?, ?, ?, ?)`,
+			requestID, c.ItemID, c.ProductID, missing,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fulfillBackorders serves open backorders for the product from a freshly
+// received batch, oldest first. Each original request that gets something
+// receives one accepted child request, linked through parentRequestID, with
+// the reserved quantities and its own prepare task.
+func fulfillBackorders(tx *sql.Tx, productID, batchID, actorID int) error {
+	// Batches already inside the short-expiry window are not shipped to
+	// customers, the same rule the FEFO allocator applies.
+	var available float64
+	err := tx.QueryRow(`
+		SELECT s.quantity
+		FROM stock s
+		JOIN products p ON p.id = s.productID
+		WHERE s.batchID = ? AND s.blocked = FALSE
+		  AND (s.expirationDate IS NULL
+		       OR s.expirationDate > DATE_ADD(CURDATE(), INTERVAL COALESCE(p.shortExpirationDate, 0) DAY))
+		FOR UPDATE
+	`, batchID).Scan(&available)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	type open struct {
+		id, requestID, userID int
+		remaining             float64
+	}
+	rows, err := tx.Query(`
+		SELECT b.id, b.requestID, pr.userID, b.quantity - b.fulfilled
+		FROM backorders b
+		JOIN purchase_requests pr ON pr.id = b.requestID
+		WHERE b.productID = ? AND b.status = 'open'
+		ORDER BY b.created_at, b.id
+		FOR UPDATE
+	`, productID)
+	if err != nil {
+		return err
+	}
+	var backorders []open
+	for rows.Next() {
+		var o open
+		if err := rows.Scan(&o.id, &o.requestID, &o.userID, &o.remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		backorders = append(backorders, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	children := make(map[int]int64)
+	for _, o := range backorders {
+		if available <= quantityEpsilon {
+			break
+		}
+		// Order lines hold whole units, so the child only takes whole units;
+		// a fractional remainder stays open rather than being truncated.
+		take := math.Floor(math.Min(o.remaining, available) + quantityEpsilon)
+		if take < 1 {
+			continue
+		}
+
+		childID, ok := children[o.requestID]
+		if !ok {
+			res, err := tx.Exec(
+				`INSERT INTO purchase_requests (userID, status, parentRequestID) VALUES (?, 'accepted', ?)`,
+				o.userID, o.requestID,
+			)
+			if err != nil {
+				return err
+			}
+			childID, _ = res.LastInsertId()
+			children[o.requestID] = childID
+
+			note := "backorder of request " + strconv.Itoa(o.requestID)
+			if err := recordRequestEvent(tx, int(childID), "", "accepted", actorID, note); err != nil {
+				return err
+			}
+		}
+
+		// The child ships at the price of the original order line.
+		res, err := tx.Exec(`
+			INSERT INTO purchase_items (requestID, productID, quantity, unitPrice, backorderID)
+			SELECT ?, ?, ?, pi.unitPrice, ? FROM backorders b JOIN purchase_items pi ON pi.id = b.itemID WHERE b.id = ?
+		`, childID, productID, take, o.id, o.id)
+		if err != nil {
+			return err
+		}
+		itemID, _ := res.LastInsertId()
+
+		if _, err := tx.Exec(`INSERT INTO assigned_batches (itemID, batchID, quantity) VALUES (?, ?, ?)`,
+			itemID, batchID, take); err != nil {
+			return err
+		}
+		doc := inventory.Doc{Type: "purchase_request", ID: int(childID)}
+		if err := inventory.Reserve(tx, batchID, take, doc, actorID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			UPDATE backorders
+			   SET fulfilled = fulfilled + ?,
+			       status = IF(quantity - fulfilled <= ?, 'fulfilled', 'open')
+			 WHERE id = ?
+		`, take, quantityEpsilon, o.id); err != nil {
+			return err
+		}
+		available -= take
+	}
+
+	for parentID, childID := range children {
+		if err := priceChildRequest(tx, childID, parentID); err != nil {
+			return err
+		}
+		if err := createPrepareTask(tx, int(childID)); err != nil {
+			return err
+		}
+		log.Printf("Backorders of request %d shipped as request %d", parentID, childID)
+	}
+	return nil
+}
+
+// reopenBackorders puts what a cancelled or denied backorder child request
+// was to ship back on its parent's backorders, so a later delivery serves
+// them again. Backorders cancelled along with their parent stay cancelled.
+func reopenBackorders(tx *sql.Tx, requestID int) error {
+	_, err := tx.Exec(`
+		UPDATE backorders b
+		JOIN (SELECT backorderID, SUM(quantity) AS quantity
+		        FROM purchase_items
+		       WHERE requestID = ? AND backorderID IS NOT NULL
+		       GROUP BY backorderID) c ON c.backorderID = b.id
+		   SET b.fulfilled = GREATEST(b.fulfilled - c.quantity, 0),
+		       b.status = 'open'
+		 WHERE b.status <> 'cancelled'
+	`, requestID)
+	return err
+}
+
+// priceChildRequest stores the totals of a backorder child request: its
+// lines at the original prices, taxed at the rate the parent was placed at.
+// Children of requests from before orders were priced stay unpriced.
+func priceChildRequest(tx *sql.Tx, childID int64, parentID int) error {
+	var rate money.NullAmount
+	if err := tx.QueryRow(`SELECT taxRate FROM purchase_requests WHERE id = ?`, parentID).Scan(&rate); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`SELECT unitPrice, quantity FROM purchase_items WHERE requestID = ?`, childID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var subtotal money.Amount
+	for rows.Next() {
+		var price money.NullAmount
+		var qty int
+		if err := rows.Scan(&price, &qty); err != nil {
+			return err
+		}
+		if !price.Valid {
+			return nil
+		}
+		subtotal += price.Amount.Times(qty)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	r := taxRate()
+	if rate.Valid {
+		r = money.Rate(rate.Amount)
+	}
+	t := priceOrder(subtotal, r)
+	_, err = tx.Exec(`UPDATE purchase_requests SET subtotal = ?, taxRate = ?, tax = ?, total = ? WHERE id = ?`,
+		t.Subtotal, t.TaxRate, t.Tax, t.Total, childID)
+	return err
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/internal/db"
+	"backend/internal/money"
+)
+
+// cartLine is a product in the signed-in user's cart at today's price.
+// Problem is set when the line can't be ordered as it stands.
+type cartLine struct {
+	ProductID   int          `json:"productID"`
+	SKU         *string      `json:"sku"`
+	ProductName string       `json:"productName"`
+	Image       *string      `json:"image"`
+	Quantity    int          `json:"quantity"`
+	UnitPrice   money.Amount `json:"unitPrice"`
+	LineTotal   money.Amount `json:"lineTotal"`
+	Available   float64      `json:"available"`
+	Problem     string       `json:"problem,omitempty"`
+}
+
+type cart struct {
+	Items []cartLine `json:"items"`
+	orderTotals
+}
+
+func loadCart(q queryer, userID int) (*cart, error) {
+	rows, err := q.Query(`
+		SELECT c.productID, p.sku, p.productName, p.image, c.quantity, p.price, md.price, COALESCE(md.quantity, 0),
+		       p.archived, `+availableStock+`
+		FROM cart_items c
+		JOIN products p ON p.id = c.productID
+		`+markdownStock+`
+		WHERE c.userID = ?
+		ORDER BY c.added_at, c.productID
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	c := &cart{Items: []cartLine{}}
+	var subtotal money.Amount
+	for rows.Next() {
+		var l cartLine
+		var sku, image sql.NullString
+		var archived bool
+		var price money.Amount
+		var markdown money.NullAmount
+		var markdownQty float64
+		if err := rows.Scan(&l.ProductID, &sku, &l.ProductName, &image, &l.Quantity, &price, &markdown, &markdownQty,
+			&archived, &l.Available); err != nil {
+			return nil, err
+		}
+		l.UnitPrice = salePrice(price, markdown, markdownQty, l.Quantity)
+		if sku.Valid {
+			l.SKU = &sku.String
+		}
+		if image.Valid {
+			l.Image = &image.String
+		}
+		l.LineTotal = l.UnitPrice.Times(l.Quantity)
+		switch {
+		case archived:
+			l.Problem = "product is no longer sold"
+		case float64(l.Quantity) > l.Available+quantityEpsilon:
+			l.Problem = fmt.Sprintf("only %g in stock", l.Available)
+		}
+		subtotal += l.LineTotal
+		c.Items = append(c.Items, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	c.orderTotals = priceOrder(subtotal, taxRate())
+	return c, nil
+}
+
+// checkCartQuantity reports why qty of a product can't be in a cart, or ""
+// when it can.
+func checkCartQuantity(q queryer, productID, qty int) (string, error) {
+	if qty <= 0 {
+		return "quantity must be positive", nil
+	}
+	var archived bool
+	var available float64
+	err := q.QueryRow(`SELECT p.archived, `+availableStock+` FROM products p WHERE p.id = ?`, productID).
+		Scan(&archived, &available)
+	if err == sql.ErrNoRows {
+		return fmt.Sprintf("product %d does not exist", productID), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if archived {
+		return fmt.Sprintf("product %d is archived", productID), nil
+	}
+	if float64(qty) > available+quantityEpsilon {
+		return fmt.Sprintf("only %g in stock", available), nil
+	}
+	return "", nil
+}
+
+func writeCart(w http.ResponseWriter, userID int) {
+	c, err := loadCart(db.DB, userID)
+	if err != nil {
+		log.Printf("Cart error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// GET /api/cart
+func GetCart(w http.ResponseWriter, r *http.Request) {
+	writeCart(w, actorID(r))
+}
+
+// DELETE /api/cart
+func ClearCart(w http.ResponseWriter, r *http.Request) {
+	if _, err := db.DB.Exec(`DELETE FROM cart_items WHERE userID = ?`, actorID(r)); err != nil {
+		http.Error(w, "Failed to clear cart", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/cart/items
+//
+// Body: {productID, quantity}. Adds to the quantity already in the cart.
+func AddCartItem(w http.ResponseWriter, r *http.Request) {
+	var payload orderLine
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if payload.Quantity <= 0 {
+		http.Error(w, "quantity must be positive", http.StatusBadRequest)
+		return
+	}
+	userID := actorID(r)
+
+	var inCart int
+	err := db.DB.QueryRow(`SELECT quantity FROM cart_items WHERE userID = ? AND productID = ?`,
+		userID, payload.ProductID).Scan(&inCart)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	msg, err := checkCartQuantity(db.DB, payload.ProductID, inCart+payload.Quantity)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if msg != "" {
+		http.Error(w, msg, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if _, err := db.DB.Exec(`
+		INSERT INTO cart_items (userID, productID, quantity) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity)
+	`, userID, payload.ProductID, payload.Quantity); err != nil {
+		http.Error(w, "Failed to add to cart", http.StatusInternalServerError)
+		return
+	}
+	writeCart(w, userID)
+}
+
+// PUT /api/cart/items/{productID}
+//
+// Body: {quantity}. A quantity of 0 removes the line.
+func UpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/cart/items/"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Quantity int `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if payload.Quantity < 0 {
+		http.Error(w, "quantity can't be negative", http.StatusBadRequest)
+		return
+	}
+	userID := actorID(r)
+
+	if payload.Quantity == 0 {
+		if _, err := db.DB.Exec(`DELETE FROM cart_items WHERE userID = ? AND productID = ?`, userID, productID); err != nil {
+			http.Error(w, "Failed to update cart", http.StatusInternalServerError)
+			return
+		}
+		writeCart(w, userID)
+		return
+	}
+
+	msg, err := checkCartQuantity(db.DB, productID, payload.Quantity)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if msg != "" {
+		http.Error(w, msg, http.StatusUnprocessableEntity)
+		return
+	}
+	if _, err := db.DB.Exec(`
+		INSERT INTO cart_items (userID, productID, quantity) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE quantity = VALUES(quantity)
+	`, userID, productID, payload.Quantity); err != nil {
+		http.Error(w, "Failed to update cart", http.StatusInternalServerError)
+		return
+	}
+	writeCart(w, userID)
+}
+
+// DELETE /api/cart/items/{productID}
+func RemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/cart/items/"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	userID := actorID(r)
+	res, err := db.DB.Exec(`DELETE FROM cart_items WHERE userID = ? AND productID = ?`, userID, productID)
+	if err != nil {
+		http.Error(w, "Failed to update cart", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Product not in cart", http.StatusNotFound)
+		return
+	}
+	writeCart(w, userID)
+}
+
+// POST /api/cart/checkout
+//
+// Turns the cart into a pending purchase request at today's prices and
+// empties it. Nothing is ordered while any line has a problem.
+func CheckoutCart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := actorID(r)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the cart so a second checkout waits and then finds it empty.
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM cart_items WHERE userID = ? FOR UPDATE`, userID).Scan(&count); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	c, err := loadCart(tx, userID)
+	if err != nil {
+		log.Printf("Checkout error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(c.Items) == 0 {
+		http.Error(w, "Cart is empty", http.StatusConflict)
+		return
+	}
+
+	var problems []lineError
+	lines := make([]orderLine, len(c.Items))
+	for i, l := range c.Items {
+		if l.Problem != "" {
+			problems = append(problems, lineError{Line: i, Error: fmt.Sprintf("%s: %s", l.ProductName, l.Problem)})
+		}
+		lines[i] = orderLine{ProductID: l.ProductID, Quantity: l.Quantity}
+	}
+	var requestID int64
+	var totals *orderTotals
+	if len(problems) == 0 {
+		if requestID, totals, problems, err = insertPurchaseRequest(tx, userID, lines, userID); err != nil {
+			log.Printf("Checkout error: %v", err)
+			http.Error(w, "Failed to create request", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Cart can't be ordered",
+			"lines": problems,
+		})
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM cart_items WHERE userID = ?`, userID); err != nil {
+		http.Error(w, "Failed to empty cart", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+		ID      int64  `json:"id"`
+		*orderTotals
+	}{"Purchase request submitted", requestID, totals})
+}
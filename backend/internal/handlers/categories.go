@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"backend/internal/db"
+)
+
+// Category is a node in the product category tree.
+type Category struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID *int   `json:"parentId"`
+	Path     string `json:"path"`
+}
+
+// categoryTree holds the whole tree; it is small enough to load at once.
+type categoryTree map[int]*Category
+
+func loadCategories(q queryer) (categoryTree, error) {
+	rows, err := q.Query(`SELECT id, name, parentID FROM categories`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tree := make(categoryTree)
+	for rows.Next() {
+		c := &Category{}
+		var parent sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.Name, &parent); err != nil {
+			return nil, err
+		}
+		if parent.Valid {
+			p := int(parent.Int64)
+			c.ParentID = &p
+		}
+		tree[c.ID] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, c := range tree {
+		c.Path = tree.path(c.ID)
+	}
+	return tree, nil
+}
+
+// path is the "Food > Dairy > Milk" breadcrumb of a category.
+func (t categoryTree) path(id int) string {
+	var names []string
+	for seen := 0; seen <= len(t); seen++ {
+		c, ok := t[id]
+		if !ok {
+			break
+		}
+		names = append([]string{c.Name}, names...)
+		if c.ParentID == nil {
+			break
+		}
+		id = *c.ParentID
+	}
+	return strings.Join(names, " > ")
+}
+
+// descendants returns id and every category below it.
+func (t categoryTree) descendants(id int) []int {
+	children := make(map[int][]int)
+	for _, c := range t {
+		if c.ParentID != nil {
+			children[*c.ParentID] = append(children[*c.ParentID], c.ID)
+		}
+	}
+	out := []int{id}
+	for i := 0; i < len(out); i++ {
+		out = append(out, children[out[i]]...)
+	}
+	return out
+}
+
+// isAncestor reports whether a is b or one of b's ancestors.
+func (t categoryTree) isAncestor(a, b int) bool {
+	for seen := 0; seen <= len(t); seen++ {
+		if a == b {
+			return true
+		}
+		c, ok := t[b]
+		if !ok || c.ParentID == nil {
+			return false
+		}
+		b = *c.ParentID
+	}
+	return false
+}
+
+// GET /api/categories
+func GetCategories(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tree, err := loadCategories(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]Category, 0, len(tree))
+	for _, c := range tree {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	json.NewEncoder(w).Encode(out)
+}
+
+type categoryPayload struct {
+	Name     string `json:"name"`
+	ParentID *int   `json:"parentId"`
+}
+
+// categoryNameTaken checks for a sibling with the same name. The unique key
+// can't catch duplicate top-level names because their parentID is NULL.
+func categoryNameTaken(name string, parentID *int, exceptID int) (bool, error) {
+	var n int
+	err := db.DB.QueryRow(`SELECT COUNT(*) FROM categories WHERE name = ? AND parentID <=> ? AND id <> ?`,
+		name, parentID, exceptID).Scan(&n)
+	return n > 0, err
+}
+
+// POST /api/categories
+func CreateCategory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var p categoryPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		http.Error(w, "Category name required", http.StatusBadRequest)
+		return
+	}
+	if taken, err := categoryNameTaken(p.Name, p.ParentID, 0); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if taken {
+		http.Error(w, "Category already exists under that parent", http.StatusConflict)
+		return
+	}
+
+	res, err := db.DB.Exec(`INSERT INTO categories (name, parentID) VALUES (?, ?)`, p.Name, p.ParentID)
+	if err != nil {
+		if isDuplicateKey(err) {
+			http.Error(w, "Category already exists under that parent", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Insert failed (unknown parent?)", http.StatusBadRequest)
+		return
+	}
+	id, _ := res.LastInsertId()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "name": p.Name})
+}
+
+// PUT /api/categories/{id}
+func UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/categories/"))
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	var p categoryPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		http.Error(w, "Category name required", http.StatusBadRequest)
+		return
+	}
+
+	tree, err := loadCategories(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := tree[id]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if p.ParentID != nil {
+		if _, ok := tree[*p.ParentID]; !ok {
+			http.Error(w, "Unknown parent category", http.StatusBadRequest)
+			return
+		}
+		if tree.isAncestor(id, *p.ParentID) {
+			http.Error(w, "A category can't be moved below itself", http.StatusConflict)
+			return
+		}
+	}
+	if taken, err := categoryNameTaken(p.Name, p.ParentID, id); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if taken {
+		http.Error(w, "Category already exists under that parent", http.StatusConflict)
+		return
+	}
+
+	if _, err := db.DB.Exec(`UPDATE categories SET name = ?, parentID = ? WHERE id = ?`, p.Name, p.ParentID, id); err != nil {
+		if isDuplicateKey(err) {
+			http.Error(w, "Category already exists under that parent", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Update failed", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "name": p.Name})
+}
+
+// DELETE /api/categories/{id}
+//
+// Products in the category become uncategorised; categories with
+// subcategories can't be deleted.
+func DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/categories/"))
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var children int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM categories WHERE parentID = ?`, id).Scan(&children); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if children > 0 {
+		http.Error(w, "Category has subcategories", http.StatusConflict)
+		return
+	}
+
+	res, err := db.DB.Exec(`DELETE FROM categories WHERE id = ?`, id)
+	if err != nil {
+		http.Error(w, "Delete failed", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
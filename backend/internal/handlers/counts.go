@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/inventory"
+	"backend/internal/models"
+)
+
+// countLine is one counted quantity submitted by a worker.
+type countLine struct {
+	BatchID  int     `json:"batchId"`
+	Quantity float64 `json:"quantity"`
+}
+
+// Reason codes an adjustment from a count can be posted with.
+var countReasonCodes = map[string]bool{
+	"count_correction": true,
+	"damage":           true,
+	"theft":            true,
+	"found":            true,
+	"data_entry":       true,
+	"other":            true,
+}
+
+// unpickedReserved is how much of a batch is reserved for requests but still
+// on the shelf.
+func unpickedReserved(q queryer, batchID int) (float64, error) {
+	var qty float64
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(ab.quantity), 0)
+		FROM assigned_batches ab
+		JOIN purchase_items pi ON pi.id = ab.itemID
+		JOIN purchase_requests pr ON pr.id = pi.requestID
+		WHERE ab.batchID = ? AND pr.status IN ('pending', 'accepted', 'picking')
+	`, batchID).Scan(&qty)
+	return qty, err
+}
+
+// varianceThreshold is the share of the expected quantity (COUNT_VARIANCE_PERCENT,
+// default 5) a variance may reach before an admin has to approve it.
+func varianceThreshold() float64 {
+	if p, err := strconv.ParseFloat(os.Getenv("COUNT_VARIANCE_PERCENT"), 64); err == nil && p >= 0 {
+		return p / 100
+	}
+	return 0.05
+}
+
+func needsApproval(expected, variance float64) bool {
+	if math.Abs(variance) <= quantityEpsilon {
+		return false
+	}
+	if expected <= quantityEpsilon {
+		return true
+	}
+	return math.Abs(variance)/expected > varianceThreshold()
+}
+
+// postCountAdjustment books the variance of a count item against its batch.
+// Stock can't go below zero, so a shortfall larger than what is free is
+// posted down to zero; the adjusted amount is returned.
+func postCountAdjustment(tx *sql.Tx, itemID, batchID int, variance float64, actorID int) (float64, error) {
+	var available float64
+	err := tx.QueryRow(`SELECT quantity FROM stock WHERE batchID = ? FOR UPDATE`, batchID).Scan(&available)
+	if err != nil {
+		return 0, err
+	}
+	delta := variance
+	if delta < -available {
+		delta = -available
+	}
+	if math.Abs(delta) <= quantityEpsilon {
+		return 0, nil
+	}
+	return delta, inventory.Adjust(tx, batchID, delta, inventory.Doc{Type: "count_item", ID: itemID}, actorID)
+}
+
+// POST /api/counts
+//
+// Schedules a count task covering every batch of a product, in a location or
+// from a supplier. Exactly one of productId, locationId and supplierId is set.
+func ScheduleCount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var payload struct {
+		ProductID  int `json:"productId"`
+		LocationID int `json:"locationId"`
+		SupplierID int `json:"supplierId"`
+		WorkerID   int `json:"workerId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var filter string
+	var arg int
+	scopes := 0
+	if payload.ProductID > 0 {
+		filter, arg = "s.productID = ?", payload.ProductID
+		scopes++
+	}
+	if payload.LocationID > 0 {
+		filter, arg = "s.locationID = ?", payload.LocationID
+		scopes++
+	}
+	if payload.SupplierID > 0 {
+		filter, arg = "p.supplierID = ?", payload.SupplierID
+		scopes++
+	}
+	if scopes != 1 {
+		http.Error(w, "Give exactly one of productId, locationId or supplierId", http.StatusBadRequest)
+		return
+	}
+
+	if payload.WorkerID != 0 {
+		var role string
+		err := db.DB.QueryRow(`SELECT role FROM users WHERE id = ?`, payload.WorkerID).Scan(&role)
+		if err != nil || role != models.RoleWorker {
+			http.Error(w, "Unknown worker", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Only batches with something on the shelf are counted; those already
+	// on an open count are left to that count.
+	rows, err := tx.Query(`
+		SELECT s.batchID
+		FROM stock s
+		JOIN products p ON p.id = s.productID
+		WHERE `+filter+`
+		  AND (s.quantity > 0 OR EXISTS (
+		      SELECT 1 FROM assigned_batches ab
+		      JOIN purchase_items pi ON pi.id = ab.itemID
+		      JOIN purchase_requests pr ON pr.id = pi.requestID
+		      WHERE ab.batchID = s.batchID AND pr.status IN ('pending', 'accepted', 'picking')))
+		  AND NOT EXISTS (
+		      SELECT 1 FROM count_items ci
+		      JOIN tasks t ON t.id = ci.taskID
+		      WHERE ci.batchID = s.batchID AND t.status = 'pending')
+		ORDER BY s.batchID
+	`, arg)
+	if err != nil {
+		http.Error(w, "Failed to load batches", http.StatusInternalServerError)
+		return
+	}
+	var batchIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		batchIDs = append(batchIDs, id)
+	}
+	rows.Close()
+	if len(batchIDs) == 0 {
+		http.Error(w, "No batches to count", http.StatusUnprocessableEntity)
+		return
+	}
+
+	taskID, err := insertTask(tx, "count", nil, nil, payload.WorkerID)
+	if err != nil {
+		http.Error(w, "Failed to create count task", http.StatusInternalServerError)
+		return
+	}
+	for _, id := range batchIDs {
+		if _, err := tx.Exec(`INSERT INTO count_items (taskID, batchID) VALUES (?, ?)`, taskID, id); err != nil {
+			http.Error(w, "Failed to create count task", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"taskId": taskID, "batches": len(batchIDs)})
+}
+
+// completeCountTask records the counted quantities of a count task. The
+// expected quantity is what the shelf should hold right now: free stock plus
+// reservations not picked yet. Small variances are posted at once; the rest
+// wait for an admin.
+func completeCountTask(tx *sql.Tx, taskID int64, counts []countLine, actorID int) ([]lineError, error) {
+	rows, err := tx.Query(`SELECT id, batchID FROM count_items WHERE taskID = ? ORDER BY batchID`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	type item struct{ id, batchID int }
+	var items []item
+	onTask := make(map[int]bool)
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.id, &it.batchID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		items = append(items, it)
+		onTask[it.batchID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var problems []lineError
+	counted := make(map[int]float64)
+	for i, c := range counts {
+		if !onTask[c.BatchID] {
+			problems = append(problems, lineError{Line: i, BatchID: c.BatchID, Error: "batch is not part of this count"})
+			continue
+		}
+		if c.Quantity < 0 {
+			problems = append(problems, lineError{Line: i, BatchID: c.BatchID, Error: "quantity can't be negative"})
+			continue
+		}
+		if _, dup := counted[c.BatchID]; dup {
+			problems = append(problems, lineError{Line: i, BatchID: c.BatchID, Error: "batch counted twice"})
+			continue
+		}
+		counted[c.BatchID] = c.Quantity
+	}
+	for _, it := range items {
+		if _, ok := counted[it.batchID]; !ok {
+			problems = append(problems, lineError{Line: -1, BatchID: it.batchID, Error: "batch was not counted"})
+		}
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+
+	for _, it := range items {
+		itemID, batchID := it.id, it.batchID
+		var free float64
+		if err := tx.QueryRow(`SELECT quantity FROM stock WHERE batchID = ? FOR UPDATE`, batchID).Scan(&free); err != nil {
+			return nil, err
+		}
+		reserved, err := unpickedReserved(tx, batchID)
+		if err != nil {
+			return nil, err
+		}
+		expected := free + reserved
+		variance := counted[batchID] - expected
+
+		status, reason := "review", interface{}(nil)
+		var posted float64
+		if !needsApproval(expected, variance) {
+			if posted, err = postCountAdjustment(tx, itemID, batchID, variance, actorID); err != nil {
+				return nil, err
+			}
+			status, reason = "posted", "count_correction"
+		}
+		if _, err := tx.Exec(`
+			UPDATE count_items
+			   SET expected = ?, counted = ?, variance = ?, posted = ?, status = ?, reasonCode = ?,
+			       countedBy = ?, counted_at = NOW()
+			 WHERE id = ?
+		`, expected, counted[batchID], variance, posted, status, reason, nullInt(actorID), itemID); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// GET /api/counts/variances
+//
+// Lists counted items, by default those waiting for approval. ?status=
+// selects posted or rejected ones instead.
+func GetCountVariances(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "review"
+	}
+	if status != "review" && status != "posted" && status != "rejected" {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT ci.id, ci.taskID, ci.batchID, p.productName, COALESCE(l.code, ''),
+		       ci.expected, ci.counted, ci.variance, ci.posted, ci.status, ci.reasonCode, ci.counted_at
+		FROM count_items ci
+		JOIN stock s ON s.batchID = ci.batchID
+		JOIN products p ON p.id = s.productID
+		LEFT JOIN locations l ON l.id = s.locationID
+		WHERE ci.status = ?
+		ORDER BY ci.counted_at, ci.id
+	`, status)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type Variance struct {
+		ID          int       `json:"id"`
+		TaskID      int       `json:"taskId"`
+		BatchID     int       `json:"batchId"`
+		ProductName string    `json:"productName"`
+		BinCode     string    `json:"binCode,omitempty"`
+		Expected    float64   `json:"expected"`
+		Counted     float64   `json:"counted"`
+		Variance    float64   `json:"variance"`
+		Posted      float64   `json:"posted"`
+		Status      string    `json:"status"`
+		ReasonCode  string    `json:"reasonCode,omitempty"`
+		CountedAt   time.Time `json:"countedAt"`
+	}
+	out := []Variance{}
+	for rows.Next() {
+		var v Variance
+		var reason sql.NullString
+		if err := rows.Scan(&v.ID, &v.TaskID, &v.BatchID, &v.ProductName, &v.BinCode,
+			&v.Expected, &v.Counted, &v.Variance, &v.Posted, &v.Status, &reason, &v.CountedAt); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		v.ReasonCode = reason.String
+		out = append(out, v)
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// POST /api/count-items/{id}/approve
+// POST /api/count-items/{id}/reject
+func ReviewCountItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/count-items/")
+	var approve bool
+	switch {
+	case strings.HasSuffix(path, "/approve"):
+		approve = true
+		path = strings.TrimSuffix(path, "/approve")
+	case strings.HasSuffix(path, "/reject"):
+		path = strings.TrimSuffix(path, "/reject")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	itemID, err := strconv.Atoi(path)
+	if err != nil {
+		http.Error(w, "Invalid count item ID", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		ReasonCode string `json:"reasonCode"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	if approve && !countReasonCodes[payload.ReasonCode] {
+		http.Error(w, "reasonCode must be one of count_correction, damage, theft, found, data_entry, other", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var batchID int
+	var variance float64
+	var status string
+	err = tx.QueryRow(`SELECT batchID, variance, status FROM count_items WHERE id = ? FOR UPDATE`, itemID).
+		Scan(&batchID, &variance, &status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Count item not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if status != "review" {
+		http.Error(w, "Count item is not waiting for approval", http.StatusConflict)
+		return
+	}
+
+	status = "rejected"
+	var posted float64
+	var reason interface{}
+	if approve {
+		if posted, err = postCountAdjustment(tx, itemID, batchID, variance, actorID(r)); err != nil {
+			log.Printf("Count adjustment error: %v", err)
+			http.Error(w, "Failed to post adjustment", http.StatusInternalServerError)
+			return
+		}
+		status, reason = "posted", payload.ReasonCode
+	}
+	if _, err := tx.Exec(`
+		UPDATE count_items
+		   SET status = ?, posted = ?, reasonCode = ?, reviewedBy = ?, reviewed_at = NOW()
+		 WHERE id = ?
+	`, status, posted, reason, nullInt(actorID(r)), itemID); err != nil {
+		http.Error(w, "Failed to update count item", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": itemID, "status": status, "posted": posted})
+}
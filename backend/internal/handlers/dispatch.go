@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/middleware"
+	"backend/internal/models"
+)
+
+var errTaskNotYours = errors.New("task is assigned to another worker")
+
+// dispatchWorker picks the worker a new task is pushed to, following
+// TASK_DISPATCH:
+//
+//	least_loaded (default)  worker with the fewest pending tasks
+//	round_robin             next worker after the one assigned most recently
+//	pull                    nobody; the task waits in the queue to be claimed
+//
+// It returns an invalid NullInt64 when the task should go to the queue.
+func dispatchWorker(tx *sql.Tx) (sql.NullInt64, error) {
+	var id sql.NullInt64
+	var err error
+
+	switch os.Getenv("TASK_DISPATCH") {
+	case "pull":
+		return id, nil
+	case "round_robin":
+		var last sql.NullInt64
+		err = tx.QueryRow(`
+			SELECT workerID FROM tasks
+			WHERE workerID IS NOT NULL AND assigned_at IS NOT NULL
+			ORDER BY assigned_at DESC, id DESC
+			LIMIT 1
+		`).Scan(&last)
+		if err != nil && err != sql.ErrNoRows {
+			return id, err
+		}
+		err = tx.QueryRow(`
+			SELECT id FROM users
+			WHERE role = 'worker'
+			ORDER BY id > ? DESC, id
+			LIMIT 1
+		`, last.Int64).Scan(&id)
+	default:
+		err = tx.QueryRow(`
+			SELECT u.id
+			FROM users u
+			LEFT JOIN tasks t ON t.workerID = u.id AND t.status = 'pending'
+			WHERE u.role = 'worker'
+			GROUP BY u.id
+			ORDER BY COUNT(t.id), u.id
+			LIMIT 1
+		`).Scan(&id)
+	}
+	if err == sql.ErrNoRows {
+		return sql.NullInt64{}, nil
+	}
+	return id, err
+}
+
+// insertTask creates a task and dispatches it unless workerID is given.
+func insertTask(tx *sql.Tx, taskType string, requestID, orderID interface{}, workerID int) (int64, error) {
+	worker := sql.NullInt64{Int64: int64(workerID), Valid: workerID > 0}
+	if !worker.Valid {
+		var err error
+		if worker, err = dispatchWorker(tx); err != nil {
+			return 0, err
+		}
+	}
+
+	var assignedAt interface{}
+	if worker.Valid {
+		assignedAt = time.Now()
+	}
+	res, err := tx.Exec(
+		`INSERT INTO tasks (requestID, orderID, workerID, type, assigned_at) VALUES (?, ?, ?, ?, ?)`,
+		requestID, orderID, worker, taskType, assignedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func claimTimeout() time.Duration {
+	if m, err := strconv.Atoi(os.Getenv("TASK_CLAIM_TIMEOUT_MINUTES")); err == nil && m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return 30 * time.Minute
+}
+
+// releaseExpiredClaims returns claimed tasks nobody started in time to the
+// queue. It runs whenever the queue is read or claimed from.
+func releaseExpiredClaims(ex interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}) error {
+	res, err := ex.Exec(`
+		UPDATE tasks
+		   SET workerID = NULL, assigned_at = NULL, claimed_at = NULL, claim_expires_at = NULL
+		 WHERE status = 'pending' AND started_at IS NULL AND claim_expires_at < NOW()
+	`)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Released %d expired task claims", n)
+	}
+	return nil
+}
+
+// takeTask locks a pending task for the caller. A queued task is claimed on
+// the spot; a task of another worker is refused unless the caller is admin.
+func takeTask(tx *sql.Tx, taskID int, r *http.Request) error {
+	var worker sql.NullInt64
+	err := tx.QueryRow(`SELECT workerID FROM tasks WHERE id = ? AND status = 'pending' FOR UPDATE`, taskID).Scan(&worker)
+	if err != nil {
+		return err
+	}
+
+	caller, _ := middleware.CurrentUser(r)
+	if !worker.Valid {
+		_, err = tx.Exec(
+			`UPDATE tasks SET workerID = ?, assigned_at = NOW(), claimed_at = NOW() WHERE id = ?`,
+			caller.UserID, taskID,
+		)
+		return err
+	}
+	if int(worker.Int64) != caller.UserID && caller.Role != models.RoleAdmin {
+		return errTaskNotYours
+	}
+	return nil
+}
+
+func writeTaskError(w http.ResponseWriter, err error) {
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Task not found", http.StatusNotFound)
+	case errors.Is(err, errTaskNotYours):
+		http.Error(w, "Task is assigned to another worker", http.StatusForbidden)
+	default:
+		http.Error(w, "Failed to update task", http.StatusInternalServerError)
+	}
+}
+
+// POST /api/worker/tasks/claim
+func ClaimNextTask(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := releaseExpiredClaims(tx); err != nil {
+		http.Error(w, "Failed to release expired claims", http.StatusInternalServerError)
+		return
+	}
+
+	var taskID int
+	var taskType string
+	err = tx.QueryRow(`
+		SELECT id, type FROM tasks
+		WHERE workerID IS NULL AND status = 'pending'
+		ORDER BY created_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`).Scan(&taskID, &taskType)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No unassigned tasks", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load queue", http.StatusInternalServerError)
+		return
+	}
+
+	expires := time.Now().Add(claimTimeout())
+	if _, err := tx.Exec(`
+		UPDATE tasks
+		   SET workerID = ?, assigned_at = NOW(), claimed_at = NOW(), claim_expires_at = ?
+		 WHERE id = ?
+	`, actorID(r), expires, taskID); err != nil {
+		http.Error(w, "Failed to claim task", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"taskId":         taskID,
+		"type":           taskType,
+		"claimExpiresAt": expires.Format(time.RFC3339),
+	})
+}
+
+// POST /api/worker/tasks/{taskId}/release
+func ReleaseTask(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/worker/tasks/")
+	idStr = strings.TrimSuffix(idStr, "/release")
+	taskID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := takeTask(tx, taskID, r); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	if _, err := tx.Exec(`
+		UPDATE tasks
+		   SET workerID = NULL, assigned_at = NULL, claimed_at = NULL,
+		       claim_expires_at = NULL, started_at = NULL, waveID = NULL
+		 WHERE id = ?
+	`, taskID); err != nil {
+		http.Error(w, "Failed to release task", http.StatusInternalServerError)
+		return
+	}
+	// Whoever takes the task over scans the picks again.
+	if _, err := tx.Exec(`DELETE FROM pick_confirmations WHERE taskID = ?`, taskID); err != nil {
+		http.Error(w, "Failed to release task", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+// POST /api/tasks/{taskId}/reassign
+func ReassignTask(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/tasks/")
+	idStr = strings.TrimSuffix(idStr, "/reassign")
+	taskID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	// workerId 0 or missing puts the task back into the queue.
+	var payload struct {
+		WorkerID int `json:"workerId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if payload.WorkerID > 0 {
+		var role string
+		err := db.DB.QueryRow(`SELECT role FROM users WHERE id = ?`, payload.WorkerID).Scan(&role)
+		if err != nil || role != models.RoleWorker {
+			http.Error(w, "Unknown worker", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the task so a worker can't claim or confirm picks on it while
+	// it changes hands.
+	var status string
+	err = tx.QueryRow(`SELECT status FROM tasks WHERE id = ? FOR UPDATE`, taskID).Scan(&status)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err == sql.ErrNoRows || status != "pending" {
+		http.Error(w, "Task not found or already completed", http.StatusNotFound)
+		return
+	}
+
+	var worker, assignedAt interface{}
+	if payload.WorkerID > 0 {
+		worker, assignedAt = payload.WorkerID, time.Now()
+	}
+	if _, err := tx.Exec(`
+		UPDATE tasks
+		   SET workerID = ?, assigned_at = ?, claimed_at = NULL,
+		       claim_expires_at = NULL, started_at = NULL, waveID = NULL
+		 WHERE id = ?
+	`, worker, assignedAt, taskID); err != nil {
+		http.Error(w, "Failed to reassign task", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM pick_confirmations WHERE taskID = ?`, taskID); err != nil {
+		http.Error(w, "Failed to reassign task", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"taskId": taskID, "workerId": payload.WorkerID})
+}
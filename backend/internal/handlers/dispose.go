@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/inventory"
+)
+
+// disposeLine names a batch quantity to take out of stock and why.
+type disposeLine struct {
+	BatchID  int     `json:"batchId"`
+	Quantity float64 `json:"quantity"`
+	Reason   string  `json:"reason"` // expired, damaged or recalled
+}
+
+func validDisposeReason(reason string) bool {
+	switch reason {
+	case "expired", "damaged", "recalled":
+		return true
+	}
+	return false
+}
+
+// createDisposeTask validates the lines against current stock and creates a
+// dispose task for them. It returns the per-line problems instead when any
+// line is invalid.
+func createDisposeTask(tx *sql.Tx, lines []disposeLine, workerID int) (int64, []lineError, error) {
+	var problems []lineError
+	for i, l := range lines {
+		fail := func(msg string) {
+			problems = append(problems, lineError{Line: i, BatchID: l.BatchID, Error: msg})
+		}
+		if !validDisposeReason(l.Reason) {
+			fail("reason must be expired, damaged or recalled")
+			continue
+		}
+		if l.Quantity <= 0 {
+			fail("quantity must be positive")
+			continue
+		}
+		var available float64
+		err := tx.QueryRow(`SELECT quantity FROM stock WHERE batchID = ?`, l.BatchID).Scan(&available)
+		if err == sql.ErrNoRows {
+			fail("batch does not exist")
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if l.Quantity > available+quantityEpsilon {
+			fail("quantity exceeds what is left in the batch")
+		}
+	}
+	if len(lines) == 0 {
+		problems = append(problems, lineError{Error: "no batches to dispose"})
+	}
+	if len(problems) > 0 {
+		return 0, problems, nil
+	}
+
+	taskID, err := insertTask(tx, "dispose", nil, nil, workerID)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, l := range lines {
+		if _, err := tx.Exec(
+			`INSERT INTO dispose_items (taskID, batchID, quantity, reason) VALUES (?, ?, ?, ?)`,
+			taskID, l.BatchID, l.Quantity, l.Reason,
+		); err != nil {
+			return 0, nil, err
+		}
+	}
+	return taskID, nil, nil
+}
+
+// completeDisposeTask takes the task's quantities out of stock and writes a
+// write-off record per batch. A batch that shrank in the meantime is only
+// written off down to zero.
+func completeDisposeTask(tx *sql.Tx, taskID int64, actorID int) error {
+	rows, err := tx.Query(`
+		SELECT di.batchID, di.quantity, di.reason
+		FROM dispose_items di
+		WHERE di.taskID = ?
+		ORDER BY di.batchID
+	`, taskID)
+	if err != nil {
+		return err
+	}
+	var lines []disposeLine
+	for rows.Next() {
+		var l disposeLine
+		if err := rows.Scan(&l.BatchID, &l.Quantity, &l.Reason); err != nil {
+			rows.Close()
+			return err
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range lines {
+		var productID int
+		var available float64
+		err := tx.QueryRow(`SELECT productID, quantity FROM stock WHERE batchID = ? FOR UPDATE`, l.BatchID).
+			Scan(&productID, &available)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		qty := l.Quantity
+		if qty > available {
+			qty = available
+		}
+		if qty <= 0 {
+			continue
+		}
+		if err := inventory.Dispose(tx, l.BatchID, qty, inventory.Doc{Type: "task", ID: int(taskID)}, actorID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO write_offs (batchID, productID, quantity, reason, taskID, actorID) VALUES (?, ?, ?, ?, ?, ?)`,
+			l.BatchID, productID, qty, l.Reason, taskID, nullInt(actorID),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateExpiredDisposeTasks creates one dispose task covering every batch
+// past its expiration date that still holds stock and isn't already on a
+// pending dispose task. It returns 0 when there is nothing to dispose.
+func GenerateExpiredDisposeTasks() (int64, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT s.batchID, s.quantity
+		FROM stock s
+		WHERE s.expirationDate < CURDATE()
+		  AND s.quantity > 0
+		  AND NOT EXISTS (
+		      SELECT 1 FROM dispose_items di
+		      JOIN tasks t ON t.id = di.taskID
+		      WHERE di.batchID = s.batchID AND t.status = 'pending')
+		ORDER BY s.batchID
+		FOR UPDATE
+	`)
+	if err != nil {
+		return 0, err
+	}
+	var lines []disposeLine
+	for rows.Next() {
+		l := disposeLine{Reason: "expired"}
+		if err := rows.Scan(&l.BatchID, &l.Quantity); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(lines) == 0 {
+		return 0, nil
+	}
+
+	taskID, problems, err := createDisposeTask(tx, lines, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(problems) > 0 {
+		log.Printf("Expired disposal skipped, invalid lines: %v", problems)
+		return 0, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	log.Printf("Dispose task %d created for %d expired batches", taskID, len(lines))
+	return taskID, nil
+}
+
+// StartDisposeJob runs GenerateExpiredDisposeTasks every
+// DISPOSE_JOB_INTERVAL_HOURS (default 24). A negative value disables it.
+func StartDisposeJob() {
+	hours := 24
+	if h, err := strconv.Atoi(os.Getenv("DISPOSE_JOB_INTERVAL_HOURS")); err == nil && h != 0 {
+		hours = h
+	}
+	if hours < 0 {
+		return
+	}
+
+	go func() {
+		for {
+			if _, err := GenerateExpiredDisposeTasks(); err != nil {
+				log.Printf("Dispose job failed: %v", err)
+			}
+			time.Sleep(time.Duration(hours) * time.Hour)
+		}
+	}()
+}
+
+// POST /api/dispose/generate
+func GenerateDisposeTasksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID, err := GenerateExpiredDisposeTasks()
+	if err != nil {
+		log.Printf("Dispose generation error: %v", err)
+		http.Error(w, "Failed to generate dispose tasks", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"taskId": taskID})
+}
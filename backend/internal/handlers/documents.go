@@ -0,0 +1,513 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/mail"
+	"backend/internal/middleware"
+	"backend/internal/models"
+	"backend/internal/money"
+	"backend/internal/pdf"
+)
+
+var errUnpriced = errors.New("request was placed before prices were recorded")
+
+// docBatch is the stock a line was picked from.
+type docBatch struct {
+	BatchID        int
+	LotNumber      string
+	ExpirationDate string
+	Quantity       float64
+}
+
+// docLine is a purchase item as printed on the paperwork. Shipped is what
+// was reserved for it, which is what left the warehouse once it shipped.
+type docLine struct {
+	ProductName string
+	SKU         string
+	Unit        string
+	Ordered     int
+	Shipped     float64
+	UnitPrice   money.NullAmount
+	Batches     []docBatch
+}
+
+// requestDoc is everything the invoice and packing slip show.
+type requestDoc struct {
+	ID        int
+	UserID    int
+	Email     string
+	Status    string
+	ParentID  int
+	CreatedAt time.Time
+	Lines     []docLine
+}
+
+func loadRequestDoc(q queryer, requestID int) (*requestDoc, error) {
+	d := &requestDoc{ID: requestID}
+	var parentID sql.NullInt64
+	err := q.QueryRow(`
+		SELECT r.userID, u.email, r.status, r.parentRequestID, r.created_at
+		FROM purchase_requests r
+		JOIN users u ON u.id = r.userID
+		WHERE r.id = ?
+	`, requestID).Scan(&d.UserID, &d.Email, &d.Status, &parentID, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.ParentID = int(parentID.Int64)
+
+	rows, err := q.Query(`
+		SELECT pi.id, p.productName, COALESCE(p.sku, ''), p.unitType, pi.quantity, pi.unitPrice
+		FROM purchase_items pi
+		JOIN products p ON p.id = pi.productID
+		WHERE pi.requestID = ?
+		ORDER BY pi.id
+	`, requestID)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[int]int)
+	for rows.Next() {
+		var itemID int
+		var l docLine
+		if err := rows.Scan(&itemID, &l.ProductName, &l.SKU, &l.Unit, &l.Ordered, &l.UnitPrice); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[itemID] = len(d.Lines)
+		d.Lines = append(d.Lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`
+		SELECT ab.itemID, ab.batchID, COALESCE(s.lotNumber, ''), s.expirationDate, SUM(ab.quantity)
+		FROM assigned_batches ab
+		JOIN purchase_items pi ON pi.id = ab.itemID
+		JOIN stock s ON s.batchID = ab.batchID
+		WHERE pi.requestID = ?
+		GROUP BY ab.itemID, ab.batchID, s.lotNumber, s.expirationDate
+		ORDER BY ab.itemID, (s.expirationDate IS NULL), s.expirationDate, ab.batchID
+	`, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var itemID int
+		var b docBatch
+		var exp sql.NullTime
+		if err := rows.Scan(&itemID, &b.BatchID, &b.LotNumber, &exp, &b.Quantity); err != nil {
+			return nil, err
+		}
+		if exp.Valid {
+			b.ExpirationDate = exp.Time.Format("2006-01-02")
+		}
+		l := &d.Lines[index[itemID]]
+		l.Batches = append(l.Batches, b)
+		l.Shipped += b.Quantity
+	}
+	return d, rows.Err()
+}
+
+// formatQuantity prints a quantity the way its product is sold: whole
+// numbers for products counted in units, up to three decimals for products
+// sold by weight or volume.
+func formatQuantity(qty float64, unitType string) string {
+	if unitType == "unit" {
+		return strconv.FormatFloat(math.Round(qty), 'f', 0, 64)
+	}
+	s := strings.TrimRight(strconv.FormatFloat(qty, 'f', 3, 64), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// invoice is the bill for what a request shipped. Numbers come from the
+// invoice_sequence row, which is only advanced by transactions that commit
+// an invoice, so they are issued in order without gaps.
+type invoice struct {
+	Number   string
+	IssuedAt time.Time
+	orderTotals
+}
+
+func invoiceNumber(n int64) string {
+	return fmt.Sprintf("INV-%06d", n)
+}
+
+// issueInvoice returns the request's invoice, issuing it on first use. It
+// bills the shipped quantities at the prices captured when the request was
+// placed; a backorder shipment uses the tax rate of its original request.
+func issueInvoice(tx *sql.Tx, d *requestDoc) (*invoice, error) {
+	// Lock the request so two first downloads don't both issue an invoice.
+	var locked int
+	if err := tx.QueryRow(`SELECT id FROM purchase_requests WHERE id = ? FOR UPDATE`, d.ID).Scan(&locked); err != nil {
+		return nil, err
+	}
+	inv := &invoice{}
+	err := tx.QueryRow(`
+		SELECT number, subtotal, taxRate, tax, total, issued_at FROM invoices WHERE requestID = ?
+	`, d.ID).Scan(&inv.Number, &inv.Subtotal, &inv.TaxRate, &inv.Tax, &inv.Total, &inv.IssuedAt)
+	if err == nil {
+		return inv, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var subtotal money.Amount
+	for _, l := range d.Lines {
+		if l.Shipped <= quantityEpsilon {
+			continue
+		}
+		if !l.UnitPrice.Valid {
+			return nil, errUnpriced
+		}
+		subtotal += l.UnitPrice.Amount.TimesQty(l.Shipped)
+	}
+	var rate money.Rate
+	if err := tx.QueryRow(`
+		SELECT COALESCE(r.taxRate, parent.taxRate, 0)
+		FROM purchase_requests r
+		LEFT JOIN purchase_requests parent ON parent.id = r.parentRequestID
+		WHERE r.id = ?
+	`, d.ID).Scan(&rate); err != nil {
+		return nil, err
+	}
+	inv.orderTotals = priceOrder(subtotal, rate)
+
+	var next int64
+	if err := tx.QueryRow(`SELECT nextNumber FROM invoice_sequence WHERE id = 1 FOR UPDATE`).Scan(&next); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE invoice_sequence SET nextNumber = nextNumber + 1 WHERE id = 1`); err != nil {
+		return nil, err
+	}
+	inv.Number, inv.IssuedAt = invoiceNumber(next), time.Now()
+	if _, err := tx.Exec(
+		`INSERT INTO invoices (requestID, number, subtotal, taxRate, tax, total) VALUES (?, ?, ?, ?, ?, ?)`,
+		d.ID, inv.Number, inv.Subtotal, inv.TaxRate, inv.Tax, inv.Total,
+	); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// sendShippingDocuments issues the invoice of a request that just shipped
+// and emails it to the customer together with the packing slip. Requests
+// placed before prices were recorded only get the packing slip.
+func sendShippingDocuments(tx *sql.Tx, requestID int) error {
+	d, err := loadRequestDoc(tx, requestID)
+	if err != nil {
+		return err
+	}
+	attachments := []mail.Attachment{{
+		Filename:    fmt.Sprintf("packing-slip-%d.pdf", d.ID),
+		ContentType: "application/pdf",
+		Data:        packingSlipPDF(d),
+	}}
+	data := map[string]interface{}{"RequestID": d.ID, "Invoice": ""}
+
+	inv, err := issueInvoice(tx, d)
+	switch {
+	case err == nil:
+		attachments = append([]mail.Attachment{{
+			Filename:    inv.Number + ".pdf",
+			ContentType: "application/pdf",
+			Data:        invoicePDF(d, inv),
+		}}, attachments...)
+		data["Invoice"] = inv.Number
+		data["Total"] = inv.Total.String()
+	case errors.Is(err, errUnpriced):
+	default:
+		return err
+	}
+	return mail.Enqueue(tx, "shipment", d.Email, data, attachments...)
+}
+
+// docPage draws the heading of every page of a document and returns where
+// the body starts.
+type docPage func(page *pdf.Page) float64
+
+// docColumn is one column of a document table.
+type docColumn struct {
+	Title string
+	Width float64
+	Right bool
+}
+
+// docTable lays rows out over as many A4 pages as they need, repeating the
+// page heading and the column titles on each.
+type docTable struct {
+	doc     *pdf.Document
+	page    *pdf.Page
+	heading docPage
+	columns []docColumn
+	y       float64
+}
+
+const (
+	docMargin   = 50.0
+	docRowSize  = 9.0
+	docRowSpace = 14.0
+)
+
+func newDocTable(heading docPage, columns []docColumn) *docTable {
+	t := &docTable{doc: pdf.New(), heading: heading, columns: columns}
+	t.newPage()
+	return t
+}
+
+func (t *docTable) newPage() {
+	t.page = t.doc.AddPage(pdf.A4Width, pdf.A4Height)
+	t.y = t.heading(t.page)
+	titles := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		titles[i] = c.Title
+	}
+	t.cells(pdf.Bold, titles)
+	t.page.Line(docMargin, t.y+docRowSpace-3, pdf.A4Width-docMargin, t.y+docRowSpace-3, 0.5)
+	t.y -= 4
+}
+
+// room starts a new page unless height points are left above the margin.
+func (t *docTable) room(height float64) {
+	if t.y-height < docMargin {
+		t.newPage()
+	}
+}
+
+func (t *docTable) row(font pdf.Font, cells ...string) {
+	t.room(docRowSpace)
+	t.cells(font, cells)
+}
+
+func (t *docTable) cells(font pdf.Font, cells []string) {
+	x := docMargin
+	for i, c := range t.columns {
+		if i < len(cells) && cells[i] != "" {
+			s := fitText(font, docRowSize, cells[i], c.Width-6)
+			if c.Right {
+				t.page.TextRight(x+c.Width, t.y, font, docRowSize, s)
+			} else {
+				t.page.Text(x, t.y, font, docRowSize, s)
+			}
+		}
+		x += c.Width
+	}
+	t.y -= docRowSpace
+}
+
+// note writes a line of small print across the page.
+func (t *docTable) note(s string) {
+	t.room(docRowSpace)
+	t.page.Text(docMargin, t.y, pdf.Regular, 8, fitText(pdf.Regular, 8, s, pdf.A4Width-2*docMargin))
+	t.y -= docRowSpace
+}
+
+func companyName() string {
+	if name := os.Getenv("COMPANY_NAME"); name != "" {
+		return name
+	}
+	return "Warehouse"
+}
+
+// docHeading draws the company, the document title with its reference
+// lines on the right and the addressee.
+func docHeading(title string, refs []string, addresseeLabel, addressee string) docPage {
+	return func(page *pdf.Page) float64 {
+		right := pdf.A4Width - docMargin
+		y := pdf.A4Height - docMargin - 16
+		page.Text(docMargin, y, pdf.Bold, 16, companyName())
+		page.TextRight(right, y, pdf.Bold, 20, title)
+		if addr := os.Getenv("COMPANY_ADDRESS"); addr != "" {
+			page.Text(docMargin, y-16, pdf.Regular, 9, addr)
+		}
+		for i, ref := range refs {
+			page.TextRight(right, y-float64(i+1)*14-4, pdf.Regular, 10, ref)
+		}
+		y -= float64(len(refs))*14 + 36
+		page.Text(docMargin, y, pdf.Bold, 10, addresseeLabel)
+		page.Text(docMargin, y-14, pdf.Regular, 10, addressee)
+		return y - 44
+	}
+}
+
+func docRefs(d *requestDoc) []string {
+	refs := []string{fmt.Sprintf("Order #%d", d.ID)}
+	if d.ParentID != 0 {
+		refs = append(refs, fmt.Sprintf("Backorder of order #%d", d.ParentID))
+	}
+	return refs
+}
+
+func invoicePDF(d *requestDoc, inv *invoice) []byte {
+	refs := append([]string{inv.Number}, docRefs(d)...)
+	refs = append(refs, "Date "+inv.IssuedAt.Format("2006-01-02"))
+	t := newDocTable(docHeading("INVOICE", refs, "Bill to", d.Email), []docColumn{
+		{Title: "Product", Width: 215},
+		{Title: "SKU", Width: 90},
+		{Title: "Qty", Width: 50, Right: true},
+		{Title: "Unit price", Width: 70, Right: true},
+		{Title: "Amount", Width: 70, Right: true},
+	})
+
+	pending := false
+	for _, l := range d.Lines {
+		if float64(l.Ordered)-l.Shipped > quantityEpsilon {
+			pending = true
+		}
+		if l.Shipped <= quantityEpsilon || !l.UnitPrice.Valid {
+			continue
+		}
+		t.row(pdf.Regular, l.ProductName, l.SKU, formatQuantity(l.Shipped, l.Unit),
+			l.UnitPrice.Amount.String(), l.UnitPrice.Amount.TimesQty(l.Shipped).String())
+	}
+
+	t.room(4 * docRowSpace)
+	t.page.Line(pdf.A4Width-docMargin-190, t.y+docRowSpace-3, pdf.A4Width-docMargin, t.y+docRowSpace-3, 0.5)
+	t.row(pdf.Regular, "", "", "", "Subtotal", inv.Subtotal.String())
+	t.row(pdf.Regular, "", "", "", "Tax "+inv.TaxRate.String()+"%", inv.Tax.String())
+	t.row(pdf.Bold, "", "", "", "Total", inv.Total.String())
+	if pending {
+		t.y -= docRowSpace
+		t.note("Items not included in this shipment are invoiced when they ship.")
+	}
+	return t.doc.Bytes()
+}
+
+func packingSlipPDF(d *requestDoc) []byte {
+	refs := append(docRefs(d), "Ordered "+d.CreatedAt.Format("2006-01-02"))
+	t := newDocTable(docHeading("PACKING SLIP", refs, "Ship to", d.Email), []docColumn{
+		{Title: "Product", Width: 170},
+		{Title: "SKU", Width: 80},
+		{Title: "Batch", Width: 55},
+		{Title: "Lot", Width: 80},
+		{Title: "Expires", Width: 65},
+		{Title: "Qty", Width: 45, Right: true},
+	})
+
+	for _, l := range d.Lines {
+		if len(l.Batches) == 0 {
+			t.row(pdf.Regular, l.ProductName, l.SKU, "", "", "", "0")
+			continue
+		}
+		for i, b := range l.Batches {
+			name, sku := l.ProductName, l.SKU
+			if i > 0 {
+				name, sku = "", ""
+			}
+			t.row(pdf.Regular, name, sku, batchLabel(b.BatchID), b.LotNumber, b.ExpirationDate, formatQuantity(b.Quantity, l.Unit))
+		}
+		if missing := float64(l.Ordered) - l.Shipped; missing > quantityEpsilon {
+			t.note(fmt.Sprintf("%s: %s of %d ordered follow in a later shipment.",
+				l.ProductName, formatQuantity(missing, l.Unit), l.Ordered))
+		}
+	}
+	return t.doc.Bytes()
+}
+
+// GET /api/purchase-requests/{id}/invoice.pdf
+//
+// Available once the request shipped; the invoice is issued on shipment or,
+// for requests shipped earlier, on first download.
+func GetInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	requestID, err := requestIDFromPath(r.URL.Path, "/invoice.pdf")
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	d, err := loadRequestDoc(tx, requestID)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Invoice error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !canAccessUser(r, d.UserID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if d.Status != "shipped" && d.Status != "delivered" {
+		http.Error(w, "Invoices are issued when the request ships", http.StatusConflict)
+		return
+	}
+
+	inv, err := issueInvoice(tx, d)
+	if errors.Is(err, errUnpriced) {
+		http.Error(w, "Request was placed before prices were recorded", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Invoice error: %v", err)
+		http.Error(w, "Failed to issue invoice", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to issue invoice", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, inv.Number))
+	w.Write(invoicePDF(d, inv))
+}
+
+// GET /api/purchase-requests/{id}/packing-slip.pdf
+//
+// Lists the reserved batches, so it is available once the request was
+// accepted. Workers can print it for any request, customers for their own.
+func GetPackingSlipPDF(w http.ResponseWriter, r *http.Request) {
+	requestID, err := requestIDFromPath(r.URL.Path, "/packing-slip.pdf")
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+
+	d, err := loadRequestDoc(db.DB, requestID)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Packing slip error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if id, _ := middleware.CurrentUser(r); id.Role != models.RoleWorker && !canAccessUser(r, d.UserID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	switch d.Status {
+	case "pending", "cancelled", "denied":
+		http.Error(w, "Request has not been accepted", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="packing-slip-%d.pdf"`, d.ID))
+	w.Write(packingSlipPDF(d))
+}
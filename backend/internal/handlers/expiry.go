@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/mail"
+)
+
+// expiryAlert is raised once per batch when it enters its product's
+// short-expiry window (near_expiry) and again when it expires.
+type expiryAlert struct {
+	ID             int     `json:"id"`
+	BatchID        int     `json:"batchId"`
+	ProductID      int     `json:"productId"`
+	ProductName    string  `json:"productName"`
+	Kind           string  `json:"kind"`
+	ExpirationDate string  `json:"expirationDate"`
+	Quantity       float64 `json:"quantity"`
+	BinCode        *string `json:"binCode"`
+	MarkdownPrice  *string `json:"markdownPrice"`
+	CreatedAt      string  `json:"createdAt"`
+	AcknowledgedAt *string `json:"acknowledgedAt"`
+}
+
+// markdownPercent is the discount put on near-expiry batches
+// (EXPIRY_MARKDOWN_PERCENT, default 0 which disables markdowns).
+func markdownPercent() float64 {
+	if p, err := strconv.ParseFloat(os.Getenv("EXPIRY_MARKDOWN_PERCENT"), 64); err == nil && p > 0 && p < 100 {
+		return p
+	}
+	return 0
+}
+
+// MonitorExpiry raises alerts for batches that entered the short-expiry
+// window of their product (products.shortExpirationDate days) or expired
+// since the last run, marks near-expiry batches down when markdowns are
+// enabled and emails new alerts to EXPIRY_ALERT_EMAIL. It returns the
+// number of new alerts.
+func MonitorExpiry() (int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Unpicked reservations still sit on the shelf, so they count too.
+	res, err := tx.Exec(`
+		INSERT IGNORE INTO expiry_alerts (batchID, productID, kind, expirationDate, quantity)
+		SELECT s.batchID, s.productID,
+		       IF(s.expirationDate < CURDATE(), 'expired', 'near_expiry'),
+		       s.expirationDate,
+		       ` + batchOnHand + ` AS onHand
+		FROM stock s
+		JOIN products p ON p.id = s.productID
+		WHERE s.expirationDate IS NOT NULL
+		  AND (s.expirationDate < CURDATE()
+		       OR s.expirationDate <= CURDATE() + INTERVAL p.shortExpirationDate DAY)
+		HAVING onHand > 0
+	`)
+	if err != nil {
+		return 0, err
+	}
+	created, _ := res.RowsAffected()
+
+	if pct := markdownPercent(); pct > 0 {
+		if _, err := tx.Exec(`
+			UPDATE stock s
+			JOIN products p ON p.id = s.productID
+			SET s.markdownPrice = ROUND(p.price * (100 - ?) / 100, 2)
+			WHERE s.markdownPrice IS NULL
+			  AND s.quantity > 0
+			  AND s.blocked = FALSE
+			  AND s.expirationDate >= CURDATE()
+			  AND s.expirationDate <= CURDATE() + INTERVAL p.shortExpirationDate DAY
+		`, pct); err != nil {
+			return 0, err
+		}
+	}
+
+	if to := os.Getenv("EXPIRY_ALERT_EMAIL"); created > 0 && to != "" {
+		alerts, err := loadExpiryAlerts(tx, "a.notified_at IS NULL")
+		if err != nil {
+			return 0, err
+		}
+		for _, addr := range strings.Split(to, ",") {
+			if addr = strings.TrimSpace(addr); addr == "" {
+				continue
+			}
+			if err := mail.Enqueue(tx, "expiry_alert", addr, map[string]interface{}{"Alerts": alerts}); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := tx.Exec(`UPDATE expiry_alerts SET notified_at = NOW() WHERE notified_at IS NULL`); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if created > 0 {
+		log.Printf("Expiry monitor raised %d alerts", created)
+	}
+	return int(created), nil
+}
+
+func loadExpiryAlerts(q queryer, where string, args ...interface{}) ([]expiryAlert, error) {
+	rows, err := q.Query(`
+		SELECT a.id, a.batchID, a.productID, p.productName, a.kind, a.expirationDate, a.quantity,
+		       l.code, s.markdownPrice, a.created_at, a.acknowledged_at
+		FROM expiry_alerts a
+		JOIN products p ON p.id = a.productID
+		LEFT JOIN stock s ON s.batchID = a.batchID
+		LEFT JOIN locations l ON l.id = s.locationID
+		WHERE `+where+`
+		ORDER BY a.expirationDate, a.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []expiryAlert{}
+	for rows.Next() {
+		var a expiryAlert
+		var exp, created time.Time
+		var binCode, markdown sql.NullString
+		var acked sql.NullTime
+		if err := rows.Scan(&a.ID, &a.BatchID, &a.ProductID, &a.ProductName, &a.Kind, &exp, &a.Quantity,
+			&binCode, &markdown, &created, &acked); err != nil {
+			return nil, err
+		}
+		a.ExpirationDate = exp.Format("2006-01-02")
+		a.CreatedAt = created.Format(time.RFC3339)
+		if binCode.Valid {
+			a.BinCode = &binCode.String
+		}
+		if markdown.Valid {
+			a.MarkdownPrice = &markdown.String
+		}
+		if acked.Valid {
+			t := acked.Time.Format(time.RFC3339)
+			a.AcknowledgedAt = &t
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// StartExpiryMonitor runs MonitorExpiry every EXPIRY_MONITOR_INTERVAL_HOURS
+// (default 24). A negative value disables it.
+func StartExpiryMonitor() {
+	hours := 24
+	if h, err := strconv.Atoi(os.Getenv("EXPIRY_MONITOR_INTERVAL_HOURS")); err == nil && h != 0 {
+		hours = h
+	}
+	if hours < 0 {
+		return
+	}
+
+	go func() {
+		for {
+			if _, err := MonitorExpiry(); err != nil {
+				log.Printf("Expiry monitor failed: %v", err)
+			}
+			time.Sleep(time.Duration(hours) * time.Hour)
+		}
+	}()
+}
+
+// GET /api/expiry-alerts?status=open|acknowledged|all
+func GetExpiryAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	where := "a.acknowledged_at IS NULL"
+	switch r.URL.Query().Get("status") {
+	case "", "open":
+	case "acknowledged":
+		where = "a.acknowledged_at IS NOT NULL"
+	case "all":
+		where = "TRUE"
+	default:
+		http.Error(w, "status must be open, acknowledged or all", http.StatusBadRequest)
+		return
+	}
+
+	alerts, err := loadExpiryAlerts(db.DB, where)
+	if err != nil {
+		log.Printf("Expiry alerts error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// POST /api/expiry-alerts/{id}/acknowledge
+func AcknowledgeExpiryAlert(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/expiry-alerts/")
+	idStr = strings.TrimSuffix(idStr, "/acknowledge")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid alert ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.DB.Exec(`
+		UPDATE expiry_alerts SET acknowledged_at = NOW(), acknowledgedBy = ?
+		WHERE id = ? AND acknowledged_at IS NULL
+	`, nullInt(actorID(r)), id)
+	if err != nil {
+		http.Error(w, "Update failed", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Alert not found or already acknowledged", http.StatusNotFound)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+// POST /api/expiry-alerts/scan
+func RunExpiryMonitor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	created, err := MonitorExpiry()
+	if err != nil {
+		log.Printf("Expiry monitor error: %v", err)
+		http.Error(w, "Failed to scan stock", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"created": created})
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/inventory"
+)
+
+// GET /api/inventory/movements?batchId=&productId=&limit=
+func GetInventoryMovements(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := `
+		SELECT m.id, m.batchID, m.productID, p.productName, m.delta, m.quantity,
+		       m.reason, m.docType, m.docID, m.actorID, u.email, m.created_at
+		FROM inventory_movements m
+		JOIN products p ON p.id = m.productID
+		LEFT JOIN users u ON u.id = m.actorID
+		WHERE 1 = 1`
+	var args []interface{}
+	if v := r.URL.Query().Get("batchId"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid batchId", http.StatusBadRequest)
+			return
+		}
+		query += ` AND m.batchID = ?`
+		args = append(args, id)
+	}
+	if v := r.URL.Query().Get("productId"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid productId", http.StatusBadRequest)
+			return
+		}
+		query += ` AND m.productID = ?`
+		args = append(args, id)
+	}
+	limit := 500
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 5000 {
+		limit = v
+	}
+	query += ` ORDER BY m.id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		log.Printf("Movements query error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type Movement struct {
+		ID          int     `json:"id"`
+		BatchID     int     `json:"batchID"`
+		ProductID   int     `json:"productID"`
+		ProductName string  `json:"productName"`
+		Delta       float64 `json:"delta"`
+		Quantity    float64 `json:"quantity"`
+		Reason      string  `json:"reason"`
+		DocType     string  `json:"docType"`
+		DocID       *int64  `json:"docID"`
+		ActorID     *int64  `json:"actorId"`
+		ActorEmail  *string `json:"actorEmail"`
+		CreatedAt   string  `json:"created_at"`
+	}
+	var out []Movement
+	for rows.Next() {
+		var m Movement
+		var docID, actor sql.NullInt64
+		var email sql.NullString
+		var created time.Time
+		if err := rows.Scan(&m.ID, &m.BatchID, &m.ProductID, &m.ProductName, &m.Delta, &m.Quantity,
+			&m.Reason, &m.DocType, &docID, &actor, &email, &created); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		if docID.Valid {
+			m.DocID = &docID.Int64
+		}
+		if actor.Valid {
+			m.ActorID = &actor.Int64
+		}
+		if email.Valid {
+			m.ActorEmail = &email.String
+		}
+		m.CreatedAt = created.Format(time.RFC3339)
+		out = append(out, m)
+	}
+
+	json.NewEncoder(w).Encode(out)
+}
+
+// GET /api/inventory/reconcile
+func ReconcileInventory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	mismatches, checked, err := inventory.Reconcile()
+	if err != nil {
+		log.Printf("Reconcile error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"checked":    checked,
+		"consistent": len(mismatches) == 0,
+		"mismatches": mismatches,
+	})
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/internal/barcode"
+	"backend/internal/db"
+	"backend/internal/pdf"
+)
+
+// label is what gets printed: the scannable code and the lines of text
+// shown next to it.
+type label struct {
+	Code   string
+	Title  string
+	Detail []string
+}
+
+func batchLabelFor(batchID int) (*label, error) {
+	b, err := loadScannedBatch(db.DB, batchID)
+	if err != nil {
+		return nil, err
+	}
+	l := &label{Code: batchLabel(b.BatchID), Title: b.ProductName}
+	detail := fmt.Sprintf("Batch %d", b.BatchID)
+	if b.ExpirationDate != nil {
+		detail += " - exp " + *b.ExpirationDate
+	}
+	l.Detail = append(l.Detail, detail)
+	if b.BinCode != nil {
+		l.Detail = append(l.Detail, "Bin "+*b.BinCode)
+	}
+	return l, nil
+}
+
+func locationLabelFor(locationID int) (*label, error) {
+	var code, zone, aisle, shelf, bin string
+	var pickFace sql.NullString
+	err := db.DB.QueryRow(`
+		SELECT l.code, l.zone, l.aisle, l.shelf, l.bin, p.productName
+		FROM locations l
+		LEFT JOIN products p ON p.id = l.productID
+		WHERE l.id = ?
+	`, locationID).Scan(&code, &zone, &aisle, &shelf, &bin, &pickFace)
+	if err != nil {
+		return nil, err
+	}
+	l := &label{Code: code, Title: code}
+	l.Detail = append(l.Detail, fmt.Sprintf("Zone %s - aisle %s - shelf %s - bin %s", zone, aisle, shelf, bin))
+	if pickFace.Valid {
+		l.Detail = append(l.Detail, "Pick face: "+pickFace.String)
+	}
+	return l, nil
+}
+
+// GET /api/labels/batches/{id}?symbology=code128|qr&format=png|pdf
+// GET /api/labels/locations/{id}?symbology=code128|qr&format=png|pdf
+//
+// PNG is the bare symbol, sized with scale (pixels per module). PDF is a
+// 100 × 50 mm label with the symbol and a readable description.
+func GetLabel(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/labels/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var l *label
+	switch parts[0] {
+	case "batches":
+		l, err = batchLabelFor(id)
+	case "locations":
+		l, err = locationLabelFor(id)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	symbology := query.Get("symbology")
+	if symbology == "" {
+		symbology = "code128"
+	}
+	if symbology != "code128" && symbology != "qr" {
+		http.Error(w, "symbology must be code128 or qr", http.StatusBadRequest)
+		return
+	}
+
+	switch query.Get("format") {
+	case "", "png":
+		scale := 3
+		if symbology == "qr" {
+			scale = 8
+		}
+		if s := query.Get("scale"); s != "" {
+			if scale, err = strconv.Atoi(s); err != nil || scale < 1 || scale > 20 {
+				http.Error(w, "scale must be between 1 and 20", http.StatusBadRequest)
+				return
+			}
+		}
+		var img image.Image
+		if symbology == "qr" {
+			img, err = barcode.QRImage(l.Code, scale)
+		} else {
+			img, err = barcode.Code128Image(l.Code, scale, 40*scale)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, img)
+
+	case "pdf":
+		doc, err := labelPDF(l, symbology)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="label-%s.pdf"`, l.Code))
+		w.Write(doc)
+
+	default:
+		http.Error(w, "format must be png or pdf", http.StatusBadRequest)
+	}
+}
+
+// labelPDF lays a label out on a 100 × 50 mm page. Symbols are drawn as
+// vector rectangles so they print sharp at any resolution.
+func labelPDF(l *label, symbology string) ([]byte, error) {
+	width, height, margin := pdf.MM(100), pdf.MM(50), pdf.MM(4)
+	doc := pdf.New()
+	page := doc.AddPage(width, height)
+
+	if symbology == "qr" {
+		matrix, err := barcode.EncodeQR(l.Code)
+		if err != nil {
+			return nil, err
+		}
+		side := height - 2*margin
+		module := side / float64(len(matrix)+2*barcode.QRQuietZone)
+		inset := margin + barcode.QRQuietZone*module
+		for row, line := range matrix {
+			for col, dark := range line {
+				if dark {
+					page.Rect(inset+float64(col)*module, height-inset-float64(row+1)*module, module, module)
+				}
+			}
+		}
+		x := margin + side + margin
+		textWidth := width - x - margin
+		y := height - margin - 12
+		page.Text(x, y, pdf.Bold, 12, fitText(pdf.Bold, 12, l.Title, textWidth))
+		y -= 16
+		page.Text(x, y, pdf.Regular, 10, fitText(pdf.Regular, 10, l.Code, textWidth))
+		for _, d := range l.Detail {
+			y -= 13
+			page.Text(x, y, pdf.Regular, 8, fitText(pdf.Regular, 8, d, textWidth))
+		}
+		return doc.Bytes(), nil
+	}
+
+	modules, err := barcode.EncodeCode128(l.Code)
+	if err != nil {
+		return nil, err
+	}
+	usable := width - 2*margin
+	module := min(pdf.MM(0.5), usable/float64(len(modules)+2*barcode.Code128QuietZone))
+	barsWidth := module * float64(len(modules))
+	x0 := (width - barsWidth) / 2
+	barBottom, barHeight := pdf.MM(14), pdf.MM(20)
+	for i := 0; i < len(modules); {
+		if !modules[i] {
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(modules) && modules[i+run] {
+			run++
+		}
+		page.Rect(x0+float64(i)*module, barBottom, float64(run)*module, barHeight)
+		i += run
+	}
+
+	page.Text(margin, height-margin-12, pdf.Bold, 12, fitText(pdf.Bold, 12, l.Title, usable))
+	page.Text((width-pdf.TextWidth(pdf.Regular, 10, l.Code))/2, barBottom-12, pdf.Regular, 10, l.Code)
+	if len(l.Detail) > 0 {
+		detail := fitText(pdf.Regular, 8, strings.Join(l.Detail, " - "), usable)
+		page.Text(margin, margin, pdf.Regular, 8, detail)
+	}
+	return doc.Bytes(), nil
+}
+
+// fitText shortens s with an ellipsis until it fits in maxWidth.
+func fitText(font pdf.Font, size float64, s string, maxWidth float64) string {
+	if pdf.TextWidth(font, size, s) <= maxWidth {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && pdf.TextWidth(font, size, string(r)+"...") > maxWidth {
+		r = r[:len(r)-1]
+	}
+	return string(r) + "..."
+}
@@ -0,0 +1,493 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/internal/db"
+)
+
+// queryer is satisfied by *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Location is one storage bin. Its place in the warehouse → zone → aisle →
+// shelf → bin hierarchy is stored on the row, and Code is the label printed
+// on the bin. A pick face is a bin dedicated to ProductID; it is topped up
+// from storage when it holds less than MinQuantity. Used is left out for
+// workers, see countsBlind.
+type Location struct {
+	ID          int      `json:"id"`
+	Warehouse   string   `json:"warehouse"`
+	Zone        string   `json:"zone"`
+	Aisle       string   `json:"aisle"`
+	Shelf       string   `json:"shelf"`
+	Bin         string   `json:"bin"`
+	Code        string   `json:"code"`
+	Capacity    float64  `json:"capacity"`
+	Used        *float64 `json:"used,omitempty"`
+	Active      bool     `json:"active"`
+	ProductID   *int     `json:"productId,omitempty"`
+	MinQuantity *float64 `json:"minQuantity,omitempty"`
+}
+
+// locationUsage is the physical quantity in each bin: what is free in stock
+// plus what is reserved for requests but not picked yet.
+const locationUsage = `
+	SELECT l.id, COALESCE(f.quantity, 0) + COALESCE(rs.quantity, 0) AS used
+	FROM locations l
+	LEFT JOIN (SELECT locationID, SUM(quantity) AS quantity
+	             FROM stock
+	            WHERE locationID IS NOT NULL
+	            GROUP BY locationID) f ON f.locationID = l.id
+	LEFT JOIN (SELECT s.locationID, SUM(ab.quantity) AS quantity
+	             FROM assigned_batches ab
+	             JOIN stock s ON s.batchID = ab.batchID
+	             JOIN purchase_items pi ON pi.id = ab.itemID
+	             JOIN purchase_requests pr ON pr.id = pi.requestID
+	            WHERE s.locationID IS NOT NULL
+	              AND pr.status IN ('pending', 'accepted', 'picking')
+	            GROUP BY s.locationID) rs ON rs.locationID = l.id`
+
+const locationColumns = `l.id, l.warehouse, l.zone, l.aisle, l.shelf, l.bin, l.code, l.capacity, u.used, l.active, l.productID, l.minQuantity`
+
+func scanLocation(row interface{ Scan(...interface{}) error }) (Location, error) {
+	var l Location
+	var used float64
+	var productID sql.NullInt64
+	var minQty sql.NullFloat64
+	err := row.Scan(&l.ID, &l.Warehouse, &l.Zone, &l.Aisle, &l.Shelf, &l.Bin, &l.Code, &l.Capacity, &used, &l.Active, &productID, &minQty)
+	l.Used = &used
+	if productID.Valid {
+		id := int(productID.Int64)
+		l.ProductID = &id
+	}
+	if minQty.Valid {
+		l.MinQuantity = &minQty.Float64
+	}
+	return l, err
+}
+
+// putawayPlanner suggests bins for put-aways from one read of the active
+// bins and their load. Each suggestion is counted against its bin, so the
+// put-aways planned together don't overfill it.
+type putawayPlanner struct {
+	bins []putawayBin
+}
+
+type putawayBin struct {
+	loc   Location
+	free  float64
+	holds map[int]bool // products with stock in the bin
+}
+
+func newPutawayPlanner(q queryer) (*putawayPlanner, error) {
+	rows, err := q.Query(`
+		SELECT ` + locationColumns + `, GROUP_CONCAT(DISTINCT s.productID)
+		FROM locations l
+		JOIN (` + locationUsage + `) u ON u.id = l.id
+		LEFT JOIN stock s ON s.locationID = l.id AND s.quantity > 0
+		WHERE l.active = TRUE
+		GROUP BY l.id, u.used
+		ORDER BY l.zone, l.aisle, l.shelf, l.bin
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	p := &putawayPlanner{}
+	for rows.Next() {
+		var b putawayBin
+		var held sql.NullString
+		var used float64
+		var productID sql.NullInt64
+		var minQty sql.NullFloat64
+		l := &b.loc
+		if err := rows.Scan(&l.ID, &l.Warehouse, &l.Zone, &l.Aisle, &l.Shelf, &l.Bin, &l.Code, &l.Capacity, &used,
+			&l.Active, &productID, &minQty, &held); err != nil {
+			return nil, err
+		}
+		l.Used = &used
+		if productID.Valid {
+			id := int(productID.Int64)
+			l.ProductID = &id
+		}
+		if minQty.Valid {
+			l.MinQuantity = &minQty.Float64
+		}
+		b.free = l.Capacity - used
+		b.holds = make(map[int]bool)
+		if held.Valid {
+			for _, s := range strings.Split(held.String, ",") {
+				if id, err := strconv.Atoi(s); err == nil {
+					b.holds[id] = true
+				}
+			}
+		}
+		p.bins = append(p.bins, b)
+	}
+	return p, rows.Err()
+}
+
+// suggest picks a bin for qty of a product: a bin already holding the
+// product with room to spare first, then the emptiest bin that fits. Pick
+// faces dedicated to other products are skipped. It returns nil when no bin
+// has room.
+func (p *putawayPlanner) suggest(productID int, qty float64) *Location {
+	var best *putawayBin
+	for i := range p.bins {
+		b := &p.bins[i]
+		if b.free < qty || b.loc.ProductID != nil && *b.loc.ProductID != productID {
+			continue
+		}
+		if best == nil || b.holds[productID] && !best.holds[productID] ||
+			b.holds[productID] == best.holds[productID] && *b.loc.Used < *best.loc.Used {
+			best = b
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	loc := best.loc
+	used := *loc.Used
+	loc.Used = &used
+	*best.loc.Used += qty
+	best.free -= qty
+	best.holds[productID] = true
+	return &loc
+}
+
+// suggestPutaway picks a bin for a single put-away; see putawayPlanner.
+func suggestPutaway(q queryer, productID int, qty float64) (*Location, error) {
+	p, err := newPutawayPlanner(q)
+	if err != nil {
+		return nil, err
+	}
+	return p.suggest(productID, qty), nil
+}
+
+// checkLocationRoom reports whether an active bin can take qty more. The
+// bin's row stays locked until tx ends, so concurrent put-aways and
+// transfers into it are checked one after the other. Its load is read with
+// locking reads rather than locationUsage: they see stock committed after
+// tx started, which a plain read inside tx would miss.
+func checkLocationRoom(tx *sql.Tx, locationID int, qty float64) (bool, error) {
+	var capacity float64
+	var active bool
+	err := tx.QueryRow(`SELECT capacity, active FROM locations WHERE id = ? FOR UPDATE`, locationID).Scan(&capacity, &active)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var stocked, reserved float64
+	if err := tx.QueryRow(`
+		SELECT COALESCE(SUM(quantity), 0) FROM stock WHERE locationID = ? FOR SHARE
+	`, locationID).Scan(&stocked); err != nil {
+		return false, err
+	}
+	if err := tx.QueryRow(`
+		SELECT COALESCE(SUM(ab.quantity), 0)
+		FROM assigned_batches ab
+		JOIN stock s ON s.batchID = ab.batchID
+		JOIN purchase_items pi ON pi.id = ab.itemID
+		JOIN purchase_requests pr ON pr.id = pi.requestID
+		WHERE s.locationID = ? AND pr.status IN ('pending', 'accepted', 'picking')
+		FOR SHARE
+	`, locationID).Scan(&reserved); err != nil {
+		return false, err
+	}
+	return active && capacity-stocked-reserved+quantityEpsilon >= qty, nil
+}
+
+// GET /api/locations
+//
+// Workers get the bins without their used capacity.
+func GetLocations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rows, err := db.DB.Query(`
+		SELECT ` + locationColumns + `
+		FROM locations l
+		JOIN (` + locationUsage + `) u ON u.id = l.id
+		ORDER BY l.warehouse, l.zone, l.aisle, l.shelf, l.bin
+	`)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	blind := countsBlind(r)
+	var out []Location
+	for rows.Next() {
+		l, err := scanLocation(rows)
+		if err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		if blind {
+			l.Used = nil
+		}
+		out = append(out, l)
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// GET /api/locations/{id}
+//
+// Workers get the bin and its batches without quantities.
+func GetLocation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/locations/"))
+	if err != nil {
+		http.Error(w, "Invalid location ID", http.StatusBadRequest)
+		return
+	}
+
+	l, err := scanLocation(db.DB.QueryRow(`
+		SELECT `+locationColumns+`
+		FROM locations l
+		JOIN (`+locationUsage+`) u ON u.id = l.id
+		WHERE l.id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	type Batch struct {
+		BatchID     int      `json:"batchID"`
+		ProductID   int      `json:"productID"`
+		ProductName string   `json:"productName"`
+		Quantity    *float64 `json:"quantity,omitempty"`
+	}
+	blind := countsBlind(r)
+	if blind {
+		l.Used = nil
+	}
+	rows, err := db.DB.Query(`
+		SELECT s.batchID, s.productID, p.productName, s.quantity
+		FROM stock s
+		JOIN products p ON p.id = s.productID
+		WHERE s.locationID = ?
+		ORDER BY s.batchID
+	`, id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var batches []Batch
+	for rows.Next() {
+		var b Batch
+		var qty float64
+		if err := rows.Scan(&b.BatchID, &b.ProductID, &b.ProductName, &qty); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		if !blind {
+			b.Quantity = &qty
+		}
+		batches = append(batches, b)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"location": l,
+		"batches":  batches,
+	})
+}
+
+type locationPayload struct {
+	Warehouse   string   `json:"warehouse"`
+	Zone        string   `json:"zone"`
+	Aisle       string   `json:"aisle"`
+	Shelf       string   `json:"shelf"`
+	Bin         string   `json:"bin"`
+	Code        string   `json:"code"`
+	Capacity    float64  `json:"capacity"`
+	Active      *bool    `json:"active"`
+	ProductID   *int     `json:"productId"`
+	MinQuantity *float64 `json:"minQuantity"`
+}
+
+func (p *locationPayload) normalize() string {
+	if p.Warehouse == "" || p.Zone == "" || p.Aisle == "" || p.Shelf == "" || p.Bin == "" {
+		return "warehouse, zone, aisle, shelf and bin are required"
+	}
+	if p.Capacity <= 0 {
+		return "capacity must be positive"
+	}
+	if p.MinQuantity != nil {
+		if p.ProductID == nil {
+			return "minQuantity needs a productId for the pick face"
+		}
+		if *p.MinQuantity < 0 || *p.MinQuantity >= p.Capacity {
+			return "minQuantity must be between 0 and capacity"
+		}
+	}
+	if p.Code == "" {
+		p.Code = strings.Join([]string{p.Warehouse, p.Zone, p.Aisle, p.Shelf, p.Bin}, "-")
+	}
+	if p.Active == nil {
+		active := true
+		p.Active = &active
+	}
+	return ""
+}
+
+// POST /api/locations
+func CreateLocation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var p locationPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if msg := p.normalize(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.DB.Exec(`
+		INSERT INTO locations (warehouse, zone, aisle, shelf, bin, code, capacity, active, productID, minQuantity)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, p.Warehouse, p.Zone, p.Aisle, p.Shelf, p.Bin, p.Code, p.Capacity, *p.Active, p.ProductID, p.MinQuantity)
+	if err != nil {
+		if isDuplicateKey(err) {
+			http.Error(w, "Location code already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Insert failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "code": p.Code})
+}
+
+// PUT /api/locations/{id}
+func UpdateLocation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/locations/"))
+	if err != nil {
+		http.Error(w, "Invalid location ID", http.StatusBadRequest)
+		return
+	}
+	var p locationPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if msg := p.normalize(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var exists int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM locations WHERE id = ?`, id).Scan(&exists); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if exists == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, err = db.DB.Exec(`
+		UPDATE locations
+		   SET warehouse = ?, zone = ?, aisle = ?, shelf = ?, bin = ?, code = ?, capacity = ?, active = ?,
+		       productID = ?, minQuantity = ?
+		 WHERE id = ?
+	`, p.Warehouse, p.Zone, p.Aisle, p.Shelf, p.Bin, p.Code, p.Capacity, *p.Active, p.ProductID, p.MinQuantity, id)
+	if err != nil {
+		if isDuplicateKey(err) {
+			http.Error(w, "Location code already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Update failed", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "code": p.Code})
+}
+
+// DELETE /api/locations/{id}
+func DeleteLocation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/locations/"))
+	if err != nil {
+		http.Error(w, "Invalid location ID", http.StatusBadRequest)
+		return
+	}
+
+	var held int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM stock WHERE locationID = ?`, id).Scan(&held); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if held > 0 {
+		http.Error(w, "Location still holds stock batches", http.StatusConflict)
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM locations WHERE id = ?`, id); err != nil {
+		log.Printf("DeleteLocation error: %v", err)
+		http.Error(w, "Delete failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/worker/tasks/{taskId}/putaway
+func GetPutawaySuggestion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/worker/tasks/")
+	idStr = strings.TrimSuffix(idStr, "/putaway")
+	taskID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var productID int
+	var qty float64
+	err = db.DB.QueryRow(`
+		SELECT op.productID, op.quantity
+		FROM tasks t
+		JOIN orderedProducts op ON op.id = t.orderID
+		WHERE t.id = ? AND t.type = 'unload'
+	`, taskID).Scan(&productID, &qty)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Unload task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	loc, err := suggestPutaway(db.DB, productID, qty)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"taskId":     taskID,
+		"quantity":   qty,
+		"suggestion": loc,
+	})
+}
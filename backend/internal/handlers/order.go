@@ -45,12 +45,13 @@ func GetAllOrderedProducts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	type Order struct {
-		ID             int     `json:"id"`
-		ProductID      int     `json:"productID"`
-		ProductName    string  `json:"productName"`
-		Quantity       float64 `json:"quantity"`
-		ExpirationDate *string `json:"expirationDate"`
-		CreatedAt      string  `json:"created_at"`
+		ID              int     `json:"id"`
+		ProductID       int     `json:"productID"`
+		ProductName     string  `json:"productName"`
+		Quantity        float64 `json:"quantity"`
+		ExpirationDate  *string `json:"expirationDate"`
+		PurchaseOrderID *int    `json:"purchaseOrderId"`
+		CreatedAt       string  `json:"created_at"`
 	}
 
 	rows, err := db.DB.Query(`
@@ -60,9 +61,11 @@ func GetAllOrderedProducts(w http.ResponseWriter, r *http.Request) {
             p.productName,
             op.quantity,
             op.expirationDate,
+            op.purchaseOrderID,
             op.created_at
         FROM orderedProducts op
         JOIN products p ON p.id = op.productID
+        WHERE op.received_at IS NULL
         ORDER BY op.id DESC
     `)
 	if err != nil {
@@ -75,6 +78,7 @@ func GetAllOrderedProducts(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var o Order
 		var exp sql.NullTime
+		var poID sql.NullInt64
 		var created time.Time
 
 		if err := rows.Scan(
@@ -83,6 +87,7 @@ func GetAllOrderedProducts(w http.ResponseWriter, r *http.Request) {
 			&o.ProductName,
 			&o.Quantity,
 			&exp,
+			&poID,
 			&created,
 		); err != nil {
 			http.Error(w, "Scan error", http.StatusInternalServerError)
@@ -93,6 +98,10 @@ func GetAllOrderedProducts(w http.ResponseWriter, r *http.Request) {
 			str := exp.Time.Format("2006-01-02")
 			o.ExpirationDate = &str
 		}
+		if poID.Valid {
+			id := int(poID.Int64)
+			o.PurchaseOrderID = &id
+		}
 		o.CreatedAt = created.Format(time.RFC3339)
 		orders = append(orders, o)
 	}
@@ -110,9 +119,19 @@ func DeleteOrderedProduct(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid ID", http.StatusBadRequest)
 		return
 	}
-	if _, err := db.DB.Exec(`DELETE FROM orderedProducts WHERE id = ?`, id); err != nil {
+	// Received lines are the record of a goods receipt and stay.
+	res, err := db.DB.Exec(`DELETE FROM orderedProducts WHERE id = ? AND received_at IS NULL`, id)
+	if err != nil {
 		http.Error(w, "Delete failed", http.StatusInternalServerError)
 		return
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var received int
+		db.DB.QueryRow(`SELECT COUNT(*) FROM orderedProducts WHERE id = ?`, id).Scan(&received)
+		if received > 0 {
+			http.Error(w, "Delivery already received", http.StatusConflict)
+			return
+		}
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
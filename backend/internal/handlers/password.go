@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/mail"
+	"backend/internal/models"
+	"backend/internal/session"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// POST /api/password/forgot
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// Always the same answer so the endpoint can't be used to probe accounts.
+	resp := models.Response{Message: "If an account exists for that email, a reset link has been sent."}
+
+	token, err := newResetToken()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	res, err := db.DB.Exec(`
+		UPDATE users
+		   SET reset_token_hash = ?, reset_expires_at = ?
+		 WHERE email = ?
+	`, session.HashToken(token), time.Now().Add(resetTTL()), req.Email)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		err := mail.Enqueue(db.DB, "password_reset", req.Email, map[string]interface{}{
+			"Token":   token,
+			"Expires": humanDuration(resetTTL()),
+		})
+		if err != nil {
+			log.Printf("Reset email failed: %v", err)
+		}
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// POST /api/password/reset
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		http.Error(w, "Missing fields", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`
+		SELECT id FROM users
+		 WHERE reset_token_hash = ? AND reset_expires_at > NOW()
+		 FOR UPDATE
+	`, session.HashToken(req.Token)).Scan(&userID)
+	if err != nil {
+		http.Error(w, "Invalid or expired reset link", http.StatusBadRequest)
+		return
+	}
+
+	// Clearing the hash makes the link single-use.
+	if _, err := tx.Exec(`
+		UPDATE users
+		   SET password = ?, reset_token_hash = NULL, reset_expires_at = NULL
+		 WHERE id = ?
+	`, string(hash), userID); err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	// Anyone holding an old session has to log in with the new password.
+	if err := session.DeleteForUser(userID); err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %v", userID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Password updated, you can now log in."})
+}
+
+func newResetToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func resetTTL() time.Duration {
+	if m, err := strconv.Atoi(os.Getenv("RESET_TTL_MINUTES")); err == nil && m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return time.Hour
+}
+
+// humanDuration renders a TTL the way it reads in an email, e.g. "24 hours".
+func humanDuration(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if h := int(d / time.Hour); h != 1 {
+			return strconv.Itoa(h) + " hours"
+		}
+		return "1 hour"
+	}
+	return strconv.Itoa(int(d/time.Minute)) + " minutes"
+}
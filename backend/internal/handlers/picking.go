@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/models"
+)
+
+// pickRouteOrder walks each warehouse zone by zone, down each aisle shelf by
+// shelf and bin by bin. Batches without a location come last.
+const pickRouteOrder = `(l.id IS NULL), l.warehouse, l.zone, l.aisle, l.shelf, l.bin, s.batchID`
+
+// pickShare is the part of a pick stop that belongs to one request, so a
+// wave picker knows which tote each unit goes into.
+type pickShare struct {
+	TaskID    int     `json:"taskId"`
+	RequestID int     `json:"requestId"`
+	Quantity  float64 `json:"quantity"`
+}
+
+// pickStop is one stop on a picking route: a batch in a bin and how much to
+// take from it.
+type pickStop struct {
+	Seq         int         `json:"seq"`
+	BinCode     string      `json:"binCode,omitempty"`
+	ProductName string      `json:"productName"`
+	BatchID     int         `json:"batchId"`
+	Quantity    float64     `json:"quantity"`
+	Requests    []pickShare `json:"requests"`
+}
+
+// pickRoute loads the reserved batches of the given prepare tasks in walking
+// order and merges the picks from the same batch into one stop.
+func pickRoute(q queryer, taskIDs []int) ([]pickStop, error) {
+	if len(taskIDs) == 0 {
+		return []pickStop{}, nil
+	}
+	args := make([]interface{}, len(taskIDs))
+	for i, id := range taskIDs {
+		args[i] = id
+	}
+
+	rows, err := q.Query(`
+		SELECT t.id, t.requestID, p.productName, ab.batchID, ab.quantity, COALESCE(l.code, '')
+		FROM tasks t
+		JOIN purchase_items pi ON pi.requestID = t.requestID
+		JOIN assigned_batches ab ON ab.itemID = pi.id
+		JOIN stock s ON s.batchID = ab.batchID
+		JOIN products p ON p.id = s.productID
+		LEFT JOIN locations l ON l.id = s.locationID
+		WHERE t.type = 'prepare' AND ab.quantity > 0
+		  AND t.id IN (`+placeholders(len(taskIDs))+`)
+		ORDER BY `+pickRouteOrder+`, t.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stops := []pickStop{}
+	for rows.Next() {
+		var share pickShare
+		var productName, binCode string
+		var batchID int
+		if err := rows.Scan(&share.TaskID, &share.RequestID, &productName, &batchID, &share.Quantity, &binCode); err != nil {
+			return nil, err
+		}
+		if n := len(stops); n > 0 && stops[n-1].BatchID == batchID {
+			stops[n-1].Quantity += share.Quantity
+			stops[n-1].Requests = append(stops[n-1].Requests, share)
+			continue
+		}
+		stops = append(stops, pickStop{
+			Seq:         len(stops) + 1,
+			BinCode:     binCode,
+			ProductName: productName,
+			BatchID:     batchID,
+			Quantity:    share.Quantity,
+			Requests:    []pickShare{share},
+		})
+	}
+	return stops, rows.Err()
+}
+
+// placeholders returns n comma-separated bind parameters for an IN list.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func waveSize() int {
+	if n, err := strconv.Atoi(os.Getenv("WAVE_MAX_REQUESTS")); err == nil && n > 0 {
+		return n
+	}
+	return 5
+}
+
+// POST /api/waves
+//
+// Merges accepted requests into one wave for a single worker. Without
+// requestIds the oldest prepare tasks that are free to take are used, up to
+// maxRequests (WAVE_MAX_REQUESTS by default).
+func CreateWave(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var payload struct {
+		WorkerID    int   `json:"workerId"`
+		RequestIDs  []int `json:"requestIds"`
+		MaxRequests int   `json:"maxRequests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var role string
+	err := db.DB.QueryRow(`SELECT role FROM users WHERE id = ?`, payload.WorkerID).Scan(&role)
+	if err != nil || role != models.RoleWorker {
+		http.Error(w, "Unknown worker", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// A task can join a wave while nobody else has started it and the
+	// request is still waiting to be picked.
+	const eligible = `
+		t.type = 'prepare' AND t.status = 'pending' AND t.started_at IS NULL
+		AND t.waveID IS NULL AND pr.status = 'accepted'
+		AND (t.workerID IS NULL OR t.workerID = ?)`
+
+	var taskIDs []int
+	if len(payload.RequestIDs) == 0 {
+		limit := payload.MaxRequests
+		if limit <= 0 {
+			limit = waveSize()
+		}
+		rows, err := tx.Query(`
+			SELECT t.id
+			FROM tasks t
+			JOIN purchase_requests pr ON pr.id = t.requestID
+			WHERE `+eligible+`
+			ORDER BY t.created_at, t.id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		`, payload.WorkerID, limit)
+		if err != nil {
+			http.Error(w, "Failed to load tasks", http.StatusInternalServerError)
+			return
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				http.Error(w, "Scan error", http.StatusInternalServerError)
+				return
+			}
+			taskIDs = append(taskIDs, id)
+		}
+		rows.Close()
+	} else {
+		for _, requestID := range payload.RequestIDs {
+			var id int
+			err := tx.QueryRow(`
+				SELECT t.id
+				FROM tasks t
+				JOIN purchase_requests pr ON pr.id = t.requestID
+				WHERE t.requestID = ? AND `+eligible+`
+				FOR UPDATE
+			`, requestID, payload.WorkerID).Scan(&id)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Request "+strconv.Itoa(requestID)+" has no prepare task that can join a wave", http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to load tasks", http.StatusInternalServerError)
+				return
+			}
+			taskIDs = append(taskIDs, id)
+		}
+	}
+	if len(taskIDs) == 0 {
+		http.Error(w, "No prepare tasks available for a wave", http.StatusNotFound)
+		return
+	}
+
+	res, err := tx.Exec(`INSERT INTO waves (workerID, createdBy) VALUES (?, ?)`, payload.WorkerID, actorID(r))
+	if err != nil {
+		http.Error(w, "Failed to create wave", http.StatusInternalServerError)
+		return
+	}
+	waveID, _ := res.LastInsertId()
+
+	now := time.Now()
+	for _, id := range taskIDs {
+		if _, err := tx.Exec(`
+			UPDATE tasks
+			   SET waveID = ?, workerID = ?, assigned_at = ?, claimed_at = NULL, claim_expires_at = NULL
+			 WHERE id = ?
+		`, waveID, payload.WorkerID, now, id); err != nil {
+			http.Error(w, "Failed to assign tasks", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	route, err := pickRoute(tx, taskIDs)
+	if err != nil {
+		http.Error(w, "Failed to build picking route", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"waveId":   waveID,
+		"workerId": payload.WorkerID,
+		"taskIds":  taskIDs,
+		"route":    route,
+	})
+}
+
+// pickList is what a printed pick list shows.
+type pickList struct {
+	Title     string     `json:"title"`
+	WaveID    int        `json:"waveId,omitempty"`
+	TaskID    int        `json:"taskId,omitempty"`
+	Worker    string     `json:"worker,omitempty"`
+	Requests  []int      `json:"requestIds"`
+	Stops     []pickStop `json:"stops"`
+	PrintedAt time.Time  `json:"printedAt"`
+}
+
+var pickListTemplate = template.Must(template.New("picklist").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 12pt; margin: 1.5cm; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #444; padding: 4px 8px; text-align: left; }
+td.qty { text-align: right; }
+.box { width: 1.2em; height: 1.2em; border: 1px solid #000; display: inline-block; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>
+{{if .Worker}}Picker: {{.Worker}}<br>{{end}}
+Requests:{{range .Requests}} #{{.}}{{end}}<br>
+Printed: {{.PrintedAt.Format "2006-01-02 15:04"}}
+</p>
+<table>
+<tr><th>#</th><th>Bin</th><th>Product</th><th>Batch</th><th>Qty</th><th>Per request</th><th>Done</th></tr>
+{{range .Stops}}<tr>
+<td>{{.Seq}}</td>
+<td>{{if .BinCode}}{{.BinCode}}{{else}}-{{end}}</td>
+<td>{{.ProductName}}</td>
+<td>{{.BatchID}}</td>
+<td class="qty">{{printf "%.2f" .Quantity}}</td>
+<td>{{range $i, $s := .Requests}}{{if $i}}, {{end}}#{{$s.RequestID}}: {{printf "%.2f" $s.Quantity}}{{end}}</td>
+<td><span class="box"></span></td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// writePickList renders the list as HTML for printing, or as JSON with
+// ?format=json.
+func writePickList(w http.ResponseWriter, r *http.Request, list pickList) {
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pickListTemplate.Execute(w, list); err != nil {
+		http.Error(w, "Failed to render pick list", http.StatusInternalServerError)
+	}
+}
+
+// GET /api/waves/{id}/pick-list
+func GetWavePickList(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/waves/")
+	idStr = strings.TrimSuffix(idStr, "/pick-list")
+	waveID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid wave ID", http.StatusBadRequest)
+		return
+	}
+
+	var workerID sql.NullInt64
+	var worker sql.NullString
+	err = db.DB.QueryRow(`
+		SELECT wv.workerID, u.email
+		FROM waves wv
+		LEFT JOIN users u ON u.id = wv.workerID
+		WHERE wv.id = ?
+	`, waveID).Scan(&workerID, &worker)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Wave not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if workerID.Valid && !canAccessUser(r, int(workerID.Int64)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT id, requestID FROM tasks
+		WHERE waveID = ? AND status = 'pending'
+		ORDER BY id
+	`, waveID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := pickList{
+		Title:     "Pick list - wave " + strconv.Itoa(waveID),
+		WaveID:    waveID,
+		Worker:    worker.String,
+		Requests:  []int{},
+		PrintedAt: time.Now(),
+	}
+	var taskIDs []int
+	for rows.Next() {
+		var taskID, requestID int
+		if err := rows.Scan(&taskID, &requestID); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		taskIDs = append(taskIDs, taskID)
+		list.Requests = append(list.Requests, requestID)
+	}
+	rows.Close()
+
+	if list.Stops, err = pickRoute(db.DB, taskIDs); err != nil {
+		http.Error(w, "Failed to build picking route", http.StatusInternalServerError)
+		return
+	}
+	writePickList(w, r, list)
+}
+
+// GET /api/worker/tasks/{taskId}/pick-list
+func GetTaskPickList(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/worker/tasks/")
+	idStr = strings.TrimSuffix(idStr, "/pick-list")
+	taskID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var requestID int
+	var workerID sql.NullInt64
+	var worker sql.NullString
+	err = db.DB.QueryRow(`
+		SELECT t.requestID, t.workerID, u.email
+		FROM tasks t
+		LEFT JOIN users u ON u.id = t.workerID
+		WHERE t.id = ? AND t.type = 'prepare'
+	`, taskID).Scan(&requestID, &workerID, &worker)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Prepare task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if workerID.Valid && !canAccessUser(r, int(workerID.Int64)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	list := pickList{
+		Title:     "Pick list - task " + strconv.Itoa(taskID),
+		TaskID:    taskID,
+		Worker:    worker.String,
+		Requests:  []int{requestID},
+		PrintedAt: time.Now(),
+	}
+	if list.Stops, err = pickRoute(db.DB, []int{taskID}); err != nil {
+		http.Error(w, "Failed to build picking route", http.StatusInternalServerError)
+		return
+	}
+	writePickList(w, r, list)
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"backend/internal/barcode"
 	"backend/internal/db"
+	"backend/internal/middleware"
+	"backend/internal/models"
+	"backend/internal/money"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,43 +19,190 @@ import (
 	"time"
 )
 
-// GET /api/products/with-stock
+// productSorts maps the sort parameter of the catalog listing to SQL.
+var productSorts = map[string]string{
+	"name":      "p.productName, p.id",
+	"-name":     "p.productName DESC, p.id",
+	"price":     "p.price, p.id",
+	"-price":    "p.price DESC, p.id",
+	"quantity":  "quantity, p.id",
+	"-quantity": "quantity DESC, p.id",
+}
+
+// availableStock is the free quantity of product p that can still be sold:
+// everything not reserved and not blocked by a recall.
+const availableStock = `COALESCE((SELECT SUM(s.quantity) FROM stock s WHERE s.productID = p.id AND s.blocked = FALSE), 0)`
+
+// markdownStock joins md: the lowest markdown price among a product's
+// sellable near-expiry batches and how much stock is marked down.
+const markdownStock = `LEFT JOIN (
+		    SELECT productID, MIN(markdownPrice) AS price, SUM(quantity) AS quantity
+		    FROM stock
+		    WHERE markdownPrice IS NOT NULL AND quantity > 0 AND expirationDate >= CURDATE() AND blocked = FALSE
+		    GROUP BY productID
+		) md ON md.productID = p.id`
+
+// salePrice is the unit price a customer pays for qty of a product: the
+// markdown price shown in the catalog while marked-down stock covers the
+// whole quantity, otherwise the list price.
+func salePrice(price money.Amount, markdown money.NullAmount, markdownQty float64, qty int) money.Amount {
+	if markdown.Valid && float64(qty) <= markdownQty+quantityEpsilon {
+		return markdown.Amount
+	}
+	return price
+}
+
+// likePattern escapes s for use inside a LIKE '%...%' match.
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// GET /api/products/with-stock?q=&category=&sort=&page=&pageSize=
+//
+// q matches the name, the SKU or an exact barcode; category includes its
+// subcategories. Without pageSize every match is returned. The total number
+// of matches is sent in X-Total-Count. Archived products are left out unless
+// archived=true, which lists only those and is for staff. Products with marked-down
+// near-expiry batches carry the markdown price and how much is sold at it.
+// Workers get the list without quantities and can't sort by them, see countsBlind.
 func GetProductsWithStock(w http.ResponseWriter, r *http.Request) {
 	type ProductWithStock struct {
-		ID       int     `json:"id"`
-		Name     string  `json:"productName"`
-		Image    string  `json:"image"`
-		Quantity int     `json:"quantity"`
-		Price    float64 `json:"price"`
+		ID               int      `json:"id"`
+		SKU              *string  `json:"sku"`
+		Name             string   `json:"productName"`
+		Image            string   `json:"image"`
+		Quantity         *int     `json:"quantity,omitempty"`
+		Price            float64  `json:"price"`
+		CategoryID       *int     `json:"categoryId"`
+		MarkdownPrice    *float64 `json:"markdownPrice,omitempty"`
+		MarkdownQuantity float64  `json:"markdownQuantity,omitempty"`
+	}
+
+	query := r.URL.Query()
+	archived := query.Get("archived") == "true"
+	if id, _ := middleware.CurrentUser(r); archived && id.Role == models.RoleCustomer {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	where := []string{"p.archived = ?"}
+	args := []interface{}{archived}
+
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		where = append(where, `(p.productName LIKE ? OR p.sku LIKE ?
+			OR EXISTS (SELECT 1 FROM product_barcodes b WHERE b.productID = p.id AND b.barcode = ?))`)
+		args = append(args, likePattern(q), likePattern(q), q)
+	}
+	if c := query.Get("category"); c != "" {
+		categoryID, err := strconv.Atoi(c)
+		if err != nil {
+			http.Error(w, "Invalid category", http.StatusBadRequest)
+			return
+		}
+		tree, err := loadCategories(db.DB)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		ids := tree.descendants(categoryID)
+		where = append(where, "p.categoryID IN ("+placeholders(len(ids))+")")
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+
+	blind := countsBlind(r)
+	order := productSorts["name"]
+	if s := query.Get("sort"); s != "" {
+		var ok bool
+		if order, ok = productSorts[s]; !ok {
+			http.Error(w, "sort must be one of name, price, quantity, optionally prefixed with -", http.StatusBadRequest)
+			return
+		}
+		if blind && strings.HasSuffix(s, "quantity") {
+			http.Error(w, "Workers can't sort by quantity", http.StatusForbidden)
+			return
+		}
+	}
+
+	var total int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM products p WHERE `+strings.Join(where, " AND "), args...).Scan(&total); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	limit := ""
+	if ps := query.Get("pageSize"); ps != "" {
+		pageSize, err := strconv.Atoi(ps)
+		if err != nil || pageSize < 1 || pageSize > 200 {
+			http.Error(w, "pageSize must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		page := 1
+		if pg := query.Get("page"); pg != "" {
+			if page, err = strconv.Atoi(pg); err != nil || page < 1 {
+				http.Error(w, "Invalid page", http.StatusBadRequest)
+				return
+			}
+		}
+		limit = " LIMIT ? OFFSET ?"
+		args = append(args, pageSize, (page-1)*pageSize)
 	}
 
 	rows, err := db.DB.Query(`
-		SELECT p.id, p.productName, p.image, p.price, COALESCE(SUM(s.quantity), 0) as quantity
+		SELECT p.id, p.sku, p.productName, p.image, p.price, p.categoryID,
+		       `+availableStock+` AS quantity,
+		       md.price, COALESCE(md.quantity, 0)
 		FROM products p
-		LEFT JOIN stock s ON p.id = s.productID
-		GROUP BY p.id, p.productName, p.image, p.price
-	`)
+		`+markdownStock+`
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY `+order+limit, args...)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var products []ProductWithStock
+	products := []ProductWithStock{}
 	for rows.Next() {
 		var p ProductWithStock
-		if err := rows.Scan(&p.ID, &p.Name, &p.Image, &p.Price, &p.Quantity); err != nil {
+		var sku sql.NullString
+		var categoryID sql.NullInt64
+		var qty float64
+		var markdown sql.NullFloat64
+		if err := rows.Scan(&p.ID, &sku, &p.Name, &p.Image, &p.Price, &categoryID, &qty,
+			&markdown, &p.MarkdownQuantity); err != nil {
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
+		if sku.Valid {
+			p.SKU = &sku.String
+		}
+		if categoryID.Valid {
+			c := int(categoryID.Int64)
+			p.CategoryID = &c
+		}
+		if markdown.Valid {
+			p.MarkdownPrice = &markdown.Float64
+		}
+		if blind {
+			p.MarkdownQuantity = 0
+		} else {
+			q := int(qty)
+			p.Quantity = &q
+		}
 		products = append(products, p)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	json.NewEncoder(w).Encode(products)
 }
 
 // GET /api/products?supplierId={id}
+//
+// Lists the products the supplier delivers, either as their main supplier or
+// through the price list, together with the supplier's terms for each.
 func GetProductsBySupplier(w http.ResponseWriter, r *http.Request) {
 	supplierIDStr := r.URL.Query().Get("supplierId")
 	if supplierIDStr == "" {
@@ -64,18 +216,27 @@ func GetProductsBySupplier(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type Product struct {
-		ID          int     `json:"id"`
-		ProductName string  `json:"productName"`
-		UnitType    string  `json:"unitType"`
-		Image       string  `json:"image"`
-		Price       float64 `json:"price"`
+		ID            int      `json:"id"`
+		ProductName   string   `json:"productName"`
+		UnitType      string   `json:"unitType"`
+		Image         string   `json:"image"`
+		Price         float64  `json:"price"`
+		SupplierSKU   *string  `json:"supplierSku"`
+		CostPrice     *float64 `json:"costPrice"`
+		PackSize      *float64 `json:"packSize"`
+		LeadTimeDays  int      `json:"leadTimeDays"`
+		MinOrderValue float64  `json:"minOrderValue"`
 	}
 
 	rows, err := db.DB.Query(`
-        SELECT id, productName, unitType, image, price
-        FROM products
-        WHERE supplierID = ?
-        ORDER BY productName
+        SELECT p.id, p.productName, p.unitType, p.image, p.price,
+               sp.supplierSku, sp.costPrice, sp.packSize, s.leadTimeDays, s.minOrderValue
+        FROM suppliers s
+        JOIN products p ON p.supplierID = s.id
+             OR p.id IN (SELECT productID FROM supplier_products WHERE supplierID = s.id)
+        LEFT JOIN supplier_products sp ON sp.supplierID = s.id AND sp.productID = p.id
+        WHERE s.id = ?
+        ORDER BY p.productName
     `, supplierID)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -86,10 +247,16 @@ func GetProductsBySupplier(w http.ResponseWriter, r *http.Request) {
 	var products []Product
 	for rows.Next() {
 		var p Product
-		if err := rows.Scan(&p.ID, &p.ProductName, &p.UnitType, &p.Image, &p.Price); err != nil {
+		var sku sql.NullString
+		var cost, pack sql.NullFloat64
+		if err := rows.Scan(&p.ID, &p.ProductName, &p.UnitType, &p.Image, &p.Price,
+			&sku, &cost, &pack, &p.LeadTimeDays, &p.MinOrderValue); err != nil {
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
+		if sku.Valid {
+			p.SupplierSKU, p.CostPrice, p.PackSize = &sku.String, &cost.Float64, &pack.Float64
+		}
 		products = append(products, p)
 	}
 
@@ -103,7 +270,12 @@ func GetBatchesForProduct(w http.ResponseWriter, r *http.Request) {
 	idStr = strings.TrimSuffix(idStr, "/batches")
 	id, _ := strconv.Atoi(idStr)
 
-	rows, err := db.DB.Query("SELECT batchID, quantity, expirationDate FROM stock WHERE productID = ? ORDER BY (expirationDate IS NULL), expirationDate", id)
+	rows, err := db.DB.Query(`
+		SELECT s.batchID, s.quantity, s.expirationDate, l.code, s.lotNumber, s.blocked
+		FROM stock s
+		LEFT JOIN locations l ON l.id = s.locationID
+		WHERE s.productID = ?
+		ORDER BY (s.expirationDate IS NULL), s.expirationDate`, id)
 	if err != nil {
 		http.Error(w, "Failed to fetch batches", http.StatusInternalServerError)
 		return
@@ -115,10 +287,19 @@ func GetBatchesForProduct(w http.ResponseWriter, r *http.Request) {
 		var batchID int
 		var qty float64
 		var exp sql.NullTime
-		_ = rows.Scan(&batchID, &qty, &exp)
+		var binCode, lot sql.NullString
+		var blocked bool
+		_ = rows.Scan(&batchID, &qty, &exp, &binCode, &lot, &blocked)
 		item := map[string]interface{}{
 			"batchID":  batchID,
 			"quantity": qty,
+			"blocked":  blocked,
+		}
+		if lot.Valid {
+			item["lotNumber"] = lot.String
+		}
+		if binCode.Valid {
+			item["binCode"] = binCode.String
 		}
 		if exp.Valid {
 			item["expirationDate"] = exp.Time.Format("2006-01-02")
@@ -183,7 +364,24 @@ func GetProductImageHandler(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, file)
 }
 
+// formFloat reads an optional non-negative number from a form field.
+func formFloat(r *http.Request, key string) (*float64, error) {
+	v := strings.TrimSpace(r.FormValue(key))
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return nil, fmt.Errorf("%s must be a non-negative number", key)
+	}
+	return &f, nil
+}
+
 // POST api/products
+//
+// Besides the basic fields the form takes sku (generated from the product
+// ID when empty), description, categoryId, weightKg, lengthCm, widthCm,
+// heightCm and barcodes, a comma-separated list whose symbology is detected.
 func CreateProduct(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseMultipartForm(10 << 20) // 10MB limit
@@ -196,6 +394,35 @@ func CreateProduct(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("productName")
 	unitType := r.FormValue("unitType") //default: unit
 	price, _ := strconv.ParseFloat(r.FormValue("price"), 64)
+	sku := strings.TrimSpace(r.FormValue("sku"))
+	description := r.FormValue("description")
+
+	var categoryID *int
+	if c := r.FormValue("categoryId"); c != "" {
+		id, err := strconv.Atoi(c)
+		if err != nil {
+			http.Error(w, "Invalid categoryId", http.StatusBadRequest)
+			return
+		}
+		categoryID = &id
+	}
+	var dims [4]*float64
+	for i, key := range []string{"weightKg", "lengthCm", "widthCm", "heightCm"} {
+		if dims[i], err = formFloat(r, key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var codes []productBarcode
+	for _, c := range strings.Split(r.FormValue("barcodes"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			codes = append(codes, productBarcode{Code: c})
+		}
+	}
+	if codes, err = checkBarcodes(codes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	imagePath := ""
 	file, header, err := r.FormFile("image")
@@ -229,18 +456,362 @@ func CreateProduct(w http.ResponseWriter, r *http.Request) {
 		imagePath = "images/products/" + filename
 	}
 
-	_, err = db.DB.Exec(
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
 		`INSERT INTO products 
-       (productName, unitType, supplierID, shortExpirationDate, image, price)
-     VALUES (?, ?, ?, NULL, ?, ?)`,
-		name, unitType, supplierID, imagePath, price,
+       (sku, productName, description, unitType, supplierID, categoryID, shortExpirationDate, image, price,
+        weightKg, lengthCm, widthCm, heightCm)
+     VALUES (?, ?, ?, ?, ?, ?, NULL, ?, ?, ?, ?, ?, ?)`,
+		nullString(sku), name, nullString(description), unitType, supplierID, categoryID, imagePath, price,
+		dims[0], dims[1], dims[2], dims[3],
 	)
+	if isDuplicateKey(err) {
+		http.Error(w, "SKU already in use", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Insert failed", http.StatusInternalServerError)
 		return
 	}
+	id, _ := res.LastInsertId()
+
+	if sku == "" {
+		sku = fmt.Sprintf("SKU-%06d", id)
+		if _, err := tx.Exec(`UPDATE products SET sku = ? WHERE id = ?`, sku, id); err != nil {
+			http.Error(w, "Insert failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := setBarcodes(tx, id, codes); err != nil {
+		if errors.Is(err, errBarcodeTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to save barcodes", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "id": id, "sku": sku})
+}
+
+// productBarcode is one of the codes a product can be scanned by. An empty
+// symbology is detected from the code.
+type productBarcode struct {
+	Code      string `json:"code"`
+	Symbology string `json:"symbology"`
+}
+
+// checkBarcodes validates and normalises a product's barcode list.
+func checkBarcodes(codes []productBarcode) ([]productBarcode, error) {
+	seen := make(map[string]bool)
+	out := make([]productBarcode, 0, len(codes))
+	for _, b := range codes {
+		b.Code = strings.TrimSpace(b.Code)
+		if b.Symbology == "" {
+			b.Symbology = barcode.Detect(b.Code)
+		}
+		if err := barcode.Validate(b.Code, b.Symbology); err != nil {
+			return nil, fmt.Errorf("barcode %q: %v", b.Code, err)
+		}
+		if seen[b.Code] {
+			return nil, fmt.Errorf("barcode %q listed twice", b.Code)
+		}
+		seen[b.Code] = true
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// errBarcodeTaken means a barcode already belongs to another product.
+var errBarcodeTaken = errors.New("barcode belongs to another product")
+
+// setBarcodes replaces the barcodes of a product.
+func setBarcodes(tx *sql.Tx, productID int64, codes []productBarcode) error {
+	if _, err := tx.Exec(`DELETE FROM product_barcodes WHERE productID = ?`, productID); err != nil {
+		return err
+	}
+	for _, b := range codes {
+		_, err := tx.Exec(`INSERT INTO product_barcodes (barcode, productID, symbology) VALUES (?, ?, ?)`,
+			b.Code, productID, b.Symbology)
+		if isDuplicateKey(err) {
+			return fmt.Errorf("%w: %s", errBarcodeTaken, b.Code)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// productDetail is the full catalog entry of a product.
+type productDetail struct {
+	ID                  int              `json:"id"`
+	SKU                 *string          `json:"sku"`
+	ProductName         string           `json:"productName"`
+	Description         string           `json:"description"`
+	UnitType            string           `json:"unitType"`
+	SupplierID          int              `json:"supplierId"`
+	CategoryID          *int             `json:"categoryId"`
+	CategoryPath        string           `json:"categoryPath,omitempty"`
+	ShortExpirationDate *int             `json:"shortExpirationDate"`
+	Image               string           `json:"image"`
+	Price               float64          `json:"price"`
+	WeightKg            *float64         `json:"weightKg"`
+	LengthCm            *float64         `json:"lengthCm"`
+	WidthCm             *float64         `json:"widthCm"`
+	HeightCm            *float64         `json:"heightCm"`
+	Quantity            *float64         `json:"quantity,omitempty"`
+	Archived            bool             `json:"archived"`
+	Barcodes            []productBarcode `json:"barcodes"`
+}
+
+func loadProduct(q queryer, id int) (*productDetail, error) {
+	p := &productDetail{Barcodes: []productBarcode{}}
+	var sku, description, image sql.NullString
+	var categoryID, shortExp sql.NullInt64
+	var weight, length, width, height sql.NullFloat64
+	var qty float64
+	err := q.QueryRow(`
+		SELECT p.id, p.sku, p.productName, p.description, p.unitType, p.supplierID, p.categoryID,
+		       p.shortExpirationDate, p.image, p.price, p.weightKg, p.lengthCm, p.widthCm, p.heightCm,
+		       p.archived, COALESCE((SELECT SUM(s.quantity) FROM stock s WHERE s.productID = p.id), 0)
+		FROM products p WHERE p.id = ?
+	`, id).Scan(&p.ID, &sku, &p.ProductName, &description, &p.UnitType, &p.SupplierID, &categoryID,
+		&shortExp, &image, &p.Price, &weight, &length, &width, &height, &p.Archived, &qty)
+	if err != nil {
+		return nil, err
+	}
+	p.Quantity = &qty
+	if sku.Valid {
+		p.SKU = &sku.String
+	}
+	p.Description, p.Image = description.String, image.String
+	if categoryID.Valid {
+		c := int(categoryID.Int64)
+		p.CategoryID = &c
+	}
+	if shortExp.Valid {
+		d := int(shortExp.Int64)
+		p.ShortExpirationDate = &d
+	}
+	for _, f := range []struct {
+		src sql.NullFloat64
+		dst **float64
+	}{{weight, &p.WeightKg}, {length, &p.LengthCm}, {width, &p.WidthCm}, {height, &p.HeightCm}} {
+		if f.src.Valid {
+			v := f.src.Float64
+			*f.dst = &v
+		}
+	}
+
+	rows, err := q.Query(`SELECT barcode, symbology FROM product_barcodes WHERE productID = ? ORDER BY barcode`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b productBarcode
+		if err := rows.Scan(&b.Code, &b.Symbology); err != nil {
+			return nil, err
+		}
+		p.Barcodes = append(p.Barcodes, b)
+	}
+	return p, rows.Err()
+}
+
+func productIDFromPath(r *http.Request) (int, error) {
+	return strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/products/"))
+}
+
+// GET /api/products/{id}
+//
+// Archived products are only shown to staff. Workers get the product
+// without its stock quantity, see countsBlind.
+func GetProduct(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := productIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	p, err := loadProduct(db.DB, id)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if user, _ := middleware.CurrentUser(r); err == sql.ErrNoRows || p.Archived && user.Role == models.RoleCustomer {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if p.CategoryID != nil {
+		if tree, err := loadCategories(db.DB); err == nil {
+			p.CategoryPath = tree.path(*p.CategoryID)
+		}
+	}
+	if countsBlind(r) {
+		p.Quantity = nil
+	}
+	json.NewEncoder(w).Encode(p)
+}
+
+// PUT /api/products/{id}
+//
+// Replaces the product's catalog data. Barcodes are only touched when the
+// list is sent; archived=false restores an archived product.
+func UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := productIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		SKU                 string            `json:"sku"`
+		ProductName         string            `json:"productName"`
+		Description         string            `json:"description"`
+		UnitType            string            `json:"unitType"`
+		SupplierID          int               `json:"supplierId"`
+		CategoryID          *int              `json:"categoryId"`
+		ShortExpirationDate *int              `json:"shortExpirationDate"`
+		Price               float64           `json:"price"`
+		WeightKg            *float64          `json:"weightKg"`
+		LengthCm            *float64          `json:"lengthCm"`
+		WidthCm             *float64          `json:"widthCm"`
+		HeightCm            *float64          `json:"heightCm"`
+		Archived            *bool             `json:"archived"`
+		Barcodes            *[]productBarcode `json:"barcodes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	payload.SKU = strings.TrimSpace(payload.SKU)
+	payload.ProductName = strings.TrimSpace(payload.ProductName)
+	if payload.SKU == "" || payload.ProductName == "" || payload.UnitType == "" || payload.SupplierID == 0 {
+		http.Error(w, "sku, productName, unitType and supplierId are required", http.StatusBadRequest)
+		return
+	}
+	if payload.Price < 0 {
+		http.Error(w, "price can't be negative", http.StatusBadRequest)
+		return
+	}
+	for _, v := range []*float64{payload.WeightKg, payload.LengthCm, payload.WidthCm, payload.HeightCm} {
+		if v != nil && *v < 0 {
+			http.Error(w, "weight and dimensions can't be negative", http.StatusBadRequest)
+			return
+		}
+	}
+	var codes []productBarcode
+	if payload.Barcodes != nil {
+		if codes, err = checkBarcodes(*payload.Barcodes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var archived bool
+	if err := tx.QueryRow(`SELECT archived FROM products WHERE id = ? FOR UPDATE`, id).Scan(&archived); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if payload.Archived != nil {
+		archived = *payload.Archived
+	}
+
+	_, err = tx.Exec(`
+		UPDATE products
+		SET sku = ?, productName = ?, description = ?, unitType = ?, supplierID = ?, categoryID = ?,
+		    shortExpirationDate = ?, price = ?, weightKg = ?, lengthCm = ?, widthCm = ?, heightCm = ?,
+		    archived_at = CASE WHEN ? THEN COALESCE(archived_at, NOW()) END, archived = ?
+		WHERE id = ?
+	`, payload.SKU, payload.ProductName, nullString(payload.Description), payload.UnitType, payload.SupplierID,
+		payload.CategoryID, payload.ShortExpirationDate, payload.Price,
+		payload.WeightKg, payload.LengthCm, payload.WidthCm, payload.HeightCm, archived, archived, id)
+	if isDuplicateKey(err) {
+		http.Error(w, "SKU already in use", http.StatusConflict)
+		return
+	}
+	if isMissingReference(err) {
+		http.Error(w, "Unknown supplier or category", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Update failed", http.StatusInternalServerError)
+		return
+	}
+	if payload.Barcodes != nil {
+		if err := setBarcodes(tx, int64(id), codes); err != nil {
+			if errors.Is(err, errBarcodeTaken) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, "Failed to save barcodes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	p, err := loadProduct(tx, id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(p)
+}
+
+// DELETE /api/products/{id}
+//
+// Products are archived rather than deleted so past requests, orders and
+// the inventory ledger keep pointing at them.
+func ArchiveProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := productIDFromPath(r)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.DB.Exec(`
+		UPDATE products SET archived = TRUE, archived_at = COALESCE(archived_at, NOW()) WHERE id = ?
+	`, id)
+	if err != nil {
+		http.Error(w, "Archive failed", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		db.DB.QueryRow(`SELECT COUNT(*) FROM products WHERE id = ?`, id).Scan(&exists)
+		if exists == 0 {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -2,23 +2,152 @@ package handlers
 
 import (
 	"backend/internal/db"
+	"backend/internal/money"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 )
 
+// orderLine is one product and whole quantity a customer orders.
+type orderLine struct {
+	ProductID int `json:"productID"`
+	Quantity  int `json:"quantity"`
+}
+
+// orderTotals values a purchase request at the prices it was placed at.
+type orderTotals struct {
+	Subtotal money.Amount `json:"subtotal"`
+	TaxRate  money.Rate   `json:"taxRate"`
+	Tax      money.Amount `json:"tax"`
+	Total    money.Amount `json:"total"`
+}
+
+// taxRate is the sales tax put on orders (TAX_RATE_PERCENT, default 0).
+func taxRate() money.Rate {
+	if r, err := money.ParseRate(os.Getenv("TAX_RATE_PERCENT")); err == nil && r > 0 {
+		return r
+	}
+	return 0
+}
+
+// priceOrder adds tax at rate to a subtotal. Tax is rounded once on the
+// whole order, not per line.
+func priceOrder(subtotal money.Amount, rate money.Rate) orderTotals {
+	t := orderTotals{Subtotal: subtotal, TaxRate: rate}
+	t.Tax = rate.Of(subtotal)
+	t.Total = t.Subtotal + t.Tax
+	return t
+}
+
+// storedTotals turns the totals columns of a purchase request into
+// orderTotals, or nil for requests placed before orders were priced.
+// taxRate is read as an amount since both are hundredths.
+func storedTotals(subtotal, rate, tax, total money.NullAmount) *orderTotals {
+	if !subtotal.Valid {
+		return nil
+	}
+	return &orderTotals{
+		Subtotal: subtotal.Amount,
+		TaxRate:  money.Rate(rate.Amount),
+		Tax:      tax.Amount,
+		Total:    total.Amount,
+	}
+}
+
+// pricedLine is the price of an order line; both are left out for lines
+// ordered before prices were captured.
+type pricedLine struct {
+	UnitPrice *money.Amount `json:"unitPrice,omitempty"`
+	LineTotal *money.Amount `json:"lineTotal,omitempty"`
+}
+
+func priceLine(unitPrice money.NullAmount, qty int) pricedLine {
+	if !unitPrice.Valid {
+		return pricedLine{}
+	}
+	total := unitPrice.Amount.Times(qty)
+	return pricedLine{UnitPrice: &unitPrice.Amount, LineTotal: &total}
+}
+
+// insertPurchaseRequest creates a pending request for the user, snapshotting
+// each product's current sale price on its line, and stores the totals. It
+// returns the per-line problems instead when any line is invalid.
+func insertPurchaseRequest(tx *sql.Tx, userID int, lines []orderLine, actorID int) (int64, *orderTotals, []lineError, error) {
+	// Archived products stay in the catalog for history but can't be ordered.
+	var problems []lineError
+	prices := make([]money.Amount, len(lines))
+	for i, item := range lines {
+		var archived bool
+		var price money.Amount
+		var markdown money.NullAmount
+		var markdownQty float64
+		err := tx.QueryRow(`
+			SELECT p.price, md.price, COALESCE(md.quantity, 0), p.archived
+			FROM products p
+			`+markdownStock+`
+			WHERE p.id = ?
+		`, item.ProductID).Scan(&price, &markdown, &markdownQty, &archived)
+		prices[i] = salePrice(price, markdown, markdownQty, item.Quantity)
+		switch {
+		case err == sql.ErrNoRows:
+			problems = append(problems, lineError{Line: i, Error: fmt.Sprintf("product %d does not exist", item.ProductID)})
+		case err != nil:
+			return 0, nil, nil, err
+		case archived:
+			problems = append(problems, lineError{Line: i, Error: fmt.Sprintf("product %d is archived", item.ProductID)})
+		case item.Quantity <= 0:
+			problems = append(problems, lineError{Line: i, Error: "quantity must be positive"})
+		}
+	}
+	if len(lines) == 0 {
+		problems = append(problems, lineError{Error: "no items to order"})
+	}
+	if len(problems) > 0 {
+		return 0, nil, problems, nil
+	}
+
+	var subtotal money.Amount
+	for i, item := range lines {
+		subtotal += prices[i].Times(item.Quantity)
+	}
+	totals := priceOrder(subtotal, taxRate())
+
+	res, err := tx.Exec(
+		`INSERT INTO purchase_requests (userID, subtotal, taxRate, tax, total) VALUES (?, ?, ?, ?, ?)`,
+		userID, totals.Subtotal, totals.TaxRate, totals.Tax, totals.Total,
+	)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	requestID, _ := res.LastInsertId()
+
+	if err := recordRequestEvent(tx, int(requestID), "", "pending", actorID, ""); err != nil {
+		return 0, nil, nil, err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO purchase_items (requestID, productID, quantity, unitPrice) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer stmt.Close()
+	for i, item := range lines {
+		if _, err := stmt.Exec(requestID, item.ProductID, item.Quantity, prices[i]); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	return requestID, &totals, nil, nil
+}
+
 // POST /api/purchase
 func CreatePurchaseRequest(w http.ResponseWriter, r *http.Request) {
-	type Item struct {
-		ProductID int `json:"productID"`
-		Quantity  int `json:"quantity"`
-	}
 	type PurchasePayload struct {
-		UserID int    `json:"userID"`
-		Items  []Item `json:"items"`
+		UserID int         `json:"userID"`
+		Items  []orderLine `json:"items"`
 	}
 
 	var payload PurchasePayload
@@ -26,6 +155,10 @@ func CreatePurchaseRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid payload", http.StatusBadRequest)
 		return
 	}
+	if !canAccessUser(r, payload.UserID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	tx, err := db.DB.Begin()
 	if err != nil {
@@ -34,39 +167,38 @@ func CreatePurchaseRequest(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	//log.Printf("Creating purchase request for userID: %d", payload.UserID)
-	res, err := tx.Exec("INSERT INTO purchase_requests (userID) VALUES (?)", payload.UserID)
+	requestID, totals, problems, err := insertPurchaseRequest(tx, payload.UserID, payload.Items, actorID(r))
 	if err != nil {
+		log.Printf("Purchase request error: %v", err)
 		http.Error(w, "Failed to create request", http.StatusInternalServerError)
 		return
 	}
-	requestID, _ := res.LastInsertId()
-
-	stmt, err := tx.Prepare("INSERT INTO purchase_items (requestID, productID, quantity) VALUES (?, ?, ?)")
-	if err != nil {
-		http.Error(w, "Statement error", http.StatusInternalServerError)
+	if len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Invalid items",
+			"lines": problems,
+		})
 		return
 	}
-	defer stmt.Close()
-
-	for _, item := range payload.Items {
-		_, err := stmt.Exec(requestID, item.ProductID, item.Quantity)
-		if err != nil {
-			http.Error(w, "Insert item error", http.StatusInternalServerError)
-			return
-		}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create request", http.StatusInternalServerError)
+		return
 	}
-	tx.Commit()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Purchase request submitted",
-	})
+	json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+		ID      int64  `json:"id"`
+		*orderTotals
+	}{"Purchase request submitted", requestID, totals})
 }
 
 // GET /api/purchase-requests
 func GetAllPurchaseRequests(w http.ResponseWriter, r *http.Request) {
 	rows, err := db.DB.Query(`
-		SELECT r.id, r.userID, u.email, r.status, r.created_at, i.productID, i.quantity, p.productName
+		SELECT r.id, r.userID, u.email, r.status, r.parentRequestID, r.created_at, i.productID, i.quantity, p.productName,
+		       i.unitPrice, r.subtotal, r.taxRate, r.tax, r.total
 		FROM purchase_requests r
 		JOIN users u ON r.userID = u.id
 		JOIN purchase_items i ON r.id = i.requestID
@@ -83,14 +215,17 @@ func GetAllPurchaseRequests(w http.ResponseWriter, r *http.Request) {
 		ProductID   int    `json:"productID"`
 		ProductName string `json:"productName"`
 		Quantity    int    `json:"quantity"`
+		pricedLine
 	}
 	type Request struct {
 		ID        int    `json:"id"`
 		UserID    int    `json:"userID"`
 		Email     string `json:"email"`
 		Status    string `json:"status"`
+		ParentID  *int   `json:"parentRequestID"`
 		CreatedAt string `json:"created_at"`
 		Items     []Item `json:"items"`
+		*orderTotals
 	}
 
 	requestsMap := make(map[int]*Request)
@@ -98,16 +233,25 @@ func GetAllPurchaseRequests(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var rid, uid, pid, qty int
 		var email, status, createdAt, pname string
-		if err := rows.Scan(&rid, &uid, &email, &status, &createdAt, &pid, &qty, &pname); err != nil {
+		var parentID sql.NullInt64
+		var unitPrice, subtotal, rate, tax, total money.NullAmount
+		if err := rows.Scan(&rid, &uid, &email, &status, &parentID, &createdAt, &pid, &qty, &pname,
+			&unitPrice, &subtotal, &rate, &tax, &total); err != nil {
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
 		req, exists := requestsMap[rid]
 		if !exists {
-			req = &Request{ID: rid, UserID: uid, Email: email, Status: status, CreatedAt: createdAt}
+			req = &Request{ID: rid, UserID: uid, Email: email, Status: status, CreatedAt: createdAt,
+				orderTotals: storedTotals(subtotal, rate, tax, total)}
+			if parentID.Valid {
+				p := int(parentID.Int64)
+				req.ParentID = &p
+			}
 			requestsMap[rid] = req
 		}
-		req.Items = append(req.Items, Item{ProductID: pid, ProductName: pname, Quantity: qty})
+		req.Items = append(req.Items, Item{ProductID: pid, ProductName: pname, Quantity: qty,
+			pricedLine: priceLine(unitPrice, qty)})
 	}
 
 	var allRequests []Request
@@ -127,16 +271,37 @@ func GetPurchaseRequestsByUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User ID missing", http.StatusBadRequest)
 		return
 	}
-	userID := parts[len(parts)-1]
+	userID, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if !canAccessUser(r, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
+	// Backorder shipments are child requests; their quantities are folded
+	// into the lines of the original request instead of listed separately.
 	rows, err := db.DB.Query(`
         SELECT r.id, r.userID, u.email, r.status, r.created_at,
-               i.productID, i.quantity, p.productName
+               i.productID, i.quantity, p.productName,
+               i.unitPrice, r.subtotal, r.taxRate, r.tax, r.total,
+               IF(r.status IN ('shipped', 'delivered'),
+                  (SELECT IFNULL(SUM(ab.quantity), 0) FROM assigned_batches ab WHERE ab.itemID = i.id), 0)
+               + (SELECT IFNULL(SUM(ci.quantity), 0)
+                    FROM backorders b
+                    JOIN purchase_items ci ON ci.backorderID = b.id
+                    JOIN purchase_requests cr ON cr.id = ci.requestID
+                   WHERE b.itemID = i.id AND cr.status IN ('shipped', 'delivered')),
+               (SELECT IFNULL(SUM(b.quantity - b.fulfilled), 0)
+                  FROM backorders b
+                 WHERE b.itemID = i.id AND b.status = 'open')
         FROM purchase_requests r
         JOIN users u ON r.userID = u.id
         JOIN purchase_items i ON r.id = i.requestID
         JOIN products p ON i.productID = p.id
-        WHERE r.userID = ?
+        WHERE r.userID = ? AND r.parentRequestID IS NULL
         ORDER BY r.id DESC
     `, userID)
 	if err != nil {
@@ -146,9 +311,12 @@ func GetPurchaseRequestsByUser(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	type Item struct {
-		ProductID   int    `json:"productID"`
-		ProductName string `json:"productName"`
-		Quantity    int    `json:"quantity"`
+		ProductID   int     `json:"productID"`
+		ProductName string  `json:"productName"`
+		Quantity    int     `json:"quantity"`
+		Shipped     float64 `json:"shippedQuantity"`
+		Backordered float64 `json:"backorderedQuantity"`
+		pricedLine
 	}
 	type Request struct {
 		ID        int    `json:"id"`
@@ -157,6 +325,7 @@ func GetPurchaseRequestsByUser(w http.ResponseWriter, r *http.Request) {
 		Status    string `json:"status"`
 		CreatedAt string `json:"created_at"`
 		Items     []Item `json:"items"`
+		*orderTotals
 	}
 
 	requestsMap := make(map[int]*Request)
@@ -164,18 +333,22 @@ func GetPurchaseRequestsByUser(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var rid, uid, pid, qty int
 		var email, status, createdAt, pname string
-		if err := rows.Scan(&rid, &uid, &email, &status, &createdAt, &pid, &qty, &pname); err != nil {
+		var shipped, backordered float64
+		var unitPrice, subtotal, rate, tax, total money.NullAmount
+		if err := rows.Scan(&rid, &uid, &email, &status, &createdAt, &pid, &qty, &pname,
+			&unitPrice, &subtotal, &rate, &tax, &total, &shipped, &backordered); err != nil {
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
 		req, exists := requestsMap[rid]
 		if !exists {
 			req = &Request{
-				ID:        rid,
-				UserID:    uid,
-				Email:     email,
-				Status:    status,
-				CreatedAt: createdAt,
+				ID:          rid,
+				UserID:      uid,
+				Email:       email,
+				Status:      status,
+				CreatedAt:   createdAt,
+				orderTotals: storedTotals(subtotal, rate, tax, total),
 			}
 			requestsMap[rid] = req
 		}
@@ -183,6 +356,9 @@ func GetPurchaseRequestsByUser(w http.ResponseWriter, r *http.Request) {
 			ProductID:   pid,
 			ProductName: pname,
 			Quantity:    qty,
+			Shipped:     shipped,
+			Backordered: backordered,
+			pricedLine:  priceLine(unitPrice, qty),
 		})
 	}
 
@@ -206,7 +382,8 @@ func GetPurchaseRequestByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rows, err := db.DB.Query(`
-		SELECT r.id, r.userID, r.status, r.created_at, i.productID, i.quantity, p.productName
+		SELECT r.id, r.userID, r.status, r.created_at, i.productID, i.quantity, p.productName,
+		       i.unitPrice, r.subtotal, r.taxRate, r.tax, r.total
 		FROM purchase_requests r
 		JOIN purchase_items i ON r.id = i.requestID
 		JOIN products p ON i.productID = p.id
@@ -222,6 +399,7 @@ func GetPurchaseRequestByID(w http.ResponseWriter, r *http.Request) {
 		ProductID   int    `json:"productID"`
 		ProductName string `json:"productName"`
 		Quantity    int    `json:"quantity"`
+		pricedLine
 	}
 	type Request struct {
 		ID        int    `json:"id"`
@@ -229,6 +407,7 @@ func GetPurchaseRequestByID(w http.ResponseWriter, r *http.Request) {
 		Status    string `json:"status"`
 		CreatedAt string `json:"created_at"`
 		Items     []Item `json:"items"`
+		*orderTotals
 	}
 
 	var req *Request
@@ -236,19 +415,23 @@ func GetPurchaseRequestByID(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var rid, uid, pid, qty int
 		var status, createdAt, pname string
-		if err := rows.Scan(&rid, &uid, &status, &createdAt, &pid, &qty, &pname); err != nil {
+		var unitPrice, subtotal, rate, tax, total money.NullAmount
+		if err := rows.Scan(&rid, &uid, &status, &createdAt, &pid, &qty, &pname,
+			&unitPrice, &subtotal, &rate, &tax, &total); err != nil {
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
 		if req == nil {
 			req = &Request{
-				ID:        rid,
-				UserID:    uid,
-				Status:    status,
-				CreatedAt: createdAt,
+				ID:          rid,
+				UserID:      uid,
+				Status:      status,
+				CreatedAt:   createdAt,
+				orderTotals: storedTotals(subtotal, rate, tax, total),
 			}
 		}
-		req.Items = append(req.Items, Item{ProductID: pid, ProductName: pname, Quantity: qty})
+		req.Items = append(req.Items, Item{ProductID: pid, ProductName: pname, Quantity: qty,
+			pricedLine: priceLine(unitPrice, qty)})
 	}
 
 	if req == nil {
@@ -260,75 +443,133 @@ func GetPurchaseRequestByID(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(req)
 }
 
-// POST /api/purchase-requests/{id}/accept or /deny
+// POST /api/purchase-requests/{id}/accept[?partial=true] or /deny
 func HandleRequestStatus(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/api/purchase-requests/")
-	id = strings.TrimSuffix(id, "/accept")
-	id = strings.TrimSuffix(id, "/deny")
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/purchase-requests/")
+	idStr = strings.TrimSuffix(idStr, "/accept")
+	idStr = strings.TrimSuffix(idStr, "/deny")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	status := "accepted"
 	if strings.HasSuffix(r.URL.Path, "/accept") {
-		ok, err := validateFullAssignment(id)
+		current, err := lockRequestStatus(tx, id)
 		if err != nil {
-			http.Error(w, "Failed to validate assignment", http.StatusInternalServerError)
+			writeTransitionError(w, err)
 			return
 		}
-		if !ok {
-			http.Error(w, "Incomplete or invalid batch assignment", http.StatusBadRequest)
+		if !canTransition(current, "accepted") {
+			writeTransitionError(w, errIllegalTransition)
 			return
 		}
 
-		var exists int
-		err = db.DB.QueryRow(`SELECT COUNT(*) FROM tasks WHERE requestID = ?`, id).Scan(&exists)
+		coverage, err := loadCoverage(tx, id)
 		if err != nil {
-			http.Error(w, "Failed to check existing task", http.StatusInternalServerError)
+			http.Error(w, "Failed to validate assignment", http.StatusInternalServerError)
+			return
+		}
+		partial, _ := strconv.ParseBool(r.URL.Query().Get("partial"))
+		full, some := true, false
+		for _, c := range coverage {
+			if c.Assigned+quantityEpsilon < c.Required {
+				full = false
+			}
+			if c.Assigned > quantityEpsilon {
+				some = true
+			}
+		}
+		if !full && !partial {
+			http.Error(w, "Incomplete or invalid batch assignment", http.StatusBadRequest)
+			return
+		}
+		if !some {
+			http.Error(w, "Nothing is assigned to ship", http.StatusBadRequest)
 			return
 		}
-		if exists == 0 {
-			_, err = db.DB.Exec(`INSERT INTO tasks (requestID, workerID, type) VALUES (?, ?, ?)`, id, 1, "prepare")
-			if err != nil {
-				http.Error(w, "Failed to assign task", http.StatusInternalServerError)
+
+		// Whatever isn't covered now is shipped later from incoming stock.
+		if !full {
+			if err := createBackorders(tx, id, coverage); err != nil {
+				log.Printf("Backorder error: %v", err)
+				http.Error(w, "Failed to create backorders", http.StatusInternalServerError)
 				return
 			}
 		}
+
+		if err := createPrepareTask(tx, id); err != nil {
+			http.Error(w, "Failed to assign task", http.StatusInternalServerError)
+			return
+		}
 	}
 
 	if strings.HasSuffix(r.URL.Path, "/deny") {
 		status = "denied"
 	}
-	_, err := db.DB.Exec("UPDATE purchase_requests SET status = ? WHERE id = ?", status, id)
-	if err != nil {
+	if err := transitionRequest(tx, id, status, actorID(r), ""); err != nil {
+		writeTransitionError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
 		http.Error(w, "Failed to update request", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("Request %s marked as %s", id, status)
+	log.Printf("Request %d marked as %s", id, status)
 	w.Write([]byte("OK"))
 }
 
-func validateFullAssignment(requestID string) (bool, error) {
-	query := `
-		SELECT pi.id, pi.quantity, IFNULL(SUM(ab.quantity), 0)
+// itemCoverage compares what an item needs with what is reserved for it.
+type itemCoverage struct {
+	ItemID    int
+	ProductID int
+	Required  float64
+	Assigned  float64
+}
+
+func loadCoverage(tx *sql.Tx, requestID int) ([]itemCoverage, error) {
+	rows, err := tx.Query(`
+		SELECT pi.id, pi.productID, pi.quantity, IFNULL(SUM(ab.quantity), 0)
 		FROM purchase_items pi
 		LEFT JOIN assigned_batches ab ON ab.itemID = pi.id
 		WHERE pi.requestID = ?
-		GROUP BY pi.id
-	`
-	rows, err := db.DB.Query(query, requestID)
+		GROUP BY pi.id, pi.productID, pi.quantity
+	`, requestID)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	defer rows.Close()
 
+	var out []itemCoverage
 	for rows.Next() {
-		var itemID int
-		var required, assigned float64
-		if err := rows.Scan(&itemID, &required, &assigned); err != nil {
-			return false, err
-		}
-		if assigned != required {
-			return false, nil // Not fully assigned
+		var c itemCoverage
+		if err := rows.Scan(&c.ItemID, &c.ProductID, &c.Required, &c.Assigned); err != nil {
+			return nil, err
 		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// createPrepareTask queues the picking task for an accepted request, once.
+func createPrepareTask(tx *sql.Tx, requestID int) error {
+	var exists int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM tasks WHERE requestID = ?`, requestID).Scan(&exists); err != nil {
+		return err
 	}
-	return true, nil
+	if exists > 0 {
+		return nil
+	}
+	_, err := insertTask(tx, "prepare", requestID, nil, 0)
+	return err
 }
 
 // GET /api/purchase-requests/{id}/details
@@ -427,13 +668,8 @@ func AssignBatches(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type BatchAssignment struct {
-		ItemID   int     `json:"itemID"`
-		BatchID  int     `json:"batchID"`
-		Quantity float64 `json:"quantity"`
-	}
 	var payload struct {
-		Batches []BatchAssignment `json:"batches"`
+		Batches []batchLine `json:"batches"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "Invalid payload", http.StatusBadRequest)
@@ -447,32 +683,42 @@ func AssignBatches(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	// Clear previous assignments for the request
-	res, err := tx.Exec(`DELETE ab FROM assigned_batches ab
-		JOIN purchase_items pi ON ab.itemID = pi.id
-		WHERE pi.requestID = ?`, requestID)
+	// Lock the request so two admins can't reassign it at the same time.
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM purchase_requests WHERE id = ? FOR UPDATE`, requestID).Scan(&status); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to load request", http.StatusInternalServerError)
+		return
+	}
+	if status != "pending" {
+		http.Error(w, "Only pending requests can be reassigned", http.StatusConflict)
+		return
+	}
 
-	if err != nil {
+	// Put the previous reservation back before validating the new one.
+	if err := releaseReservations(tx, requestID, actorID(r)); err != nil {
+		log.Printf("AssignBatches release error: %v", err)
 		http.Error(w, "Failed to clear previous assignments", http.StatusInternalServerError)
 		return
 	}
 
-	count, _ := res.RowsAffected()
-	log.Printf("Deleted %d previous assignments", count)
-
-	for _, b := range payload.Batches {
-		log.Printf("Assigning: itemID=%d, batchID=%d, quantity=%.2f", b.ItemID, b.BatchID, b.Quantity)
-		_, err := tx.Exec(`INSERT INTO assigned_batches (itemID, batchID, quantity) VALUES (?, ?, ?)`,
-			b.ItemID, b.BatchID, b.Quantity)
-		if err != nil {
-			http.Error(w, "Failed to assign batch", http.StatusInternalServerError)
-			return
-		}
-		_, err = tx.Exec(`UPDATE stock SET quantity = quantity - ? WHERE batchID = ?`, b.Quantity, b.BatchID)
-		if err != nil {
-			http.Error(w, "Failed to update stock", http.StatusInternalServerError)
-			return
-		}
+	problems, err := reserveBatches(tx, requestID, payload.Batches, actorID(r))
+	if err != nil {
+		log.Printf("AssignBatches reserve error: %v", err)
+		http.Error(w, "Failed to assign batch", http.StatusInternalServerError)
+		return
+	}
+	if len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Invalid batch assignment",
+			"lines": problems,
+		})
+		return
 	}
 
 	if err := tx.Commit(); err != nil {
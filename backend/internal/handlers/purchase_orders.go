@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+)
+
+// poLine is one product line on a supplier purchase order.
+type poLine struct {
+	ProductID      int     `json:"productId"`
+	Quantity       float64 `json:"quantity"`
+	ExpirationDate *string `json:"expirationDate"` // optional, in "YYYY-MM-DD"
+}
+
+// POST /api/purchase-orders
+//
+// Creates an order to one supplier. Every line becomes an expected delivery
+// in orderedProducts; with createTasks an unload task is dispatched per line.
+func CreatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var payload struct {
+		SupplierID   int      `json:"supplierId"`
+		ExpectedDate *string  `json:"expectedDate"`
+		Lines        []poLine `json:"lines"`
+		CreateTasks  bool     `json:"createTasks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(payload.Lines) == 0 {
+		http.Error(w, "At least one line required", http.StatusBadRequest)
+		return
+	}
+	if payload.ExpectedDate != nil {
+		expected, err := time.ParseInLocation("2006-01-02", *payload.ExpectedDate, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid expectedDate, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		y, m, d := time.Now().Date()
+		if expected.Before(time.Date(y, m, d, 0, 0, 0, 0, time.Local)) {
+			http.Error(w, "expectedDate can't be in the past", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var active bool
+	err = tx.QueryRow(`SELECT active FROM suppliers WHERE id = ?`, payload.SupplierID).Scan(&active)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Unknown supplier", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !active {
+		http.Error(w, "Supplier is inactive", http.StatusConflict)
+		return
+	}
+
+	problems, err := checkPurchaseOrder(tx, payload.SupplierID, payload.Lines)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Invalid order lines",
+			"lines": problems,
+		})
+		return
+	}
+
+	orderID, lineIDs, err := insertPurchaseOrder(tx, payload.SupplierID, payload.ExpectedDate, payload.Lines, payload.CreateTasks, actorID(r))
+	if err != nil {
+		log.Printf("CreatePurchaseOrder error: %v", err)
+		http.Error(w, "Failed to create purchase order", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": orderID, "lineIds": lineIDs})
+}
+
+// checkPurchaseOrder validates the lines of an order to a supplier. Every
+// line needs a cost price on the supplier's price list, so the order can be
+// valued against the supplier's minimum order value.
+func checkPurchaseOrder(q queryer, supplierID int, lines []poLine) ([]lineError, error) {
+	var minOrderValue float64
+	if err := q.QueryRow(`SELECT minOrderValue FROM suppliers WHERE id = ?`, supplierID).Scan(&minOrderValue); err != nil {
+		return nil, err
+	}
+
+	var problems []lineError
+	var orderValue float64
+	for i, l := range lines {
+		if l.Quantity <= 0 {
+			problems = append(problems, lineError{Line: i, Error: "quantity must be positive"})
+			continue
+		}
+		var productSupplierID int
+		var cost sql.NullFloat64
+		err := q.QueryRow(`
+			SELECT p.supplierID, sp.costPrice
+			FROM products p
+			LEFT JOIN supplier_products sp ON sp.productID = p.id AND sp.supplierID = ?
+			WHERE p.id = ?
+		`, supplierID, l.ProductID).Scan(&productSupplierID, &cost)
+		if err == sql.ErrNoRows {
+			problems = append(problems, lineError{Line: i, Error: fmt.Sprintf("product %d does not exist", l.ProductID)})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !cost.Valid {
+			if productSupplierID != supplierID {
+				problems = append(problems, lineError{Line: i, Error: fmt.Sprintf("product %d is not supplied by supplier %d", l.ProductID, supplierID)})
+			} else {
+				problems = append(problems, lineError{Line: i, Error: fmt.Sprintf("product %d has no cost price from supplier %d", l.ProductID, supplierID)})
+			}
+			continue
+		}
+		orderValue += cost.Float64 * l.Quantity
+	}
+	if len(problems) == 0 && orderValue < minOrderValue {
+		problems = append(problems, lineError{Line: -1, Error: fmt.Sprintf("order value %.2f is below the supplier minimum of %.2f", orderValue, minOrderValue)})
+	}
+	return problems, nil
+}
+
+// insertPurchaseOrder writes an already validated order and its lines, and
+// dispatches an unload task per line when createTasks is set.
+func insertPurchaseOrder(tx *sql.Tx, supplierID int, expectedDate interface{}, lines []poLine, createTasks bool, actorID int) (int64, []int64, error) {
+	res, err := tx.Exec(
+		`INSERT INTO purchase_orders (supplierID, expectedDate, createdBy) VALUES (?, ?, ?)`,
+		supplierID, expectedDate, nullInt(actorID),
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	orderID, err := res.LastInsertId()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	lineIDs := []int64{}
+	for _, l := range lines {
+		res, err := tx.Exec(
+			`INSERT INTO orderedProducts (productID, quantity, expirationDate, purchaseOrderID) VALUES (?, ?, ?, ?)`,
+			l.ProductID, l.Quantity, l.ExpirationDate, orderID,
+		)
+		if err != nil {
+			return 0, nil, err
+		}
+		lineID, err := res.LastInsertId()
+		if err != nil {
+			return 0, nil, err
+		}
+		lineIDs = append(lineIDs, lineID)
+
+		if createTasks {
+			if _, err := insertTask(tx, "unload", nil, lineID, 0); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+	return orderID, lineIDs, nil
+}
+
+type purchaseOrderLine struct {
+	ID               int      `json:"id"`
+	ProductID        int      `json:"productId"`
+	ProductName      string   `json:"productName"`
+	Quantity         float64  `json:"quantity"`
+	ReceivedQuantity *float64 `json:"receivedQuantity"`
+	DamagedQuantity  *float64 `json:"damagedQuantity"`
+	ReceivedAt       *string  `json:"receivedAt"`
+}
+
+type purchaseOrder struct {
+	ID           int                 `json:"id"`
+	SupplierID   int                 `json:"supplierId"`
+	SupplierName string              `json:"supplierName"`
+	Status       string              `json:"status"`
+	ExpectedDate *string             `json:"expectedDate"`
+	CreatedAt    string              `json:"created_at"`
+	Lines        []purchaseOrderLine `json:"lines,omitempty"`
+}
+
+func scanPurchaseOrder(row interface{ Scan(...interface{}) error }) (purchaseOrder, error) {
+	var o purchaseOrder
+	var expected sql.NullTime
+	var created time.Time
+	err := row.Scan(&o.ID, &o.SupplierID, &o.SupplierName, &o.Status, &expected, &created)
+	if expected.Valid {
+		s := expected.Time.Format("2006-01-02")
+		o.ExpectedDate = &s
+	}
+	o.CreatedAt = created.Format(time.RFC3339)
+	return o, err
+}
+
+const purchaseOrderColumns = `po.id, po.supplierID, s.supplierName, po.status, po.expectedDate, po.created_at`
+
+// GET /api/purchase-orders
+func GetPurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rows, err := db.DB.Query(`
+		SELECT ` + purchaseOrderColumns + `
+		FROM purchase_orders po
+		JOIN suppliers s ON s.id = po.supplierID
+		ORDER BY po.id DESC
+	`)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	orders := []purchaseOrder{}
+	for rows.Next() {
+		o, err := scanPurchaseOrder(rows)
+		if err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		orders = append(orders, o)
+	}
+	json.NewEncoder(w).Encode(orders)
+}
+
+// GET /api/purchase-orders/{id}
+func GetPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/purchase-orders/"))
+	if err != nil {
+		http.Error(w, "Invalid purchase order ID", http.StatusBadRequest)
+		return
+	}
+
+	o, err := scanPurchaseOrder(db.DB.QueryRow(`
+		SELECT `+purchaseOrderColumns+`
+		FROM purchase_orders po
+		JOIN suppliers s ON s.id = po.supplierID
+		WHERE po.id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT op.id, op.productID, p.productName, op.quantity, op.receivedQuantity, op.damagedQuantity, op.received_at
+		FROM orderedProducts op
+		JOIN products p ON p.id = op.productID
+		WHERE op.purchaseOrderID = ?
+		ORDER BY op.id
+	`, id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l purchaseOrderLine
+		var received, damaged sql.NullFloat64
+		var at sql.NullTime
+		if err := rows.Scan(&l.ID, &l.ProductID, &l.ProductName, &l.Quantity, &received, &damaged, &at); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		if received.Valid {
+			l.ReceivedQuantity = &received.Float64
+		}
+		if damaged.Valid {
+			l.DamagedQuantity = &damaged.Float64
+		}
+		if at.Valid {
+			s := at.Time.Format(time.RFC3339)
+			l.ReceivedAt = &s
+		}
+		o.Lines = append(o.Lines, l)
+	}
+	json.NewEncoder(w).Encode(o)
+}
+
+// receipt is what the worker found when unloading one delivery line.
+type receipt struct {
+	Received       *float64 `json:"receivedQuantity"` // defaults to the ordered quantity
+	Damaged        float64  `json:"damagedQuantity"`
+	ExpirationDate *string  `json:"expirationDate"` // overrides the expected date, "YYYY-MM-DD"
+	LotNumber      string   `json:"lotNumber"`      // the supplier's lot printed on the delivery
+}
+
+// validate checks the receipt against the ordered quantity and fills in the
+// default received quantity. It returns a message when the receipt is invalid.
+func (rc *receipt) validate(ordered float64) string {
+	if rc.Received == nil {
+		rc.Received = &ordered
+	}
+	if *rc.Received < 0 || rc.Damaged < 0 {
+		return "quantities can't be negative"
+	}
+	if rc.Damaged > *rc.Received+quantityEpsilon {
+		return "damaged quantity exceeds received quantity"
+	}
+	if rc.ExpirationDate != nil {
+		if _, err := time.Parse("2006-01-02", *rc.ExpirationDate); err != nil {
+			return "expirationDate must be YYYY-MM-DD"
+		}
+	}
+	if rc.LotNumber = strings.TrimSpace(rc.LotNumber); len(rc.LotNumber) > 64 {
+		return "lotNumber can be at most 64 characters"
+	}
+	return ""
+}
+
+// recordReceipt stores what arrived on a delivery line, raises a batchProblem
+// rapport when it differs from the order and moves the purchase order on.
+func recordReceipt(tx *sql.Tx, lineID int, ordered float64, rc receipt, actorID int) error {
+	if _, err := tx.Exec(`
+		UPDATE orderedProducts
+		   SET receivedQuantity = ?, damagedQuantity = ?, received_at = NOW()
+		 WHERE id = ?
+	`, *rc.Received, rc.Damaged, lineID); err != nil {
+		return err
+	}
+
+	var productName string
+	var poID sql.NullInt64
+	if err := tx.QueryRow(`
+		SELECT p.productName, op.purchaseOrderID
+		FROM orderedProducts op
+		JOIN products p ON p.id = op.productID
+		WHERE op.id = ?
+	`, lineID).Scan(&productName, &poID); err != nil {
+		return err
+	}
+
+	var issues []string
+	switch diff := *rc.Received - ordered; {
+	case diff > quantityEpsilon:
+		issues = append(issues, fmt.Sprintf("over-delivered by %.2f", diff))
+	case diff < -quantityEpsilon:
+		issues = append(issues, fmt.Sprintf("under-delivered by %.2f", -diff))
+	}
+	if rc.Damaged > quantityEpsilon {
+		issues = append(issues, fmt.Sprintf("%.2f damaged", rc.Damaged))
+	}
+	if len(issues) > 0 {
+		ref := fmt.Sprintf("Delivery line %d", lineID)
+		if poID.Valid {
+			ref = fmt.Sprintf("Purchase order %d, line %d", poID.Int64, lineID)
+		}
+		content := fmt.Sprintf("%s (%s): ordered %.2f, received %.2f; %s.",
+			ref, productName, ordered, *rc.Received, strings.Join(issues, ", "))
+		if _, err := tx.Exec(
+			`INSERT INTO rapports (workerID, type, content) VALUES (?, 'batchProblem', ?)`,
+			actorID, content,
+		); err != nil {
+			return err
+		}
+		log.Printf("Receipt discrepancy: %s", content)
+	}
+
+	if !poID.Valid {
+		return nil
+	}
+	_, err := tx.Exec(`
+		UPDATE purchase_orders
+		   SET status = IF(EXISTS (SELECT 1 FROM orderedProducts WHERE purchaseOrderID = ? AND received_at IS NULL),
+		                   'partially_received', 'received')
+		 WHERE id = ?
+	`, poID.Int64, poID.Int64)
+	return err
+}
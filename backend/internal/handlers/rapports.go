@@ -38,8 +38,7 @@ func CreateRapport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// hardcoded for now
-	workerID := 1
+	reporterID := actorID(r)
 
 	var contentVal string
 	if req.Type == "delivery" {
@@ -50,7 +49,7 @@ func CreateRapport(w http.ResponseWriter, r *http.Request) {
 
 	if _, err := db.DB.Exec(
 		`INSERT INTO rapports (workerID, type, content) VALUES (?, ?, ?)`,
-		workerID, req.Type, contentVal,
+		reporterID, req.Type, contentVal,
 	); err != nil {
 		http.Error(w, "Insert failed", http.StatusInternalServerError)
 		return
@@ -70,6 +69,10 @@ func GetWorkerRapports(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid or missing workerId", http.StatusBadRequest)
 		return
 	}
+	if !canAccessUser(r, workerID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	rows, err := db.DB.Query(`
       SELECT id, type, content, status, response, created_at
@@ -0,0 +1,874 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/inventory"
+	"backend/internal/mail"
+)
+
+func intArgs(ids []int) []interface{} {
+	out := make([]interface{}, len(ids))
+	for i, v := range ids {
+		out[i] = v
+	}
+	return out
+}
+
+// batchFamily widens seed batches to every batch split off them or that
+// they were split from, since those are the same physical lot.
+func batchFamily(q queryer, seeds []int) ([]int, error) {
+	seen := make(map[int]bool)
+	var all, frontier []int
+	for _, id := range seeds {
+		if !seen[id] {
+			seen[id] = true
+			all = append(all, id)
+			frontier = append(frontier, id)
+		}
+	}
+	for len(frontier) > 0 {
+		in := placeholders(len(frontier))
+		args := intArgs(frontier)
+		rows, err := q.Query(`
+			SELECT parentBatchID FROM stock WHERE batchID IN (`+in+`) AND parentBatchID IS NOT NULL
+			UNION
+			SELECT batchID FROM stock WHERE parentBatchID IN (`+in+`)
+		`, append(args, args...)...)
+		if err != nil {
+			return nil, err
+		}
+		frontier = nil
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !seen[id] {
+				seen[id] = true
+				all = append(all, id)
+				frontier = append(frontier, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return all, nil
+}
+
+// lotBatches returns the batches carrying a supplier lot number, optionally
+// limited to one product.
+func lotBatches(q queryer, lot string, productID int) ([]int, error) {
+	query := `SELECT batchID FROM stock WHERE lotNumber = ?`
+	args := []interface{}{lot}
+	if productID != 0 {
+		query += ` AND productID = ?`
+		args = append(args, productID)
+	}
+	rows, err := q.Query(query+` ORDER BY batchID`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// tracedBatch is a batch of a traced lot with what is still in the
+// warehouse: free stock plus reservations that haven't been picked.
+type tracedBatch struct {
+	BatchID        int     `json:"batchId"`
+	ProductID      int     `json:"productId"`
+	ProductName    string  `json:"productName"`
+	LotNumber      *string `json:"lotNumber"`
+	ParentBatchID  *int    `json:"parentBatchId"`
+	ExpirationDate *string `json:"expirationDate"`
+	BinCode        *string `json:"binCode"`
+	Quantity       float64 `json:"quantity"`
+	Reserved       float64 `json:"reserved"`
+	Blocked        bool    `json:"blocked"`
+}
+
+// traceSource is the delivery a batch was received from.
+type traceSource struct {
+	BatchID          int     `json:"batchId"`
+	OrderedProductID int     `json:"orderedProductId"`
+	PurchaseOrderID  *int64  `json:"purchaseOrderId"`
+	SupplierID       *int64  `json:"supplierId"`
+	SupplierName     *string `json:"supplierName"`
+	Quantity         float64 `json:"quantity"`
+	ReceivedAt       string  `json:"receivedAt"`
+}
+
+// traceCustomer is a purchase request that got stock from a traced batch.
+type traceCustomer struct {
+	RequestID int     `json:"requestId"`
+	Status    string  `json:"status"`
+	UserID    int     `json:"userId"`
+	Email     string  `json:"email"`
+	BatchID   int     `json:"batchId"`
+	Quantity  float64 `json:"quantity"`
+}
+
+// traceMovement is one ledger entry of a traced batch.
+type traceMovement struct {
+	ID        int     `json:"id"`
+	BatchID   int     `json:"batchId"`
+	Delta     float64 `json:"delta"`
+	Quantity  float64 `json:"quantity"`
+	Reason    string  `json:"reason"`
+	DocType   string  `json:"docType"`
+	DocID     *int64  `json:"docId"`
+	CreatedAt string  `json:"createdAt"`
+}
+
+type trace struct {
+	Batches   []tracedBatch   `json:"batches"`
+	Sources   []traceSource   `json:"sources"`
+	Customers []traceCustomer `json:"customers"`
+	Movements []traceMovement `json:"movements"`
+}
+
+func loadTracedBatches(q queryer, ids []int) ([]tracedBatch, error) {
+	rows, err := q.Query(`
+		SELECT s.batchID, s.productID, p.productName, s.lotNumber, s.parentBatchID, s.expirationDate,
+		       l.code, s.quantity, s.blocked,
+		       COALESCE((SELECT SUM(ab.quantity)
+		                   FROM assigned_batches ab
+		                   JOIN purchase_items pi ON pi.id = ab.itemID
+		                   JOIN purchase_requests pr ON pr.id = pi.requestID
+		                  WHERE ab.batchID = s.batchID
+		                    AND pr.status IN ('pending', 'accepted', 'picking')), 0)
+		FROM stock s
+		JOIN products p ON p.id = s.productID
+		LEFT JOIN locations l ON l.id = s.locationID
+		WHERE s.batchID IN (`+placeholders(len(ids))+`)
+		ORDER BY s.batchID
+	`, intArgs(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []tracedBatch{}
+	for rows.Next() {
+		var b tracedBatch
+		var lot, binCode sql.NullString
+		var parent sql.NullInt64
+		var exp sql.NullTime
+		if err := rows.Scan(&b.BatchID, &b.ProductID, &b.ProductName, &lot, &parent, &exp,
+			&binCode, &b.Quantity, &b.Blocked, &b.Reserved); err != nil {
+			return nil, err
+		}
+		if lot.Valid {
+			b.LotNumber = &lot.String
+		}
+		if parent.Valid {
+			id := int(parent.Int64)
+			b.ParentBatchID = &id
+		}
+		if exp.Valid {
+			d := exp.Time.Format("2006-01-02")
+			b.ExpirationDate = &d
+		}
+		if binCode.Valid {
+			b.BinCode = &binCode.String
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// loadTrace follows the batches back to the deliveries they came from and
+// forward to the purchase requests they were reserved or shipped for.
+func loadTrace(q queryer, ids []int) (*trace, error) {
+	t := &trace{Sources: []traceSource{}, Customers: []traceCustomer{}, Movements: []traceMovement{}}
+	var err error
+	if t.Batches, err = loadTracedBatches(q, ids); err != nil {
+		return nil, err
+	}
+	in, args := placeholders(len(ids)), intArgs(ids)
+
+	rows, err := q.Query(`
+		SELECT m.batchID, op.id, op.purchaseOrderID, po.supplierID, su.supplierName, m.quantity, m.created_at
+		FROM inventory_movements m
+		JOIN orderedProducts op ON op.id = m.docID
+		LEFT JOIN purchase_orders po ON po.id = op.purchaseOrderID
+		LEFT JOIN suppliers su ON su.id = po.supplierID
+		WHERE m.reason = 'receipt' AND m.docType = 'ordered_product'
+		  AND m.batchID IN (`+in+`)
+		ORDER BY m.batchID
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s traceSource
+		var po, supplier sql.NullInt64
+		var supplierName sql.NullString
+		var at time.Time
+		if err := rows.Scan(&s.BatchID, &s.OrderedProductID, &po, &supplier, &supplierName, &s.Quantity, &at); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if po.Valid {
+			s.PurchaseOrderID = &po.Int64
+		}
+		if supplier.Valid {
+			s.SupplierID, s.SupplierName = &supplier.Int64, &supplierName.String
+		}
+		s.ReceivedAt = at.Format(time.RFC3339)
+		t.Sources = append(t.Sources, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`
+		SELECT pr.id, pr.status, u.id, u.email, ab.batchID, SUM(ab.quantity)
+		FROM assigned_batches ab
+		JOIN purchase_items pi ON pi.id = ab.itemID
+		JOIN purchase_requests pr ON pr.id = pi.requestID
+		JOIN users u ON u.id = pr.userID
+		WHERE ab.batchID IN (`+in+`)
+		GROUP BY pr.id, pr.status, u.id, u.email, ab.batchID
+		ORDER BY pr.id, ab.batchID
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c traceCustomer
+		if err := rows.Scan(&c.RequestID, &c.Status, &c.UserID, &c.Email, &c.BatchID, &c.Quantity); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.Customers = append(t.Customers, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`
+		SELECT id, batchID, delta, quantity, reason, docType, docID, created_at
+		FROM inventory_movements
+		WHERE batchID IN (`+in+`)
+		ORDER BY id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m traceMovement
+		var docID sql.NullInt64
+		var at time.Time
+		if err := rows.Scan(&m.ID, &m.BatchID, &m.Delta, &m.Quantity, &m.Reason, &m.DocType, &docID, &at); err != nil {
+			return nil, err
+		}
+		if docID.Valid {
+			m.DocID = &docID.Int64
+		}
+		m.CreatedAt = at.Format(time.RFC3339)
+		t.Movements = append(t.Movements, m)
+	}
+	return t, rows.Err()
+}
+
+// GET /api/trace?lot={lotNumber}&productId={id}
+// GET /api/trace?batchId={id}
+//
+// Traces a supplier lot or a single batch, including the batches split off
+// it by transfers, back to its deliveries and forward to its customers.
+func TraceLot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+
+	var seeds []int
+	var err error
+	switch lot := strings.TrimSpace(query.Get("lot")); {
+	case query.Get("batchId") != "":
+		id, convErr := strconv.Atoi(query.Get("batchId"))
+		if convErr != nil {
+			http.Error(w, "Invalid batchId", http.StatusBadRequest)
+			return
+		}
+		seeds = []int{id}
+	case lot != "":
+		productID := 0
+		if v := query.Get("productId"); v != "" {
+			if productID, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "Invalid productId", http.StatusBadRequest)
+				return
+			}
+		}
+		if seeds, err = lotBatches(db.DB, lot, productID); err != nil {
+			log.Printf("Trace error: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "lot or batchId required", http.StatusBadRequest)
+		return
+	}
+
+	ids, err := batchFamily(db.DB, seeds)
+	if err != nil {
+		log.Printf("Trace error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(ids) == 0 {
+		http.Error(w, "No batches found", http.StatusNotFound)
+		return
+	}
+	t, err := loadTrace(db.DB, ids)
+	if err != nil {
+		log.Printf("Trace error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(t.Batches) == 0 {
+		http.Error(w, "No batches found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(t)
+}
+
+// recallBatch is a batch blocked by a recall: what was left in the warehouse
+// and how much of it had to be taken back from open requests.
+type recallBatch struct {
+	BatchID  int     `json:"batchId"`
+	Quantity float64 `json:"quantity"`
+	Released float64 `json:"released"`
+}
+
+type recall struct {
+	ID            int           `json:"id"`
+	ProductID     int           `json:"productId"`
+	ProductName   string        `json:"productName"`
+	LotNumber     *string       `json:"lotNumber"`
+	Reason        string        `json:"reason"`
+	Status        string        `json:"status"`
+	DisposeTaskID *int64        `json:"disposeTaskId"`
+	CreatedAt     string        `json:"createdAt"`
+	ClosedAt      *string       `json:"closedAt"`
+	Batches       []recallBatch `json:"batches"`
+}
+
+func loadRecalls(q queryer, where string, args ...interface{}) ([]recall, error) {
+	rows, err := q.Query(`
+		SELECT r.id, r.productID, p.productName, r.lotNumber, r.reason, r.status, r.disposeTaskID,
+		       r.created_at, r.closed_at
+		FROM recalls r
+		JOIN products p ON p.id = r.productID
+		WHERE `+where+`
+		ORDER BY r.id DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	recalls := []recall{}
+	index := make(map[int]int)
+	for rows.Next() {
+		var rc recall
+		var lot sql.NullString
+		var task sql.NullInt64
+		var created time.Time
+		var closed sql.NullTime
+		if err := rows.Scan(&rc.ID, &rc.ProductID, &rc.ProductName, &lot, &rc.Reason, &rc.Status, &task,
+			&created, &closed); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if lot.Valid {
+			rc.LotNumber = &lot.String
+		}
+		if task.Valid {
+			rc.DisposeTaskID = &task.Int64
+		}
+		rc.CreatedAt = created.Format(time.RFC3339)
+		if closed.Valid {
+			s := closed.Time.Format(time.RFC3339)
+			rc.ClosedAt = &s
+		}
+		rc.Batches = []recallBatch{}
+		index[rc.ID] = len(recalls)
+		recalls = append(recalls, rc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(recalls) == 0 {
+		return recalls, nil
+	}
+
+	ids := make([]int, len(recalls))
+	for i, rc := range recalls {
+		ids[i] = rc.ID
+	}
+	rows, err = q.Query(`
+		SELECT recallID, batchID, quantity, released
+		FROM recall_batches
+		WHERE recallID IN (`+placeholders(len(ids))+`)
+		ORDER BY batchID
+	`, intArgs(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var b recallBatch
+		if err := rows.Scan(&id, &b.BatchID, &b.Quantity, &b.Released); err != nil {
+			return nil, err
+		}
+		rc := &recalls[index[id]]
+		rc.Batches = append(rc.Batches, b)
+	}
+	return recalls, rows.Err()
+}
+
+// releaseRecalledReservations takes the blocked batches back from requests
+// that haven't been picked yet. Accepted requests get a backorder for what
+// they lost; pending ones are allocated again when they are accepted. Stock
+// a worker has already confirmed picking is off the shelf, so it stays with
+// its request and only the unpicked rest is released. It returns the
+// released quantity per batch.
+func releaseRecalledReservations(tx *sql.Tx, recallID int, batchIDs []int, actorID int) (map[int]float64, error) {
+	rows, err := tx.Query(`
+		SELECT ab.id, ab.itemID, ab.batchID, ab.quantity, pi.requestID, pi.productID, pr.status
+		FROM assigned_batches ab
+		JOIN purchase_items pi ON pi.id = ab.itemID
+		JOIN purchase_requests pr ON pr.id = pi.requestID
+		WHERE ab.batchID IN (`+placeholders(len(batchIDs))+`)
+		  AND pr.status IN ('pending', 'accepted', 'picking')
+		ORDER BY ab.id
+		FOR UPDATE
+	`, intArgs(batchIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	type hold struct {
+		id, itemID, batchID, requestID, productID int
+		qty                                       float64
+		status                                    string
+	}
+	var holds []hold
+	for rows.Next() {
+		var h hold
+		if err := rows.Scan(&h.id, &h.itemID, &h.batchID, &h.qty, &h.requestID, &h.productID, &h.status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	type pick struct{ requestID, batchID int }
+	picked := make(map[pick]float64)
+	rows, err = tx.Query(`
+		SELECT t.requestID, pc.batchID, SUM(pc.quantity)
+		FROM pick_confirmations pc
+		JOIN tasks t ON t.id = pc.taskID
+		WHERE pc.batchID IN (`+placeholders(len(batchIDs))+`)
+		GROUP BY t.requestID, pc.batchID
+	`, intArgs(batchIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var k pick
+		var qty float64
+		if err := rows.Scan(&k.requestID, &k.batchID, &qty); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		picked[k] = qty
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	released := make(map[int]float64)
+	doc := inventory.Doc{Type: "recall", ID: recallID}
+	for _, h := range holds {
+		k := pick{h.requestID, h.batchID}
+		keep := math.Min(h.qty, picked[k])
+		picked[k] -= keep
+		qty := h.qty - keep
+		if qty <= quantityEpsilon {
+			continue
+		}
+		if err := inventory.ReleaseReservation(tx, h.batchID, qty, doc, actorID); err != nil {
+			return nil, err
+		}
+		if keep > quantityEpsilon {
+			_, err = tx.Exec(`UPDATE assigned_batches SET quantity = ? WHERE id = ?`, keep, h.id)
+		} else {
+			_, err = tx.Exec(`DELETE FROM assigned_batches WHERE id = ?`, h.id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.status != "pending" {
+			if _, err := tx.Exec(
+				`INSERT INTO backorders (requestID, itemID, productID, quantity) VALUES (?, ?, ?, ?)`,
+				h.requestID, h.itemID, h.productID, qty,
+			); err != nil {
+				return nil, err
+			}
+		}
+		released[h.batchID] += qty
+	}
+	return released, nil
+}
+
+// notifyRecall emails every customer whose packed, shipped or delivered
+// request contained stock from the batches, once per customer. Requests
+// still being picked count too when they kept stock a worker had already
+// picked, see releaseRecalledReservations. It returns the number of
+// customers notified.
+func notifyRecall(tx *sql.Tx, rc *recall, batchIDs []int) (int, error) {
+	rows, err := tx.Query(`
+		SELECT u.email, pr.id
+		FROM assigned_batches ab
+		JOIN purchase_items pi ON pi.id = ab.itemID
+		JOIN purchase_requests pr ON pr.id = pi.requestID
+		JOIN users u ON u.id = pr.userID
+		WHERE ab.batchID IN (`+placeholders(len(batchIDs))+`)
+		  AND pr.status IN ('picking', 'packed', 'shipped', 'delivered')
+		GROUP BY u.email, pr.id
+		ORDER BY u.email, pr.id
+	`, intArgs(batchIDs)...)
+	if err != nil {
+		return 0, err
+	}
+	var emails []string
+	requests := make(map[string][]int)
+	for rows.Next() {
+		var email string
+		var requestID int
+		if err := rows.Scan(&email, &requestID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if _, ok := requests[email]; !ok {
+			emails = append(emails, email)
+		}
+		requests[email] = append(requests[email], requestID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	lot := ""
+	if rc.LotNumber != nil {
+		lot = *rc.LotNumber
+	}
+	for _, email := range emails {
+		data := map[string]interface{}{
+			"ProductName": rc.ProductName,
+			"LotNumber":   lot,
+			"Reason":      rc.Reason,
+			"Requests":    requests[email],
+		}
+		if err := mail.Enqueue(tx, "recall_notice", email, data); err != nil {
+			return 0, err
+		}
+	}
+	return len(emails), nil
+}
+
+// POST /api/recalls
+//
+// Body: {lotNumber, productId, batchIds, reason, notifyCustomers}. The
+// recall covers the lot (or the listed batches) and every batch split off
+// them. They are blocked from allocation, taken back from requests that
+// haven't been picked, put on a dispose task and, unless notifyCustomers is
+// false, customers who already received them are emailed.
+func CreateRecall(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload struct {
+		LotNumber       string `json:"lotNumber"`
+		ProductID       int    `json:"productId"`
+		BatchIDs        []int  `json:"batchIds"`
+		Reason          string `json:"reason"`
+		NotifyCustomers *bool  `json:"notifyCustomers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	payload.LotNumber = strings.TrimSpace(payload.LotNumber)
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	if payload.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	if payload.LotNumber == "" && len(payload.BatchIDs) == 0 {
+		http.Error(w, "lotNumber or batchIds required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	seeds := payload.BatchIDs
+	if len(seeds) == 0 {
+		if seeds, err = lotBatches(tx, payload.LotNumber, payload.ProductID); err != nil {
+			log.Printf("Recall error: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	ids, err := batchFamily(tx, seeds)
+	if err != nil {
+		log.Printf("Recall error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(ids) == 0 {
+		http.Error(w, "No batches found", http.StatusNotFound)
+		return
+	}
+
+	// Lock the batches; a recall covers one product and skips batches an
+	// earlier recall already blocked.
+	rows, err := tx.Query(`
+		SELECT batchID, productID, blocked FROM stock
+		WHERE batchID IN (`+placeholders(len(ids))+`)
+		ORDER BY batchID
+		FOR UPDATE
+	`, intArgs(ids)...)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	products := make(map[int]bool)
+	var productID int
+	var batchIDs []int
+	for rows.Next() {
+		var id, pid int
+		var blocked bool
+		if err := rows.Scan(&id, &pid, &blocked); err != nil {
+			rows.Close()
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		products[pid], productID = true, pid
+		if !blocked {
+			batchIDs = append(batchIDs, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case len(products) == 0:
+		http.Error(w, "No batches found", http.StatusNotFound)
+		return
+	case len(products) > 1:
+		http.Error(w, "Batches belong to several products, give productId", http.StatusUnprocessableEntity)
+		return
+	case len(batchIDs) == 0:
+		http.Error(w, "Batches are already recalled", http.StatusConflict)
+		return
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO recalls (productID, lotNumber, reason, createdBy) VALUES (?, ?, ?, ?)`,
+		productID, nullString(payload.LotNumber), payload.Reason, nullInt(actorID(r)),
+	)
+	if err != nil {
+		http.Error(w, "Failed to create recall", http.StatusInternalServerError)
+		return
+	}
+	id64, _ := res.LastInsertId()
+	recallID := int(id64)
+
+	if _, err := tx.Exec(
+		`UPDATE stock SET blocked = TRUE WHERE batchID IN (`+placeholders(len(batchIDs))+`)`,
+		intArgs(batchIDs)...,
+	); err != nil {
+		http.Error(w, "Failed to block batches", http.StatusInternalServerError)
+		return
+	}
+
+	released, err := releaseRecalledReservations(tx, recallID, batchIDs, actorID(r))
+	if err != nil {
+		log.Printf("Recall release error: %v", err)
+		http.Error(w, "Failed to release reservations", http.StatusInternalServerError)
+		return
+	}
+
+	var lines []disposeLine
+	for _, id := range batchIDs {
+		var qty float64
+		if err := tx.QueryRow(`SELECT quantity FROM stock WHERE batchID = ?`, id).Scan(&qty); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO recall_batches (recallID, batchID, quantity, released) VALUES (?, ?, ?, ?)`,
+			recallID, id, qty, released[id],
+		); err != nil {
+			http.Error(w, "Failed to record recalled batches", http.StatusInternalServerError)
+			return
+		}
+		if qty > quantityEpsilon {
+			lines = append(lines, disposeLine{BatchID: id, Quantity: qty, Reason: "recalled"})
+		}
+	}
+	if len(lines) > 0 {
+		taskID, problems, err := createDisposeTask(tx, lines, 0)
+		if err != nil || len(problems) > 0 {
+			log.Printf("Recall dispose task error: %v %v", err, problems)
+			http.Error(w, "Failed to create dispose task", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec(`UPDATE recalls SET disposeTaskID = ? WHERE id = ?`, taskID, recallID); err != nil {
+			http.Error(w, "Failed to update recall", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	recalls, err := loadRecalls(tx, "r.id = ?", recallID)
+	if err != nil || len(recalls) == 0 {
+		http.Error(w, "Failed to load recall", http.StatusInternalServerError)
+		return
+	}
+	rc := recalls[0]
+
+	notified := 0
+	if payload.NotifyCustomers == nil || *payload.NotifyCustomers {
+		if notified, err = notifyRecall(tx, &rc, batchIDs); err != nil {
+			log.Printf("Recall notice error: %v", err)
+			http.Error(w, "Failed to notify customers", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Recall %d blocked %d batches of product %d", recallID, len(batchIDs), productID)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		recall
+		CustomersNotified int `json:"customersNotified"`
+	}{rc, notified})
+}
+
+// GET /api/recalls?status=open|closed|all
+func GetRecalls(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	where, args := "TRUE", []interface{}{}
+	switch status := r.URL.Query().Get("status"); status {
+	case "", "all":
+	case "open", "closed":
+		where, args = "r.status = ?", append(args, status)
+	default:
+		http.Error(w, "status must be open, closed or all", http.StatusBadRequest)
+		return
+	}
+
+	recalls, err := loadRecalls(db.DB, where, args...)
+	if err != nil {
+		log.Printf("Recalls error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(recalls)
+}
+
+// GET /api/recalls/{id}
+func GetRecall(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/recalls/"))
+	if err != nil {
+		http.Error(w, "Invalid recall ID", http.StatusBadRequest)
+		return
+	}
+
+	recalls, err := loadRecalls(db.DB, "r.id = ?", id)
+	if err != nil {
+		log.Printf("Recall error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(recalls) == 0 {
+		http.Error(w, "Recall not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(recalls[0])
+}
+
+// POST /api/recalls/{id}/close
+//
+// Closing a recall only records that it was dealt with; its batches stay
+// blocked.
+func CloseRecall(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/recalls/")
+	idStr = strings.TrimSuffix(idStr, "/close")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid recall ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.DB.Exec(
+		`UPDATE recalls SET status = 'closed', closed_at = NOW() WHERE id = ? AND status = 'open'`, id,
+	)
+	if err != nil {
+		http.Error(w, "Update failed", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, fmt.Sprintf("Recall %d not found or already closed", id), http.StatusNotFound)
+		return
+	}
+	w.Write([]byte("OK"))
+}
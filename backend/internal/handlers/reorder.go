@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"backend/internal/db"
+)
+
+// demandWindowDays is how far back average daily demand is measured
+// (DEMAND_WINDOW_DAYS, default 90).
+func demandWindowDays() int {
+	if d, err := strconv.Atoi(os.Getenv("DEMAND_WINDOW_DAYS")); err == nil && d > 0 {
+		return d
+	}
+	return 90
+}
+
+// reorderLine is the engine's view of one product.
+type reorderLine struct {
+	ProductID      int      `json:"productId"`
+	ProductName    string   `json:"productName"`
+	OnHand         float64  `json:"onHand"`
+	Reserved       float64  `json:"reserved"`
+	Incoming       float64  `json:"incoming"`
+	Position       float64  `json:"position"`
+	AvgDailyDemand float64  `json:"avgDailyDemand"`
+	LeadTimeDays   int      `json:"leadTimeDays"`
+	SafetyStock    float64  `json:"safetyStock"`
+	ReorderPoint   float64  `json:"reorderPoint"`
+	Quantity       float64  `json:"quantity"`
+	SupplierSKU    string   `json:"supplierSku,omitempty"`
+	CostPrice      *float64 `json:"costPrice"`
+	PackSize       float64  `json:"packSize"`
+}
+
+// supplierSuggestion is a proposed purchase order to one supplier.
+type supplierSuggestion struct {
+	SupplierID    int           `json:"supplierId"`
+	SupplierName  string        `json:"supplierName"`
+	LeadTimeDays  int           `json:"leadTimeDays"`
+	MinOrderValue float64       `json:"minOrderValue"`
+	OrderValue    float64       `json:"orderValue"`
+	BelowMinimum  bool          `json:"belowMinimum"`
+	Lines         []reorderLine `json:"lines"`
+}
+
+// reorderSuggestions runs the replenishment engine. For each product the
+// stock position is usable on-hand stock plus open deliveries minus
+// reservations. When it is at or below the reorder point — the configured
+// one, or else demand over the lead time plus safety stock — the product is
+// ordered from its supplier: the configured reorder quantity, or enough to
+// cover another lead time above the reorder point, rounded up to whole packs.
+func reorderSuggestions(q queryer) ([]supplierSuggestion, error) {
+	window := demandWindowDays()
+	rows, err := q.Query(`
+		SELECT p.id, p.productName, p.reorderPoint, p.safetyStock, p.reorderQuantity,
+		       s.id, s.supplierName, s.leadTimeDays, s.minOrderValue,
+		       sp.supplierSku, sp.costPrice, COALESCE(sp.packSize, 1),
+		       COALESCE((SELECT SUM(st.quantity) FROM stock st
+		                  WHERE st.productID = p.id AND st.blocked = FALSE
+		                    AND (st.expirationDate IS NULL OR st.expirationDate >= CURDATE())), 0),
+		       COALESCE((SELECT SUM(ab.quantity) FROM assigned_batches ab
+		                   JOIN purchase_items pi ON pi.id = ab.itemID
+		                   JOIN purchase_requests pr ON pr.id = pi.requestID
+		                  WHERE pi.productID = p.id AND pr.status IN ('pending', 'accepted', 'picking')), 0),
+		       COALESCE((SELECT SUM(op.quantity) FROM orderedProducts op
+		                  WHERE op.productID = p.id AND op.received_at IS NULL), 0),
+		       COALESCE((SELECT SUM(pi.quantity) FROM purchase_items pi
+		                   JOIN purchase_requests pr ON pr.id = pi.requestID
+		                  WHERE pi.productID = p.id AND pi.backorderID IS NULL
+		                    AND pr.status NOT IN ('cancelled', 'denied')
+		                    AND pr.created_at >= NOW() - INTERVAL ? DAY), 0)
+		FROM products p
+		JOIN suppliers s ON s.id = p.supplierID
+		LEFT JOIN supplier_products sp ON sp.supplierID = s.id AND sp.productID = p.id
+		WHERE s.active = TRUE AND p.archived = FALSE
+		ORDER BY s.supplierName, p.productName
+	`, window)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bySupplier := make(map[int]*supplierSuggestion)
+	var out []*supplierSuggestion
+	for rows.Next() {
+		var l reorderLine
+		var sup supplierSuggestion
+		var reorderPoint, reorderQty, cost sql.NullFloat64
+		var sku sql.NullString
+		var free, demand float64
+		if err := rows.Scan(&l.ProductID, &l.ProductName, &reorderPoint, &l.SafetyStock, &reorderQty,
+			&sup.SupplierID, &sup.SupplierName, &sup.LeadTimeDays, &sup.MinOrderValue,
+			&sku, &cost, &l.PackSize, &free, &l.Reserved, &l.Incoming, &demand); err != nil {
+			return nil, err
+		}
+
+		// stock.quantity is already net of reservations.
+		l.OnHand = free + l.Reserved
+		l.Position = l.OnHand + l.Incoming - l.Reserved
+		l.AvgDailyDemand = demand / float64(window)
+		l.LeadTimeDays = sup.LeadTimeDays
+		leadDemand := l.AvgDailyDemand * float64(l.LeadTimeDays)
+
+		if reorderPoint.Valid {
+			l.ReorderPoint = reorderPoint.Float64
+		} else {
+			l.ReorderPoint = leadDemand + l.SafetyStock
+		}
+		if l.ReorderPoint <= 0 || l.Position > l.ReorderPoint {
+			continue
+		}
+
+		if reorderQty.Valid && reorderQty.Float64 > 0 {
+			l.Quantity = reorderQty.Float64
+		} else {
+			l.Quantity = l.ReorderPoint + leadDemand - l.Position
+		}
+		if l.PackSize > 0 {
+			l.Quantity = math.Ceil(l.Quantity/l.PackSize-quantityEpsilon) * l.PackSize
+		}
+		if l.Quantity <= 0 {
+			continue
+		}
+		if sku.Valid {
+			l.SupplierSKU = sku.String
+			l.CostPrice = &cost.Float64
+		}
+
+		s, ok := bySupplier[sup.SupplierID]
+		if !ok {
+			s = &sup
+			bySupplier[sup.SupplierID] = s
+			out = append(out, s)
+		}
+		s.Lines = append(s.Lines, l)
+		if l.CostPrice != nil {
+			s.OrderValue += *l.CostPrice * l.Quantity
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]supplierSuggestion, 0, len(out))
+	for _, s := range out {
+		s.OrderValue = math.Round(s.OrderValue*100) / 100
+		s.BelowMinimum = s.OrderValue < s.MinOrderValue
+		result = append(result, *s)
+	}
+	return result, nil
+}
+
+// GET /api/reorder/suggestions
+func GetReorderSuggestions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	suggestions, err := reorderSuggestions(db.DB)
+	if err != nil {
+		log.Printf("Reorder suggestions error: %v", err)
+		http.Error(w, "Failed to compute suggestions", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(suggestions)
+}
+
+// POST /api/reorder/approve
+//
+// Turns the current suggestions for the given suppliers (all when
+// supplierIds is empty) into purchase orders. Suggestions are recomputed
+// here, so what gets ordered reflects stock at the moment of approval.
+// Suggestions that fail the purchase order checks — below the supplier's
+// minimum, or with lines missing a cost price — are skipped and reported.
+func ApproveReorderSuggestions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var payload struct {
+		SupplierIDs []int `json:"supplierIds"`
+		CreateTasks bool  `json:"createTasks"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	wanted := make(map[int]bool)
+	for _, id := range payload.SupplierIDs {
+		wanted[id] = true
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the products and their suppliers before reading stock, so two
+	// approvals running at once can't both order the same shortfall: the
+	// second waits here and then sees the first one's purchase orders.
+	rows, err := tx.Query(`
+		SELECT p.id FROM products p
+		JOIN suppliers s ON s.id = p.supplierID
+		WHERE s.active = TRUE AND p.archived = FALSE
+		FOR UPDATE
+	`)
+	if err != nil {
+		http.Error(w, "Failed to lock products", http.StatusInternalServerError)
+		return
+	}
+	rows.Close()
+
+	suggestions, err := reorderSuggestions(tx)
+	if err != nil {
+		log.Printf("Reorder suggestions error: %v", err)
+		http.Error(w, "Failed to compute suggestions", http.StatusInternalServerError)
+		return
+	}
+
+	type created struct {
+		PurchaseOrderID int64   `json:"purchaseOrderId"`
+		SupplierID      int     `json:"supplierId"`
+		LineIDs         []int64 `json:"lineIds"`
+	}
+	type skipped struct {
+		SupplierID int         `json:"supplierId"`
+		Lines      []lineError `json:"lines"`
+	}
+	orders := []created{}
+	rejected := []skipped{}
+	for _, s := range suggestions {
+		if len(wanted) > 0 && !wanted[s.SupplierID] {
+			continue
+		}
+		lines := make([]poLine, len(s.Lines))
+		for i, l := range s.Lines {
+			lines[i] = poLine{ProductID: l.ProductID, Quantity: l.Quantity}
+		}
+		problems, err := checkPurchaseOrder(tx, s.SupplierID, lines)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if len(problems) > 0 {
+			rejected = append(rejected, skipped{s.SupplierID, problems})
+			continue
+		}
+		id, lineIDs, err := insertPurchaseOrder(tx, s.SupplierID, nil, lines, payload.CreateTasks, actorID(r))
+		if err != nil {
+			log.Printf("Reorder approval error: %v", err)
+			http.Error(w, "Failed to create purchase order", http.StatusInternalServerError)
+			return
+		}
+		orders = append(orders, created{id, s.SupplierID, lineIDs})
+	}
+	if len(orders) == 0 {
+		if len(rejected) > 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "No suggestion passed the purchase order checks",
+				"skipped": rejected,
+			})
+			return
+		}
+		http.Error(w, "Nothing to reorder", http.StatusNotFound)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(orders, func(i, j int) bool { return orders[i].SupplierID < orders[j].SupplierID })
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"orders":  orders,
+		"skipped": rejected,
+	})
+}
+
+// PUT /api/products/{id}/reorder-settings
+func UpdateReorderSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/products/")
+	idStr = strings.TrimSuffix(idStr, "/reorder-settings")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	// A null reorder point or quantity lets the engine derive it from demand.
+	var payload struct {
+		ReorderPoint    *float64 `json:"reorderPoint"`
+		SafetyStock     float64  `json:"safetyStock"`
+		ReorderQuantity *float64 `json:"reorderQuantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if payload.SafetyStock < 0 ||
+		(payload.ReorderPoint != nil && *payload.ReorderPoint < 0) ||
+		(payload.ReorderQuantity != nil && *payload.ReorderQuantity <= 0) {
+		http.Error(w, "reorderPoint and safetyStock can't be negative, reorderQuantity must be positive", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.DB.Exec(`
+		UPDATE products SET reorderPoint = ?, safetyStock = ?, reorderQuantity = ? WHERE id = ?
+	`, payload.ReorderPoint, payload.SafetyStock, payload.ReorderQuantity, id)
+	if err != nil {
+		http.Error(w, "Update failed", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		if err := db.DB.QueryRow(`SELECT COUNT(*) FROM products WHERE id = ?`, id).Scan(&exists); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if exists == 0 {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":              id,
+		"reorderPoint":    payload.ReorderPoint,
+		"safetyStock":     payload.SafetyStock,
+		"reorderQuantity": payload.ReorderQuantity,
+	})
+}
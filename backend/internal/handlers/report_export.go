@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/money"
+	"backend/internal/xlsx"
+)
+
+// columnKind says how a report column is formatted in each export.
+type columnKind int
+
+const (
+	textColumn columnKind = iota
+	countColumn
+	quantityColumn
+	moneyColumn
+)
+
+type reportColumn struct {
+	Key   string
+	Title string
+	Kind  columnKind
+	// Total adds the column up in the totals row.
+	Total bool
+}
+
+// report is a table that can be served as JSON, CSV or XLSX. Cells hold a
+// string, int, float64, money.Amount or nil for a blank.
+type report struct {
+	Name    string
+	Title   string
+	Filters map[string]interface{}
+	Columns []reportColumn
+	Rows    [][]interface{}
+}
+
+func (rep *report) add(cells ...interface{}) {
+	rep.Rows = append(rep.Rows, cells)
+}
+
+// totals returns the totals row, or nil when no column is totalled.
+func (rep *report) totals() []interface{} {
+	var row []interface{}
+	for i, c := range rep.Columns {
+		if !c.Total {
+			continue
+		}
+		if row == nil {
+			row = make([]interface{}, len(rep.Columns))
+		}
+		switch c.Kind {
+		case moneyColumn:
+			var sum money.Amount
+			for _, r := range rep.Rows {
+				if v, ok := r[i].(money.Amount); ok {
+					sum += v
+				}
+			}
+			row[i] = sum
+		case countColumn:
+			sum := 0
+			for _, r := range rep.Rows {
+				if v, ok := r[i].(int); ok {
+					sum += v
+				}
+			}
+			row[i] = sum
+		case quantityColumn:
+			var sum float64
+			for _, r := range rep.Rows {
+				if v, ok := r[i].(float64); ok {
+					sum += v
+				}
+			}
+			row[i] = roundQuantity(sum)
+		}
+	}
+	return row
+}
+
+// roundQuantity trims float noise from summed quantities.
+func roundQuantity(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// writeReport serves the report in the format asked for with ?format=json
+// (the default), csv or xlsx. CSV and XLSX end with a totals row; JSON
+// carries it as "totals".
+func writeReport(w http.ResponseWriter, r *http.Request, rep *report) {
+	format := r.URL.Query().Get("format")
+	filename := fmt.Sprintf("%s-%s.%s", rep.Name, time.Now().Format("2006-01-02"), format)
+
+	switch format {
+	case "", "json":
+		rows := make([]map[string]interface{}, len(rep.Rows))
+		for i, row := range rep.Rows {
+			rows[i] = rep.object(row)
+		}
+		out := map[string]interface{}{
+			"report":      rep.Name,
+			"generatedAt": time.Now().Format(time.RFC3339),
+			"filters":     rep.Filters,
+			"rows":        rows,
+		}
+		if t := rep.totals(); t != nil {
+			totals := make(map[string]interface{})
+			for i, c := range rep.Columns {
+				if c.Total {
+					totals[c.Key] = t[i]
+				}
+			}
+			out["totals"] = totals
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		cw := csv.NewWriter(w)
+		record := make([]string, len(rep.Columns))
+		for i, c := range rep.Columns {
+			record[i] = c.Title
+		}
+		cw.Write(record)
+		for _, row := range rep.Rows {
+			cw.Write(csvRecord(row))
+		}
+		if t := rep.totals(); t != nil {
+			t[0] = "Total"
+			cw.Write(csvRecord(t))
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Printf("Report %s export error: %v", rep.Name, err)
+		}
+
+	case "xlsx":
+		sheet := xlsx.New(rep.Title)
+		widths := make([]float64, len(rep.Columns))
+		heading := make([]xlsx.Cell, len(rep.Columns))
+		for i, c := range rep.Columns {
+			widths[i] = 14
+			if c.Kind == textColumn {
+				widths[i] = 28
+			}
+			heading[i] = xlsx.Text(c.Title).Styled(xlsx.Bold)
+		}
+		sheet.SetWidths(widths...)
+		sheet.AddRow(heading...)
+		for _, row := range rep.Rows {
+			sheet.AddRow(xlsxRow(row, false)...)
+		}
+		if t := rep.totals(); t != nil {
+			t[0] = "Total"
+			sheet.AddRow(xlsxRow(t, true)...)
+		}
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		if err := sheet.Write(w); err != nil {
+			log.Printf("Report %s export error: %v", rep.Name, err)
+		}
+
+	default:
+		http.Error(w, "format must be json, csv or xlsx", http.StatusBadRequest)
+	}
+}
+
+func (rep *report) object(row []interface{}) map[string]interface{} {
+	obj := make(map[string]interface{}, len(rep.Columns))
+	for i, c := range rep.Columns {
+		obj[c.Key] = row[i]
+	}
+	return obj
+}
+
+func csvRecord(row []interface{}) []string {
+	record := make([]string, len(row))
+	for i, v := range row {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			record[i] = csvText(v)
+		case int:
+			record[i] = strconv.Itoa(v)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case money.Amount:
+			record[i] = v.String()
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return record
+}
+
+// csvText keeps a spreadsheet from reading a text cell as a formula, by
+// putting a quote in front of text that starts like one.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func xlsxRow(row []interface{}, bold bool) []xlsx.Cell {
+	text, number := xlsx.Plain, xlsx.Decimal
+	if bold {
+		text, number = xlsx.Bold, xlsx.BoldDecimal
+	}
+	cells := make([]xlsx.Cell, len(row))
+	for i, v := range row {
+		switch v := v.(type) {
+		case nil:
+			cells[i] = xlsx.Empty()
+		case string:
+			cells[i] = xlsx.Text(v).Styled(text)
+		case int:
+			cells[i] = xlsx.Number(float64(v)).Styled(text)
+		case float64:
+			cells[i] = xlsx.Number(v).Styled(text)
+		case money.Amount:
+			cells[i] = xlsx.Number(float64(v) / 100).Styled(number)
+		default:
+			cells[i] = xlsx.Text(fmt.Sprint(v)).Styled(text)
+		}
+	}
+	return cells
+}
+
+// reportDate reads an optional YYYY-MM-DD query parameter.
+func reportDate(r *http.Request, key string) (time.Time, bool, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	return t, err == nil, err
+}
@@ -0,0 +1,599 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/money"
+)
+
+// batchOnHand is what physically sits in batch s: its free quantity plus
+// reservations that haven't been picked yet.
+const batchOnHand = `s.quantity + COALESCE((SELECT SUM(ab.quantity)
+	FROM assigned_batches ab
+	JOIN purchase_items pi ON pi.id = ab.itemID
+	JOIN purchase_requests pr ON pr.id = pi.requestID
+	WHERE ab.batchID = s.batchID AND pr.status IN ('pending', 'accepted', 'picking')), 0)`
+
+// reportProduct is a product with what the reports group and value it by.
+type reportProduct struct {
+	ID         int
+	SKU        string
+	Name       string
+	Unit       string
+	SupplierID int
+	Supplier   string
+	CategoryID int
+	Category   string
+	Cost       money.NullAmount
+}
+
+func loadReportProducts(q queryer) (map[int]*reportProduct, error) {
+	tree, err := loadCategories(q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(`
+		SELECT p.id, COALESCE(p.sku, ''), p.productName, p.unitType, p.supplierID, s.supplierName,
+		       p.categoryID, sp.costPrice
+		FROM products p
+		JOIN suppliers s ON s.id = p.supplierID
+		LEFT JOIN supplier_products sp ON sp.supplierID = p.supplierID AND sp.productID = p.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := make(map[int]*reportProduct)
+	for rows.Next() {
+		p := &reportProduct{}
+		var categoryID sql.NullInt64
+		if err := rows.Scan(&p.ID, &p.SKU, &p.Name, &p.Unit, &p.SupplierID, &p.Supplier,
+			&categoryID, &p.Cost); err != nil {
+			return nil, err
+		}
+		if categoryID.Valid {
+			p.CategoryID = int(categoryID.Int64)
+			p.Category = tree.path(p.CategoryID)
+		}
+		products[p.ID] = p
+	}
+	return products, rows.Err()
+}
+
+// reportBatch is a batch with stock on hand.
+type reportBatch struct {
+	BatchID      int
+	ProductID    int
+	OnHand       float64
+	Blocked      bool
+	SalePrice    money.Amount
+	Expiration   sql.NullTime
+	DaysToExpiry int
+	ReceivedAt   time.Time
+}
+
+// loadReportBatches returns every batch with stock on hand. A batch split
+// off another keeps the receipt date of the batch it came from.
+func loadReportBatches(q queryer) ([]reportBatch, error) {
+	rows, err := q.Query(`
+		SELECT s.batchID, s.productID, s.parentBatchID, ` + batchOnHand + `, s.blocked,
+		       COALESCE(s.markdownPrice, p.price), s.expirationDate,
+		       COALESCE(DATEDIFF(s.expirationDate, CURDATE()), 0),
+		       (SELECT MIN(m.created_at) FROM inventory_movements m WHERE m.batchID = s.batchID)
+		FROM stock s
+		JOIN products p ON p.id = s.productID
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []reportBatch
+	parents := make(map[int]int)
+	firstMovement := make(map[int]time.Time)
+	for rows.Next() {
+		var b reportBatch
+		var parentID sql.NullInt64
+		var first sql.NullTime
+		if err := rows.Scan(&b.BatchID, &b.ProductID, &parentID, &b.OnHand, &b.Blocked,
+			&b.SalePrice, &b.Expiration, &b.DaysToExpiry, &first); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			parents[b.BatchID] = int(parentID.Int64)
+		}
+		firstMovement[b.BatchID] = first.Time
+		all = append(all, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var batches []reportBatch
+	for _, b := range all {
+		if b.OnHand <= quantityEpsilon {
+			continue
+		}
+		root := b.BatchID
+		for seen := 0; seen < len(parents); seen++ {
+			parent, ok := parents[root]
+			if !ok {
+				break
+			}
+			root = parent
+		}
+		b.ReceivedAt = firstMovement[root]
+		batches = append(batches, b)
+	}
+	return batches, nil
+}
+
+// productTotals is a product's stock summed over its batches.
+type productTotals struct {
+	*reportProduct
+	OnHand    float64
+	Blocked   float64
+	SaleValue money.Amount
+}
+
+// costValue is the on-hand stock at supplier cost; ok is false when the
+// product has no cost price from its supplier.
+func (t *productTotals) costValue() (money.Amount, bool) {
+	if !t.Cost.Valid {
+		return 0, false
+	}
+	return t.Cost.Amount.TimesQty(t.OnHand), true
+}
+
+// sumByProduct adds batches up per product, sorted by product name.
+func sumByProduct(products map[int]*reportProduct, batches []reportBatch) []*productTotals {
+	byID := make(map[int]*productTotals)
+	var out []*productTotals
+	for _, b := range batches {
+		t, ok := byID[b.ProductID]
+		if !ok {
+			t = &productTotals{reportProduct: products[b.ProductID]}
+			if t.reportProduct == nil {
+				continue
+			}
+			byID[b.ProductID] = t
+			out = append(out, t)
+		}
+		t.OnHand += b.OnHand
+		if b.Blocked {
+			t.Blocked += b.OnHand
+		} else {
+			t.SaleValue += b.SalePrice.TimesQty(b.OnHand)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// optionalMoney turns a value that may be unknown into a report cell.
+func optionalMoney(v money.Amount, ok bool) interface{} {
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// GET /api/reports/stock-value?groupBy=product|supplier|category&receivedFrom=&receivedTo=&format=
+//
+// On-hand stock, unpicked reservations included, valued at supplier cost and
+// at sale price. Sale value uses markdown prices and leaves out batches
+// blocked by a recall. receivedFrom and receivedTo keep the batches received
+// in that date range.
+func GetStockValueReport(w http.ResponseWriter, r *http.Request) {
+	groupBy := r.URL.Query().Get("groupBy")
+	if groupBy == "" {
+		groupBy = "product"
+	}
+	if groupBy != "product" && groupBy != "supplier" && groupBy != "category" {
+		http.Error(w, "groupBy must be product, supplier or category", http.StatusBadRequest)
+		return
+	}
+	from, hasFrom, err := reportDate(r, "receivedFrom")
+	if err != nil {
+		http.Error(w, "Invalid receivedFrom, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	to, hasTo, err := reportDate(r, "receivedTo")
+	if err != nil {
+		http.Error(w, "Invalid receivedTo, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	products, err := loadReportProducts(db.DB)
+	if err != nil {
+		log.Printf("Stock value report error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	batches, err := loadReportBatches(db.DB)
+	if err != nil {
+		log.Printf("Stock value report error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	filters := map[string]interface{}{"groupBy": groupBy}
+	if hasFrom || hasTo {
+		kept := batches[:0]
+		for _, b := range batches {
+			if hasFrom && b.ReceivedAt.Before(from) || hasTo && !b.ReceivedAt.Before(to.AddDate(0, 0, 1)) {
+				continue
+			}
+			kept = append(kept, b)
+		}
+		batches = kept
+		if hasFrom {
+			filters["receivedFrom"] = from.Format("2006-01-02")
+		}
+		if hasTo {
+			filters["receivedTo"] = to.Format("2006-01-02")
+		}
+	}
+	totals := sumByProduct(products, batches)
+
+	rep := &report{Name: "stock-value-by-" + groupBy, Title: "Stock value by " + groupBy, Filters: filters}
+	if groupBy == "product" {
+		rep.Columns = []reportColumn{
+			{Key: "productId", Title: "Product ID", Kind: countColumn},
+			{Key: "sku", Title: "SKU"},
+			{Key: "product", Title: "Product"},
+			{Key: "supplier", Title: "Supplier"},
+			{Key: "category", Title: "Category"},
+			{Key: "unit", Title: "Unit"},
+			{Key: "onHand", Title: "On hand", Kind: quantityColumn},
+			{Key: "blocked", Title: "Blocked", Kind: quantityColumn},
+			{Key: "unitCost", Title: "Unit cost", Kind: moneyColumn},
+			{Key: "costValue", Title: "Cost value", Kind: moneyColumn, Total: true},
+			{Key: "saleValue", Title: "Sale value", Kind: moneyColumn, Total: true},
+		}
+		for _, t := range totals {
+			var unitCost interface{}
+			if t.Cost.Valid {
+				unitCost = t.Cost.Amount
+			}
+			rep.add(t.ID, t.SKU, t.Name, t.Supplier, t.Category, t.Unit,
+				roundQuantity(t.OnHand), roundQuantity(t.Blocked), unitCost,
+				optionalMoney(t.costValue()), t.SaleValue)
+		}
+		writeReport(w, r, rep)
+		return
+	}
+
+	// Quantities in different units don't add up, so groups report values
+	// and product counts only.
+	type group struct {
+		id        interface{}
+		name      string
+		products  int
+		uncosted  int
+		costValue money.Amount
+		saleValue money.Amount
+	}
+	groups := make(map[string]*group)
+	var order []string
+	for _, t := range totals {
+		g := &group{id: t.SupplierID, name: t.Supplier}
+		if groupBy == "category" {
+			g = &group{id: nil, name: "Uncategorized"}
+			if t.CategoryID != 0 {
+				g = &group{id: t.CategoryID, name: t.Category}
+			}
+		}
+		key := g.name + "\x00" + strconv.Itoa(t.SupplierID)
+		if groupBy == "category" {
+			key = g.name
+		}
+		if existing, ok := groups[key]; ok {
+			g = existing
+		} else {
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.products++
+		g.saleValue += t.SaleValue
+		if cost, ok := t.costValue(); ok {
+			g.costValue += cost
+		} else {
+			g.uncosted++
+		}
+	}
+	sort.Strings(order)
+
+	idTitle, nameTitle := "Supplier ID", "Supplier"
+	if groupBy == "category" {
+		idTitle, nameTitle = "Category ID", "Category"
+	}
+	rep.Columns = []reportColumn{
+		{Key: groupBy + "Id", Title: idTitle, Kind: countColumn},
+		{Key: groupBy, Title: nameTitle},
+		{Key: "products", Title: "Products", Kind: countColumn, Total: true},
+		{Key: "productsWithoutCost", Title: "Products without cost", Kind: countColumn, Total: true},
+		{Key: "costValue", Title: "Cost value", Kind: moneyColumn, Total: true},
+		{Key: "saleValue", Title: "Sale value", Kind: moneyColumn, Total: true},
+	}
+	for _, key := range order {
+		g := groups[key]
+		rep.add(g.id, g.name, g.products, g.uncosted, g.costValue, g.saleValue)
+	}
+	writeReport(w, r, rep)
+}
+
+// expiryBuckets are the days-to-expiry ranges of the aging report; a batch
+// falls in the first bucket whose upper bound it doesn't exceed.
+var expiryBuckets = []struct {
+	key, title string
+	maxDays    int
+}{
+	{"expired", "Expired", -1},
+	{"days0to7", "0-7 days", 7},
+	{"days8to30", "8-30 days", 30},
+	{"days31to90", "31-90 days", 90},
+	{"over90Days", "Over 90 days", math.MaxInt},
+}
+
+// GET /api/reports/expiry-aging?from=&to=&format=
+//
+// On-hand quantity per product bucketed by days until its batches expire.
+// from and to keep batches expiring in that date range, which leaves out
+// batches without an expiration date. The expired cost value is what the
+// Expired bucket cost to buy.
+func GetExpiryAgingReport(w http.ResponseWriter, r *http.Request) {
+	from, hasFrom, err := reportDate(r, "from")
+	if err != nil {
+		http.Error(w, "Invalid from, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	to, hasTo, err := reportDate(r, "to")
+	if err != nil {
+		http.Error(w, "Invalid to, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	products, err := loadReportProducts(db.DB)
+	if err != nil {
+		log.Printf("Expiry aging report error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	batches, err := loadReportBatches(db.DB)
+	if err != nil {
+		log.Printf("Expiry aging report error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	filters := map[string]interface{}{}
+	if hasFrom {
+		filters["from"] = from.Format("2006-01-02")
+	}
+	if hasTo {
+		filters["to"] = to.Format("2006-01-02")
+	}
+	type aging struct {
+		buckets []float64
+		undated float64
+	}
+	byProduct := make(map[int]*aging)
+	var kept []reportBatch
+	for _, b := range batches {
+		if hasFrom || hasTo {
+			if !b.Expiration.Valid || hasFrom && b.Expiration.Time.Before(from) || hasTo && b.Expiration.Time.After(to) {
+				continue
+			}
+		}
+		a, ok := byProduct[b.ProductID]
+		if !ok {
+			a = &aging{buckets: make([]float64, len(expiryBuckets))}
+			byProduct[b.ProductID] = a
+		}
+		if !b.Expiration.Valid {
+			a.undated += b.OnHand
+		} else {
+			for i, bucket := range expiryBuckets {
+				if b.DaysToExpiry <= bucket.maxDays {
+					a.buckets[i] += b.OnHand
+					break
+				}
+			}
+		}
+		kept = append(kept, b)
+	}
+
+	rep := &report{Name: "expiry-aging", Title: "Expiry aging", Filters: filters}
+	rep.Columns = []reportColumn{
+		{Key: "productId", Title: "Product ID", Kind: countColumn},
+		{Key: "sku", Title: "SKU"},
+		{Key: "product", Title: "Product"},
+		{Key: "unit", Title: "Unit"},
+	}
+	for _, bucket := range expiryBuckets {
+		rep.Columns = append(rep.Columns, reportColumn{Key: bucket.key, Title: bucket.title, Kind: quantityColumn})
+	}
+	rep.Columns = append(rep.Columns,
+		reportColumn{Key: "noExpiry", Title: "No expiry date", Kind: quantityColumn},
+		reportColumn{Key: "onHand", Title: "On hand", Kind: quantityColumn},
+		reportColumn{Key: "expiredCostValue", Title: "Expired cost value", Kind: moneyColumn, Total: true},
+		reportColumn{Key: "costValue", Title: "Cost value", Kind: moneyColumn, Total: true},
+	)
+	for _, t := range sumByProduct(products, kept) {
+		a := byProduct[t.ID]
+		row := []interface{}{t.ID, t.SKU, t.Name, t.Unit}
+		for _, qty := range a.buckets {
+			row = append(row, roundQuantity(qty))
+		}
+		cost, ok := t.costValue()
+		// expiryBuckets starts with the batches already past their date.
+		var expiredCost interface{}
+		if t.Cost.Valid {
+			expiredCost = t.Cost.Amount.TimesQty(a.buckets[0])
+		}
+		row = append(row, roundQuantity(a.undated), roundQuantity(t.OnHand), expiredCost, optionalMoney(cost, ok))
+		rep.add(row...)
+	}
+	writeReport(w, r, rep)
+}
+
+// GET /api/reports/slow-moving?from=&to=&coverDays=&format=
+//
+// Products with stock on hand that sold little between from and to (by
+// default the last 90 days). Sales are the quantities in purchase_items of
+// requests that weren't cancelled or denied; backorder requests repeat their
+// original's items and don't count again. A product is dead when nothing
+// was ordered and slow when its stock lasts more than coverDays (default 90)
+// at the window's rate of demand.
+func GetSlowMovingReport(w http.ResponseWriter, r *http.Request) {
+	today := time.Now()
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local)
+	to, hasTo, err := reportDate(r, "to")
+	if err != nil {
+		http.Error(w, "Invalid to, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if !hasTo {
+		to = today
+	}
+	from, hasFrom, err := reportDate(r, "from")
+	if err != nil {
+		http.Error(w, "Invalid from, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if !hasFrom {
+		from = to.AddDate(0, 0, -89)
+	}
+	if from.After(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+	coverDays := 90
+	if v := r.URL.Query().Get("coverDays"); v != "" {
+		if coverDays, err = strconv.Atoi(v); err != nil || coverDays <= 0 {
+			http.Error(w, "coverDays must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	end := to.AddDate(0, 0, 1)
+	windowDays := end.Sub(from).Hours() / 24
+
+	products, err := loadReportProducts(db.DB)
+	if err != nil {
+		log.Printf("Slow-moving report error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	batches, err := loadReportBatches(db.DB)
+	if err != nil {
+		log.Printf("Slow-moving report error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	type sales struct {
+		ordered     int
+		lastOrdered sql.NullTime
+	}
+	sold := make(map[int]sales)
+	rows, err := db.DB.Query(`
+		SELECT pi.productID,
+		       COALESCE(SUM(IF(pr.created_at >= ? AND pr.created_at < ?, pi.quantity, 0)), 0),
+		       MAX(IF(pr.created_at < ?, pr.created_at, NULL))
+		FROM purchase_items pi
+		JOIN purchase_requests pr ON pr.id = pi.requestID
+		WHERE pr.parentRequestID IS NULL AND pr.status NOT IN ('cancelled', 'denied')
+		GROUP BY pi.productID
+	`, from, end, end)
+	if err != nil {
+		log.Printf("Slow-moving report error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID int
+		var s sales
+		if err := rows.Scan(&productID, &s.ordered, &s.lastOrdered); err != nil {
+			log.Printf("Slow-moving report error: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		sold[productID] = s
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	type slow struct {
+		*productTotals
+		sales
+		cover  float64
+		status string
+	}
+	var found []slow
+	for _, t := range sumByProduct(products, batches) {
+		s := slow{productTotals: t, sales: sold[t.ID], status: "dead"}
+		if s.ordered > 0 {
+			s.cover = t.OnHand / (float64(s.ordered) / windowDays)
+			if s.cover <= float64(coverDays) {
+				continue
+			}
+			s.status = "slow"
+		}
+		found = append(found, s)
+	}
+	// Dead stock first, then the longest-lasting.
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].status != found[j].status {
+			return found[i].status == "dead"
+		}
+		return found[i].cover > found[j].cover
+	})
+
+	rep := &report{Name: "slow-moving", Title: "Slow-moving stock", Filters: map[string]interface{}{
+		"from":      from.Format("2006-01-02"),
+		"to":        to.Format("2006-01-02"),
+		"coverDays": coverDays,
+	}}
+	rep.Columns = []reportColumn{
+		{Key: "productId", Title: "Product ID", Kind: countColumn},
+		{Key: "sku", Title: "SKU"},
+		{Key: "product", Title: "Product"},
+		{Key: "supplier", Title: "Supplier"},
+		{Key: "category", Title: "Category"},
+		{Key: "unit", Title: "Unit"},
+		{Key: "status", Title: "Status"},
+		{Key: "onHand", Title: "On hand", Kind: quantityColumn},
+		{Key: "ordered", Title: "Ordered in period", Kind: countColumn},
+		{Key: "daysOfCover", Title: "Days of cover", Kind: quantityColumn},
+		{Key: "lastOrdered", Title: "Last ordered"},
+		{Key: "costValue", Title: "Cost value", Kind: moneyColumn, Total: true},
+	}
+	for _, s := range found {
+		var cover, last interface{}
+		if s.status == "slow" {
+			cover = math.Round(s.cover*10) / 10
+		}
+		if s.lastOrdered.Valid {
+			last = s.lastOrdered.Time.Format("2006-01-02")
+		}
+		rep.add(s.ID, s.SKU, s.Name, s.Supplier, s.Category, s.Unit, s.status,
+			roundQuantity(s.OnHand), s.ordered, cover, last, optionalMoney(s.costValue()))
+	}
+	writeReport(w, r, rep)
+}
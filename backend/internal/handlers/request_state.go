@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/middleware"
+	"backend/internal/models"
+)
+
+var errIllegalTransition = errors.New("illegal status transition")
+
+// requestTransitions is the purchase request state machine. delivered,
+// cancelled and denied are terminal.
+var requestTransitions = map[string][]string{
+	"pending":  {"accepted", "denied", "cancelled"},
+	"accepted": {"picking", "denied", "cancelled"},
+	"picking":  {"packed"},
+	"packed":   {"shipped"},
+	"shipped":  {"delivered"},
+}
+
+// fulfilmentPath is the order an accepted request moves through the warehouse.
+var fulfilmentPath = []string{"accepted", "picking", "packed", "shipped"}
+
+func canTransition(from, to string) bool {
+	for _, s := range requestTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// lockRequestStatus returns the request's status and holds its row lock until
+// the transaction ends.
+func lockRequestStatus(tx *sql.Tx, requestID int) (string, error) {
+	var status string
+	err := tx.QueryRow(`SELECT status FROM purchase_requests WHERE id = ? FOR UPDATE`, requestID).Scan(&status)
+	return status, err
+}
+
+// transitionRequest moves a request to a new status if the state machine
+// allows it and records the change. Cancelling or denying a request puts its
+// reserved stock back, cancels its open backorders and drops its pending tasks.
+// Shipping it emails the customer the invoice and packing slip.
+func transitionRequest(tx *sql.Tx, requestID int, to string, actorID int, note string) error {
+	from, err := lockRequestStatus(tx, requestID)
+	if err != nil {
+		return err
+	}
+	if !canTransition(from, to) {
+		return errIllegalTransition
+	}
+
+	if to == "cancelled" || to == "denied" {
+		if err := releaseReservations(tx, requestID, actorID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE backorders SET status = 'cancelled' WHERE requestID = ? AND status = 'open'`, requestID); err != nil {
+			return err
+		}
+		if err := reopenBackorders(tx, requestID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM tasks WHERE requestID = ? AND status = 'pending'`, requestID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE purchase_requests SET status = ? WHERE id = ?`, to, requestID); err != nil {
+		return err
+	}
+	if to == "shipped" {
+		if err := sendShippingDocuments(tx, requestID); err != nil {
+			return err
+		}
+	}
+	return recordRequestEvent(tx, requestID, from, to, actorID, note)
+}
+
+// advanceRequest walks a request along fulfilmentPath up to target,
+// recording every intermediate step.
+func advanceRequest(tx *sql.Tx, requestID int, target string, actorID int) error {
+	from, err := lockRequestStatus(tx, requestID)
+	if err != nil {
+		return err
+	}
+	start, end := -1, -1
+	for i, s := range fulfilmentPath {
+		if s == from {
+			start = i
+		}
+		if s == target {
+			end = i
+		}
+	}
+	if start < 0 || end <= start {
+		return errIllegalTransition
+	}
+	for _, next := range fulfilmentPath[start+1 : end+1] {
+		if err := transitionRequest(tx, requestID, next, actorID, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRequestEvent appends to the request's status history. An empty from
+// marks the creation of the request.
+func recordRequestEvent(tx *sql.Tx, requestID int, from, to string, actorID int, note string) error {
+	_, err := tx.Exec(
+		`INSERT INTO purchase_request_events (requestID, fromStatus, toStatus, actorID, note)
+		 VALUES (?, ?, ?, ?, ?)`,
+		requestID, nullString(from), to, nullInt(actorID), nullString(note),
+	)
+	return err
+}
+
+// writeTransitionError maps transitionRequest errors to HTTP responses.
+func writeTransitionError(w http.ResponseWriter, err error) {
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Request not found", http.StatusNotFound)
+	case errors.Is(err, errIllegalTransition):
+		http.Error(w, "Illegal status transition", http.StatusConflict)
+	default:
+		http.Error(w, "Failed to update request", http.StatusInternalServerError)
+	}
+}
+
+// actorID is the signed-in user making the request, or 0.
+func actorID(r *http.Request) int {
+	id, _ := middleware.CurrentUser(r)
+	return id.UserID
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullInt(i int) interface{} {
+	if i == 0 {
+		return nil
+	}
+	return i
+}
+
+// requestIDFromPath extracts {id} from /api/purchase-requests/{id}/<suffix>.
+func requestIDFromPath(path, suffix string) (int, error) {
+	idStr := strings.TrimPrefix(path, "/api/purchase-requests/")
+	idStr = strings.TrimSuffix(idStr, suffix)
+	return strconv.Atoi(idStr)
+}
+
+// requestOwner returns the customer who placed the request.
+func requestOwner(requestID int) (int, error) {
+	var userID int
+	err := db.DB.QueryRow(`SELECT userID FROM purchase_requests WHERE id = ?`, requestID).Scan(&userID)
+	return userID, err
+}
+
+// POST /api/purchase-requests/{id}/cancel
+func CancelPurchaseRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requestID, err := requestIDFromPath(r.URL.Path, "/cancel")
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+	owner, err := requestOwner(requestID)
+	if err != nil {
+		writeTransitionError(w, err)
+		return
+	}
+	if !canAccessUser(r, owner) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(r.Body).Decode(&payload)
+
+	applyTransition(w, r, requestID, "cancelled", payload.Reason)
+}
+
+// POST /api/purchase-requests/{id}/confirm-delivery
+func ConfirmDelivery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requestID, err := requestIDFromPath(r.URL.Path, "/confirm-delivery")
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+	owner, err := requestOwner(requestID)
+	if err != nil {
+		writeTransitionError(w, err)
+		return
+	}
+	if !canAccessUser(r, owner) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	applyTransition(w, r, requestID, "delivered", "")
+}
+
+// POST /api/purchase-requests/{id}/status
+func UpdatePurchaseRequestStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requestID, err := requestIDFromPath(r.URL.Path, "/status")
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Status == "accepted" {
+		// Accepting needs the batch coverage checks of /accept.
+		http.Error(w, "Use /accept to accept a request", http.StatusBadRequest)
+		return
+	}
+	if id, _ := middleware.CurrentUser(r); (payload.Status == "cancelled" || payload.Status == "denied") && id.Role != models.RoleAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	applyTransition(w, r, requestID, payload.Status, payload.Note)
+}
+
+func applyTransition(w http.ResponseWriter, r *http.Request, requestID int, to, note string) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := transitionRequest(tx, requestID, to, actorID(r), note); err != nil {
+		writeTransitionError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     requestID,
+		"status": to,
+	})
+}
+
+// GET /api/purchase-requests/{id}/history
+func GetPurchaseRequestHistory(w http.ResponseWriter, r *http.Request) {
+	requestID, err := requestIDFromPath(r.URL.Path, "/history")
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+	owner, err := requestOwner(requestID)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	if !canAccessUser(r, owner) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT e.fromStatus, e.toStatus, e.actorID, u.email, e.note, e.created_at
+		FROM purchase_request_events e
+		LEFT JOIN users u ON u.id = e.actorID
+		WHERE e.requestID = ?
+		ORDER BY e.id
+	`, requestID)
+	if err != nil {
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type Event struct {
+		From       *string `json:"from"`
+		To         string  `json:"to"`
+		ActorID    *int64  `json:"actorId"`
+		ActorEmail *string `json:"actorEmail"`
+		Note       *string `json:"note"`
+		CreatedAt  string  `json:"created_at"`
+	}
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var from, email, note sql.NullString
+		var actor sql.NullInt64
+		var created time.Time
+		if err := rows.Scan(&from, &e.To, &actor, &email, &note, &created); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		if from.Valid {
+			e.From = &from.String
+		}
+		if actor.Valid {
+			e.ActorID = &actor.Int64
+		}
+		if email.Valid {
+			e.ActorEmail = &email.String
+		}
+		if note.Valid {
+			e.Note = &note.String
+		}
+		e.CreatedAt = created.Format(time.RFC3339)
+		events = append(events, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
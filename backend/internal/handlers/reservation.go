@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"backend/internal/inventory"
+)
+
+// quantityEpsilon absorbs FLOAT rounding when comparing stock quantities.
+const quantityEpsilon = 1e-6
+
+// batchLine is one requested reservation of stock for a purchase item.
+type batchLine struct {
+	ItemID   int     `json:"itemID"`
+	BatchID  int     `json:"batchID"`
+	Quantity float64 `json:"quantity"`
+}
+
+// lineError explains why a single line of an assignment payload was rejected.
+type lineError struct {
+	Line    int    `json:"line"`
+	ItemID  int    `json:"itemID"`
+	BatchID int    `json:"batchID"`
+	Error   string `json:"error"`
+}
+
+// batchQty is the total reserved from one batch for a request.
+type batchQty struct {
+	batchID int
+	qty     float64
+}
+
+// reservedBatches sums the request's assigned_batches per batch.
+func reservedBatches(tx *sql.Tx, requestID int) ([]batchQty, error) {
+	rows, err := tx.Query(`
+		SELECT ab.batchID, SUM(ab.quantity)
+		FROM assigned_batches ab
+		JOIN purchase_items pi ON ab.itemID = pi.id
+		WHERE pi.requestID = ?
+		GROUP BY ab.batchID
+		ORDER BY ab.batchID
+	`, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []batchQty
+	for rows.Next() {
+		var b batchQty
+		if err := rows.Scan(&b.batchID, &b.qty); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// releaseReservations puts every quantity reserved for the request back into
+// stock and removes its assigned_batches rows.
+func releaseReservations(tx *sql.Tx, requestID, actorID int) error {
+	held, err := reservedBatches(tx, requestID)
+	if err != nil {
+		return err
+	}
+
+	doc := inventory.Doc{Type: "purchase_request", ID: requestID}
+	for _, h := range held {
+		if err := inventory.ReleaseReservation(tx, h.batchID, h.qty, doc, actorID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE ab FROM assigned_batches ab
+		JOIN purchase_items pi ON ab.itemID = pi.id
+		WHERE pi.requestID = ?`, requestID)
+	return err
+}
+
+// reserveBatches validates lines against the request's items and the locked
+// stock rows and, if every line is valid, reserves them. Nothing is written
+// when any line fails; the caller gets one lineError per bad line instead.
+func reserveBatches(tx *sql.Tx, requestID int, lines []batchLine, actorID int) ([]lineError, error) {
+	type item struct {
+		productID int
+		required  float64
+		assigned  float64
+	}
+	items := make(map[int]*item)
+
+	rows, err := tx.Query(`SELECT id, productID, quantity FROM purchase_items WHERE requestID = ?`, requestID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		it := &item{}
+		if err := rows.Scan(&id, &it.productID, &it.required); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		items[id] = it
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Lock the touched batches in a fixed order so concurrent assignments
+	// can't deadlock on each other.
+	var batchIDs []int
+	seen := make(map[int]bool)
+	for _, l := range lines {
+		if !seen[l.BatchID] {
+			seen[l.BatchID] = true
+			batchIDs = append(batchIDs, l.BatchID)
+		}
+	}
+	sort.Ints(batchIDs)
+
+	type batch struct {
+		productID int
+		available float64
+		blocked   bool
+	}
+	batches := make(map[int]*batch)
+	for _, id := range batchIDs {
+		b := &batch{}
+		err := tx.QueryRow(`SELECT productID, quantity, blocked FROM stock WHERE batchID = ? FOR UPDATE`, id).
+			Scan(&b.productID, &b.available, &b.blocked)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		batches[id] = b
+	}
+
+	var problems []lineError
+	for i, l := range lines {
+		fail := func(format string, args ...interface{}) {
+			problems = append(problems, lineError{Line: i, ItemID: l.ItemID, BatchID: l.BatchID, Error: fmt.Sprintf(format, args...)})
+		}
+
+		it, ok := items[l.ItemID]
+		if !ok {
+			fail("item %d is not part of request %d", l.ItemID, requestID)
+			continue
+		}
+		b, ok := batches[l.BatchID]
+		if !ok {
+			fail("batch %d does not exist", l.BatchID)
+			continue
+		}
+		if l.Quantity <= 0 {
+			fail("quantity must be positive")
+			continue
+		}
+		if b.blocked {
+			fail("batch %d is blocked by a recall", l.BatchID)
+			continue
+		}
+		if b.productID != it.productID {
+			fail("batch %d holds product %d, item needs product %d", l.BatchID, b.productID, it.productID)
+			continue
+		}
+		if l.Quantity > b.available+quantityEpsilon {
+			fail("batch %d has only %.2f available, %.2f requested", l.BatchID, b.available, l.Quantity)
+			continue
+		}
+		if it.assigned+l.Quantity > it.required+quantityEpsilon {
+			fail("item %d needs %.2f, assignment would reach %.2f", l.ItemID, it.required, it.assigned+l.Quantity)
+			continue
+		}
+		b.available -= l.Quantity
+		it.assigned += l.Quantity
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+
+	for _, l := range lines {
+		if _, err := tx.Exec(`INSERT INTO assigned_batches (itemID, batchID, quantity) VALUES (?, ?, ?)`,
+			l.ItemID, l.BatchID, l.Quantity); err != nil {
+			return nil, err
+		}
+		doc := inventory.Doc{Type: "purchase_request", ID: requestID}
+		if err := inventory.Reserve(tx, l.BatchID, l.Quantity, doc, actorID); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// recordPicks books a pick movement for every batch reserved for the request.
+func recordPicks(tx *sql.Tx, requestID, actorID int) error {
+	picked, err := reservedBatches(tx, requestID)
+	if err != nil {
+		return err
+	}
+
+	doc := inventory.Doc{Type: "purchase_request", ID: requestID}
+	for _, p := range picked {
+		if err := inventory.RecordPick(tx, p.batchID, p.qty, doc, actorID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/internal/db"
+)
+
+// batchLabelPrefix starts the code printed on batch labels, e.g. BATCH-42.
+// Bin labels carry the location code and products their own barcodes.
+const batchLabelPrefix = "BATCH-"
+
+func batchLabel(batchID int) string {
+	return batchLabelPrefix + strconv.Itoa(batchID)
+}
+
+// parseBatchLabel returns the batch ID encoded in a scanned batch label.
+func parseBatchLabel(code string) (int, bool) {
+	if !strings.HasPrefix(strings.ToUpper(code), batchLabelPrefix) {
+		return 0, false
+	}
+	id, err := strconv.Atoi(code[len(batchLabelPrefix):])
+	return id, err == nil && id > 0
+}
+
+// scannedBatch is a stock batch as a scanner sees it.
+type scannedBatch struct {
+	BatchID        int      `json:"batchId"`
+	ProductID      int      `json:"productId"`
+	ProductName    string   `json:"productName"`
+	Quantity       *float64 `json:"quantity,omitempty"`
+	ExpirationDate *string  `json:"expirationDate"`
+	LocationID     *int     `json:"locationId"`
+	BinCode        *string  `json:"binCode"`
+}
+
+func loadScannedBatch(q queryer, batchID int) (*scannedBatch, error) {
+	b := &scannedBatch{}
+	var qty float64
+	var exp sql.NullTime
+	var locationID sql.NullInt64
+	var binCode sql.NullString
+	err := q.QueryRow(`
+		SELECT s.batchID, s.productID, p.productName, s.quantity, s.expirationDate, s.locationID, l.code
+		FROM stock s
+		JOIN products p ON p.id = s.productID
+		LEFT JOIN locations l ON l.id = s.locationID
+		WHERE s.batchID = ?
+	`, batchID).Scan(&b.BatchID, &b.ProductID, &b.ProductName, &qty, &exp, &locationID, &binCode)
+	if err != nil {
+		return nil, err
+	}
+	b.Quantity = &qty
+	if exp.Valid {
+		d := exp.Time.Format("2006-01-02")
+		b.ExpirationDate = &d
+	}
+	if locationID.Valid {
+		id := int(locationID.Int64)
+		b.LocationID, b.BinCode = &id, &binCode.String
+	}
+	return b, nil
+}
+
+// scanResult is what a scanned code resolved to; exactly one of Batch,
+// Location and Product is set, matching Type.
+type scanResult struct {
+	Type     string         `json:"type"`
+	Code     string         `json:"code"`
+	Batch    *scannedBatch  `json:"batch,omitempty"`
+	Location *Location      `json:"location,omitempty"`
+	Product  *productDetail `json:"product,omitempty"`
+}
+
+// resolveScan looks a code up as a batch label, a bin label, a product
+// barcode and finally a SKU. It returns nil when nothing matches.
+func resolveScan(q queryer, code string) (*scanResult, error) {
+	res := &scanResult{Code: code}
+
+	if batchID, ok := parseBatchLabel(code); ok {
+		b, err := loadScannedBatch(q, batchID)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.Type, res.Batch = "batch", b
+		return res, nil
+	}
+
+	l, err := scanLocation(q.QueryRow(`
+		SELECT `+locationColumns+`
+		FROM locations l
+		JOIN (`+locationUsage+`) u ON u.id = l.id
+		WHERE l.code = ?
+	`, code))
+	if err == nil {
+		res.Type, res.Location = "location", &l
+		return res, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// A UPC-A code is an EAN-13 with a leading zero, and scanners report
+	// either form.
+	candidates := []string{code}
+	if len(code) == 12 {
+		candidates = append(candidates, "0"+code)
+	} else if len(code) == 13 && code[0] == '0' {
+		candidates = append(candidates, code[1:])
+	}
+	var productID, priority int
+	err = q.QueryRow(`
+		SELECT productID, 0 FROM product_barcodes WHERE barcode IN (`+placeholders(len(candidates))+`)
+		UNION ALL
+		SELECT id, 1 FROM products WHERE sku = ?
+		ORDER BY 2
+		LIMIT 1
+	`, append(stringArgs(candidates), code)...).Scan(&productID, &priority)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := loadProduct(q, productID)
+	if err != nil {
+		return nil, err
+	}
+	res.Type, res.Product = "product", p
+	return res, nil
+}
+
+// hideQuantities leaves the stock quantities out of a scan result, so a
+// worker scanning a bin during a blind count can't read what it holds.
+func (res *scanResult) hideQuantities() {
+	switch {
+	case res.Batch != nil:
+		res.Batch.Quantity = nil
+	case res.Location != nil:
+		res.Location.Used = nil
+	case res.Product != nil:
+		res.Product.Quantity = nil
+	}
+}
+
+func stringArgs(s []string) []interface{} {
+	out := make([]interface{}, len(s))
+	for i, v := range s {
+		out[i] = v
+	}
+	return out
+}
+
+// GET /api/scan?code={code}
+//
+// Workers get the result without stock quantities, see countsBlind.
+func ScanCode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		http.Error(w, "Missing code parameter", http.StatusBadRequest)
+		return
+	}
+
+	res, err := resolveScan(db.DB, code)
+	if err != nil {
+		log.Printf("Scan error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if res == nil {
+		http.Error(w, "Unknown code", http.StatusNotFound)
+		return
+	}
+	if countsBlind(r) {
+		res.hideQuantities()
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// pickCheck compares what a prepare task must pick from a batch with what
+// the worker has confirmed by scanning.
+type pickCheck struct {
+	BatchID   int     `json:"batchId"`
+	BinCode   *string `json:"binCode"`
+	Reserved  float64 `json:"reserved"`
+	Confirmed float64 `json:"confirmed"`
+}
+
+func (c pickCheck) done() bool {
+	return math.Abs(c.Reserved-c.Confirmed) <= quantityEpsilon
+}
+
+func pickProgress(q queryer, taskID int64, requestID int) ([]pickCheck, error) {
+	rows, err := q.Query(`
+		SELECT ab.batchID, l.code, SUM(ab.quantity),
+		       COALESCE((SELECT pc.quantity FROM pick_confirmations pc
+		                  WHERE pc.taskID = ? AND pc.batchID = ab.batchID), 0)
+		FROM assigned_batches ab
+		JOIN purchase_items pi ON pi.id = ab.itemID
+		JOIN stock s ON s.batchID = ab.batchID
+		LEFT JOIN locations l ON l.id = s.locationID
+		WHERE pi.requestID = ?
+		GROUP BY ab.batchID, l.code
+		ORDER BY ab.batchID
+	`, taskID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []pickCheck
+	for rows.Next() {
+		var c pickCheck
+		var binCode sql.NullString
+		if err := rows.Scan(&c.BatchID, &binCode, &c.Reserved, &c.Confirmed); err != nil {
+			return nil, err
+		}
+		if binCode.Valid {
+			c.BinCode = &binCode.String
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// unconfirmedPicks lists the batches of a prepare task that haven't been
+// scanned for exactly their reserved quantity.
+func unconfirmedPicks(tx *sql.Tx, taskID int64, requestID int) ([]lineError, error) {
+	checks, err := pickProgress(tx, taskID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	var problems []lineError
+	for _, c := range checks {
+		if !c.done() {
+			problems = append(problems, lineError{Line: -1, BatchID: c.BatchID,
+				Error: fmt.Sprintf("confirmed %g of %g", c.Confirmed, c.Reserved)})
+		}
+	}
+	return problems, nil
+}
+
+// POST /api/worker/tasks/{taskId}/confirm-pick
+//
+// Records that the worker picked quantity from a batch, identified by the
+// scanned batch label (code) or batchId. The batch must be reserved for the
+// task's request and confirmations can't exceed the reserved quantity.
+// Scanning the same batch again adds to what was confirmed.
+func ConfirmPick(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/worker/tasks/")
+	idStr = strings.TrimSuffix(idStr, "/confirm-pick")
+	taskID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		Code     string  `json:"code"`
+		BatchID  int     `json:"batchId"`
+		Quantity float64 `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if payload.Quantity <= 0 {
+		http.Error(w, "quantity must be positive", http.StatusBadRequest)
+		return
+	}
+	batchID := payload.BatchID
+	if code := strings.TrimSpace(payload.Code); code != "" {
+		id, ok := parseBatchLabel(code)
+		if !ok {
+			http.Error(w, "Scanned code is not a batch label", http.StatusUnprocessableEntity)
+			return
+		}
+		batchID = id
+	}
+	if batchID == 0 {
+		http.Error(w, "code or batchId required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := takeTask(tx, taskID, r); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	var taskType string
+	var requestID sql.NullInt64
+	var startedAt sql.NullTime
+	if err := tx.QueryRow(`SELECT type, requestID, started_at FROM tasks WHERE id = ?`, taskID).
+		Scan(&taskType, &requestID, &startedAt); err != nil {
+		http.Error(w, "Failed to load task info", http.StatusInternalServerError)
+		return
+	}
+	if taskType != "prepare" || !requestID.Valid {
+		http.Error(w, "Only prepare tasks are picked", http.StatusConflict)
+		return
+	}
+	if !startedAt.Valid {
+		http.Error(w, "Start the task before picking", http.StatusConflict)
+		return
+	}
+
+	checks, err := pickProgress(tx, int64(taskID), int(requestID.Int64))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var check *pickCheck
+	for i := range checks {
+		if checks[i].BatchID == batchID {
+			check = &checks[i]
+		}
+	}
+	if check == nil {
+		http.Error(w, fmt.Sprintf("Wrong batch: batch %d is not on this pick list", batchID), http.StatusUnprocessableEntity)
+		return
+	}
+	if check.Confirmed+payload.Quantity > check.Reserved+quantityEpsilon {
+		http.Error(w, fmt.Sprintf("Too much: only %g left to pick from batch %d",
+			check.Reserved-check.Confirmed, batchID), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO pick_confirmations (taskID, batchID, quantity, confirmedBy) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity), confirmedBy = VALUES(confirmedBy)
+	`, taskID, batchID, payload.Quantity, actorID(r)); err != nil {
+		http.Error(w, "Failed to record pick", http.StatusInternalServerError)
+		return
+	}
+	check.Confirmed += payload.Quantity
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	complete := true
+	for _, c := range checks {
+		complete = complete && c.done()
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"batchId":  batchID,
+		"picks":    checks,
+		"complete": complete,
+	})
+}
@@ -1,24 +1,55 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"backend/internal/db"
 )
 
+// Supplier carries the trading terms agreed with a supplier.
+type Supplier struct {
+	ID            int     `json:"id"`
+	SupplierName  string  `json:"supplierName"`
+	ContactEmail  string  `json:"contactEmail"`
+	ContactPhone  string  `json:"contactPhone"`
+	Address       string  `json:"address"`
+	LeadTimeDays  int     `json:"leadTimeDays"`
+	MinOrderValue float64 `json:"minOrderValue"`
+	Active        bool    `json:"active"`
+}
+
+const supplierColumns = `id, supplierName, COALESCE(contactEmail, ''), COALESCE(contactPhone, ''),
+	COALESCE(address, ''), leadTimeDays, minOrderValue, active`
+
+func scanSupplier(row interface{ Scan(...interface{}) error }) (Supplier, error) {
+	var s Supplier
+	err := row.Scan(&s.ID, &s.SupplierName, &s.ContactEmail, &s.ContactPhone,
+		&s.Address, &s.LeadTimeDays, &s.MinOrderValue, &s.Active)
+	return s, err
+}
+
+// SupplierPrice is one line of a supplier's price list.
+type SupplierPrice struct {
+	ProductID   int     `json:"productId"`
+	ProductName string  `json:"productName,omitempty"`
+	SupplierSKU string  `json:"supplierSku"`
+	CostPrice   float64 `json:"costPrice"`
+	PackSize    float64 `json:"packSize"`
+}
+
 // GET /api/suppliers
+//
+// ?active=true leaves out inactive suppliers.
 func GetSuppliers(w http.ResponseWriter, r *http.Request) {
-	type Supplier struct {
-		ID           int    `json:"id"`
-		SupplierName string `json:"supplierName"`
+	query := `SELECT ` + supplierColumns + ` FROM suppliers`
+	if r.URL.Query().Get("active") == "true" {
+		query += ` WHERE active = TRUE`
 	}
-
-	rows, err := db.DB.Query(`
-        SELECT id, supplierName
-        FROM suppliers
-        ORDER BY supplierName
-    `)
+	rows, err := db.DB.Query(query + ` ORDER BY supplierName`)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -27,8 +58,8 @@ func GetSuppliers(w http.ResponseWriter, r *http.Request) {
 
 	var suppliers []Supplier
 	for rows.Next() {
-		var s Supplier
-		if err := rows.Scan(&s.ID, &s.SupplierName); err != nil {
+		s, err := scanSupplier(rows)
+		if err != nil {
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
@@ -39,25 +70,56 @@ func GetSuppliers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(suppliers)
 }
 
+type supplierPayload struct {
+	SupplierName  string  `json:"supplierName"`
+	ContactEmail  string  `json:"contactEmail"`
+	ContactPhone  string  `json:"contactPhone"`
+	Address       string  `json:"address"`
+	LeadTimeDays  *int    `json:"leadTimeDays"`
+	MinOrderValue float64 `json:"minOrderValue"`
+	Active        *bool   `json:"active"`
+}
+
+func (p *supplierPayload) normalize() string {
+	p.SupplierName = strings.TrimSpace(p.SupplierName)
+	if p.SupplierName == "" {
+		return "Supplier name required"
+	}
+	if p.ContactEmail != "" && !strings.Contains(p.ContactEmail, "@") {
+		return "Invalid contact email"
+	}
+	if p.LeadTimeDays == nil {
+		days := 7
+		p.LeadTimeDays = &days
+	}
+	if *p.LeadTimeDays < 0 || p.MinOrderValue < 0 {
+		return "leadTimeDays and minOrderValue can't be negative"
+	}
+	if p.Active == nil {
+		active := true
+		p.Active = &active
+	}
+	return ""
+}
+
 // POST api/suppliers
 func CreateSupplier(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	var req struct {
-		SupplierName string `json:"supplierName"`
-	}
+	var req supplierPayload
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
-	if req.SupplierName == "" {
-		http.Error(w, "Supplier name required", http.StatusBadRequest)
+	if msg := req.normalize(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
 
-	result, err := db.DB.Exec(
-		`INSERT INTO suppliers (supplierName) VALUES (?)`,
-		req.SupplierName,
-	)
+	result, err := db.DB.Exec(`
+		INSERT INTO suppliers (supplierName, contactEmail, contactPhone, address, leadTimeDays, minOrderValue, active)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, req.SupplierName, nullString(req.ContactEmail), nullString(req.ContactPhone), nullString(req.Address),
+		*req.LeadTimeDays, req.MinOrderValue, *req.Active)
 	if err != nil {
 		http.Error(w, "Insert failed", http.StatusInternalServerError)
 		return
@@ -70,3 +132,211 @@ func CreateSupplier(w http.ResponseWriter, r *http.Request) {
 		"supplierName": req.SupplierName,
 	})
 }
+
+// supplierIDFromPath reads the ID after /api/suppliers/ and returns the rest
+// of the path.
+func supplierIDFromPath(path string) (int, string, error) {
+	rest := strings.TrimPrefix(path, "/api/suppliers/")
+	idStr, tail, _ := strings.Cut(rest, "/")
+	id, err := strconv.Atoi(idStr)
+	return id, tail, err
+}
+
+// GET /api/suppliers/{id}
+func GetSupplier(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, _, err := supplierIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, "Invalid supplier ID", http.StatusBadRequest)
+		return
+	}
+
+	s, err := scanSupplier(db.DB.QueryRow(`SELECT `+supplierColumns+` FROM suppliers WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT sp.productID, p.productName, sp.supplierSku, sp.costPrice, sp.packSize
+		FROM supplier_products sp
+		JOIN products p ON p.id = sp.productID
+		WHERE sp.supplierID = ?
+		ORDER BY p.productName
+	`, id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	prices := []SupplierPrice{}
+	for rows.Next() {
+		var p SupplierPrice
+		if err := rows.Scan(&p.ProductID, &p.ProductName, &p.SupplierSKU, &p.CostPrice, &p.PackSize); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		prices = append(prices, p)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"supplier":  s,
+		"priceList": prices,
+	})
+}
+
+// PUT /api/suppliers/{id}
+func UpdateSupplier(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, _, err := supplierIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, "Invalid supplier ID", http.StatusBadRequest)
+		return
+	}
+	var req supplierPayload
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if msg := req.normalize(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var exists int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM suppliers WHERE id = ?`, id).Scan(&exists); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if exists == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if _, err := db.DB.Exec(`
+		UPDATE suppliers
+		   SET supplierName = ?, contactEmail = ?, contactPhone = ?, address = ?,
+		       leadTimeDays = ?, minOrderValue = ?, active = ?
+		 WHERE id = ?
+	`, req.SupplierName, nullString(req.ContactEmail), nullString(req.ContactPhone), nullString(req.Address),
+		*req.LeadTimeDays, req.MinOrderValue, *req.Active, id); err != nil {
+		http.Error(w, "Update failed", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "supplierName": req.SupplierName})
+}
+
+// DELETE /api/suppliers/{id}
+//
+// Deleting a supplier would cascade to its products, so suppliers that still
+// have products or purchase orders can only be deactivated.
+func DeleteSupplier(w http.ResponseWriter, r *http.Request) {
+	id, _, err := supplierIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, "Invalid supplier ID", http.StatusBadRequest)
+		return
+	}
+
+	var used int
+	if err := db.DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM products WHERE supplierID = ?)
+		     + (SELECT COUNT(*) FROM purchase_orders WHERE supplierID = ?)
+	`, id, id).Scan(&used); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if used > 0 {
+		http.Error(w, "Supplier has products or purchase orders; deactivate it instead", http.StatusConflict)
+		return
+	}
+
+	res, err := db.DB.Exec(`DELETE FROM suppliers WHERE id = ?`, id)
+	if err != nil {
+		http.Error(w, "Delete failed", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PUT /api/suppliers/{id}/prices/{productId}
+// DELETE /api/suppliers/{id}/prices/{productId}
+func SupplierPriceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	supplierID, tail, err := supplierIDFromPath(r.URL.Path)
+	if err != nil || !strings.HasPrefix(tail, "prices/") {
+		http.Error(w, "Invalid supplier ID", http.StatusBadRequest)
+		return
+	}
+	productID, err := strconv.Atoi(strings.TrimPrefix(tail, "prices/"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if _, err := db.DB.Exec(`DELETE FROM supplier_products WHERE supplierID = ? AND productID = ?`, supplierID, productID); err != nil {
+			http.Error(w, "Delete failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var p SupplierPrice
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	p.ProductID = productID
+	p.SupplierSKU = strings.TrimSpace(p.SupplierSKU)
+	if p.PackSize == 0 {
+		p.PackSize = 1
+	}
+	if p.SupplierSKU == "" || p.CostPrice < 0 || p.PackSize < 0 {
+		http.Error(w, "supplierSku required; costPrice and packSize can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	var known int
+	if err := db.DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM suppliers WHERE id = ?) * (SELECT COUNT(*) FROM products WHERE id = ?)
+	`, supplierID, productID).Scan(&known); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if known == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var taken int
+	if err := db.DB.QueryRow(`
+		SELECT COUNT(*) FROM supplier_products WHERE supplierID = ? AND supplierSku = ? AND productID <> ?
+	`, supplierID, p.SupplierSKU, productID).Scan(&taken); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if taken > 0 {
+		http.Error(w, "Supplier SKU already used for another product", http.StatusConflict)
+		return
+	}
+
+	if _, err := db.DB.Exec(`
+		INSERT INTO supplier_products (supplierID, productID, supplierSku, costPrice, packSize)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE supplierSku = VALUES(supplierSku), costPrice = VALUES(costPrice), packSize = VALUES(packSize)
+	`, supplierID, productID, p.SupplierSKU, p.CostPrice, p.PackSize); err != nil {
+		http.Error(w, "Update failed", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(p)
+}
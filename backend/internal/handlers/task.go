@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"backend/internal/db"
+	"backend/internal/models"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -14,10 +15,12 @@ func CreateTask(w http.ResponseWriter, r *http.Request) {
 
 	// Decode a payload that might have either requestId or orderId
 	var req struct {
-		Type      string `json:"type"`                // "unload", "prepare", or "dispose"
-		RequestID *int   `json:"requestId,omitempty"` // for prepare/dispose
-		OrderID   *int   `json:"orderId,omitempty"`   // for unload
-		WorkerID  int    `json:"workerId"`            // who gets the task
+		Type      string        `json:"type"`                // "unload", "prepare", "dispose" or "transfer"
+		RequestID *int          `json:"requestId,omitempty"` // for prepare
+		OrderID   *int          `json:"orderId,omitempty"`   // for unload
+		Items     []disposeLine `json:"items,omitempty"`     // for dispose
+		Transfer  *transferLine `json:"transfer,omitempty"`  // for transfer
+		WorkerID  int           `json:"workerId"`            // who gets the task; 0 dispatches it
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
@@ -25,44 +28,113 @@ func CreateTask(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Basic validation
-	if req.Type != "unload" && req.Type != "prepare" && req.Type != "dispose" {
+	if req.Type != "unload" && req.Type != "prepare" && req.Type != "dispose" && req.Type != "transfer" {
 		http.Error(w, "Invalid type", http.StatusBadRequest)
 		return
 	}
-	if req.WorkerID == 0 {
-		http.Error(w, "Missing workerId", http.StatusBadRequest)
-		return
+
+	if req.WorkerID != 0 {
+		var role string
+		err := db.DB.QueryRow(`SELECT role FROM users WHERE id = ?`, req.WorkerID).Scan(&role)
+		if err != nil || role != models.RoleWorker {
+			http.Error(w, "Unknown worker", http.StatusBadRequest)
+			return
+		}
 	}
 
-	// Build the INSERT depending on the task type
-	var (
-		sqlStmt string
-		arg     interface{}
-	)
+	// Pick the reference column depending on the task type
+	var requestID, orderID interface{}
 	switch req.Type {
 	case "unload":
 		if req.OrderID == nil {
 			http.Error(w, "Missing orderId for unload task", http.StatusBadRequest)
 			return
 		}
-		sqlStmt = `INSERT INTO tasks (orderID, workerID, type) VALUES (?, ?, ?)`
-		arg = *req.OrderID
-	case "prepare", "dispose":
+		orderID = *req.OrderID
+	case "dispose":
+		tx, err := db.DB.Begin()
+		if err != nil {
+			http.Error(w, "Transaction error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		id, problems, err := createDisposeTask(tx, req.Items, req.WorkerID)
+		if err != nil {
+			log.Printf("CreateTask dispose error: %v", err)
+			http.Error(w, "Insert failed", http.StatusInternalServerError)
+			return
+		}
+		if len(problems) > 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Invalid dispose items",
+				"lines": problems,
+			})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Insert failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "taskId": id})
+		return
+	case "transfer":
+		if req.Transfer == nil {
+			http.Error(w, "Missing transfer for transfer task", http.StatusBadRequest)
+			return
+		}
+		tx, err := db.DB.Begin()
+		if err != nil {
+			http.Error(w, "Transaction error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		id, msg, err := createTransferTask(tx, *req.Transfer, "manual", req.WorkerID)
+		if err != nil {
+			log.Printf("CreateTask transfer error: %v", err)
+			http.Error(w, "Insert failed", http.StatusInternalServerError)
+			return
+		}
+		if msg != "" {
+			http.Error(w, msg, http.StatusUnprocessableEntity)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Insert failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "taskId": id})
+		return
+	case "prepare":
 		if req.RequestID == nil {
 			http.Error(w, "Missing requestId for "+req.Type+" task", http.StatusBadRequest)
 			return
 		}
-		sqlStmt = `INSERT INTO tasks (requestID, workerID, type) VALUES (?, ?, ?)`
-		arg = *req.RequestID
+		requestID = *req.RequestID
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Transaction error", http.StatusInternalServerError)
+		return
 	}
+	defer tx.Rollback()
 
-	// Execute
-	if _, err := db.DB.Exec(sqlStmt, arg, req.WorkerID, req.Type); err != nil {
+	id, err := insertTask(tx, req.Type, requestID, orderID, req.WorkerID)
+	if err != nil {
 		log.Printf("CreateTask insert error: %v", err)
 		http.Error(w, "Insert failed", http.StatusInternalServerError)
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Insert failed", http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "taskId": id})
 }
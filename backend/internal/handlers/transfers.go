@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"backend/internal/db"
+	"backend/internal/inventory"
+)
+
+var (
+	errTransferShort  = errors.New("batch no longer holds the quantity to transfer")
+	errTransferNoRoom = errors.New("destination location has no room")
+)
+
+// transferLine moves a quantity of one batch to another location.
+type transferLine struct {
+	BatchID      int     `json:"batchId"`
+	ToLocationID int     `json:"toLocationId"`
+	Quantity     float64 `json:"quantity"`
+}
+
+// pendingTransferOut is how much of a batch open transfer tasks will take.
+func pendingTransferOut(q queryer, batchID int) (float64, error) {
+	var qty float64
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(ti.quantity), 0)
+		FROM transfer_items ti
+		JOIN tasks t ON t.id = ti.taskID
+		WHERE ti.batchID = ? AND t.status = 'pending'
+	`, batchID).Scan(&qty)
+	return qty, err
+}
+
+// createTransferTask validates l against stock and the destination and
+// creates a transfer task for it. A non-empty message means l was rejected.
+func createTransferTask(tx *sql.Tx, l transferLine, reason string, workerID int) (int64, string, error) {
+	if l.Quantity <= 0 {
+		return 0, "quantity must be positive", nil
+	}
+
+	var available float64
+	var from sql.NullInt64
+	err := tx.QueryRow(`SELECT quantity, locationID FROM stock WHERE batchID = ? FOR UPDATE`, l.BatchID).
+		Scan(&available, &from)
+	if err == sql.ErrNoRows {
+		return 0, "batch does not exist", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if from.Valid && int(from.Int64) == l.ToLocationID {
+		return 0, "batch is already in that location", nil
+	}
+
+	pending, err := pendingTransferOut(tx, l.BatchID)
+	if err != nil {
+		return 0, "", err
+	}
+	if l.Quantity > available-pending+quantityEpsilon {
+		return 0, "quantity exceeds what is free in the batch", nil
+	}
+
+	ok, err := checkLocationRoom(tx, l.ToLocationID, l.Quantity)
+	if err != nil {
+		return 0, "", err
+	}
+	if !ok {
+		return 0, "destination is unknown, inactive or has no room", nil
+	}
+
+	taskID, err := insertTask(tx, "transfer", nil, nil, workerID)
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO transfer_items (taskID, batchID, toLocationID, quantity, reason) VALUES (?, ?, ?, ?, ?)`,
+		taskID, l.BatchID, l.ToLocationID, l.Quantity, reason,
+	); err != nil {
+		return 0, "", err
+	}
+	return taskID, "", nil
+}
+
+// completeTransferTask carries out the move. The whole batch is relocated
+// when all of it goes and nothing on it is reserved; otherwise the quantity
+// is split off into a new batch at the destination.
+func completeTransferTask(tx *sql.Tx, taskID int64, actorID int) error {
+	var l transferLine
+	err := tx.QueryRow(
+		`SELECT batchID, toLocationID, quantity FROM transfer_items WHERE taskID = ?`, taskID,
+	).Scan(&l.BatchID, &l.ToLocationID, &l.Quantity)
+	if err != nil {
+		return err
+	}
+
+	var available float64
+	err = tx.QueryRow(`SELECT quantity FROM stock WHERE batchID = ? FOR UPDATE`, l.BatchID).Scan(&available)
+	if err == sql.ErrNoRows {
+		return errTransferShort
+	}
+	if err != nil {
+		return err
+	}
+	if l.Quantity > available+quantityEpsilon {
+		return errTransferShort
+	}
+
+	// Reserved units sit on the same shelf until they are picked.
+	reserved, err := unpickedReserved(tx, l.BatchID)
+	if err != nil {
+		return err
+	}
+
+	ok, err := checkLocationRoom(tx, l.ToLocationID, l.Quantity)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errTransferNoRoom
+	}
+
+	doc := inventory.Doc{Type: "task", ID: int(taskID)}
+	if reserved <= quantityEpsilon && l.Quantity >= available-quantityEpsilon {
+		return inventory.Move(tx, l.BatchID, l.ToLocationID, doc, actorID)
+	}
+	_, err = inventory.Split(tx, l.BatchID, l.Quantity, l.ToLocationID, doc, actorID)
+	return err
+}
+
+// replenish creates transfer tasks for every pick face among locationIDs
+// (all pick faces when nil) that holds less than its minimum and has no
+// replenishment under way. It tops the face up to capacity from other bins,
+// earliest expiry first, and returns the created task IDs.
+func replenish(tx *sql.Tx, locationIDs []int) ([]int64, error) {
+	query := `
+		SELECT l.id, l.productID, l.capacity - u.used
+		FROM locations l
+		JOIN (` + locationUsage + `) u ON u.id = l.id
+		WHERE l.active = TRUE AND l.productID IS NOT NULL AND l.minQuantity IS NOT NULL
+		  AND u.used < l.minQuantity
+		  AND NOT EXISTS (SELECT 1 FROM transfer_items ti JOIN tasks t ON t.id = ti.taskID
+		                   WHERE ti.toLocationID = l.id AND t.status = 'pending')`
+	var args []interface{}
+	if locationIDs != nil {
+		if len(locationIDs) == 0 {
+			return nil, nil
+		}
+		query += ` AND l.id IN (` + placeholders(len(locationIDs)) + `)`
+		for _, id := range locationIDs {
+			args = append(args, id)
+		}
+	}
+	query += ` ORDER BY l.id FOR UPDATE OF l`
+
+	type face struct {
+		id, productID int
+		need          float64
+	}
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var faces []face
+	for rows.Next() {
+		var f face
+		if err := rows.Scan(&f.id, &f.productID, &f.need); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		faces = append(faces, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var created []int64
+	for _, f := range faces {
+		srcRows, err := tx.Query(`
+			SELECT s.batchID, s.quantity
+			FROM stock s
+			JOIN locations l ON l.id = s.locationID
+			WHERE s.productID = ? AND s.quantity > 0 AND s.blocked = FALSE AND s.locationID <> ?
+			  AND (l.productID IS NULL OR l.productID <> s.productID)
+			ORDER BY (s.expirationDate IS NULL), s.expirationDate, s.batchID
+		`, f.productID, f.id)
+		if err != nil {
+			return nil, err
+		}
+		var sources []batchQty
+		for srcRows.Next() {
+			var b batchQty
+			if err := srcRows.Scan(&b.batchID, &b.qty); err != nil {
+				srcRows.Close()
+				return nil, err
+			}
+			sources = append(sources, b)
+		}
+		srcRows.Close()
+		if err := srcRows.Err(); err != nil {
+			return nil, err
+		}
+
+		need := f.need
+		for _, src := range sources {
+			if need <= quantityEpsilon {
+				break
+			}
+			pending, err := pendingTransferOut(tx, src.batchID)
+			if err != nil {
+				return nil, err
+			}
+			qty := src.qty - pending
+			if qty <= quantityEpsilon {
+				continue
+			}
+			if qty > need {
+				qty = need
+			}
+			id, msg, err := createTransferTask(tx, transferLine{BatchID: src.batchID, ToLocationID: f.id, Quantity: qty}, "replenishment", 0)
+			if err != nil {
+				return nil, err
+			}
+			if msg != "" {
+				log.Printf("Replenishment of location %d from batch %d skipped: %s", f.id, src.batchID, msg)
+				continue
+			}
+			created = append(created, id)
+			need -= qty
+		}
+	}
+	return created, nil
+}
+
+// requestLocations lists the bins a request was picked from.
+func requestLocations(tx *sql.Tx, requestID int) ([]int, error) {
+	rows, err := tx.Query(`
+		SELECT DISTINCT s.locationID
+		FROM assigned_batches ab
+		JOIN purchase_items pi ON pi.id = ab.itemID
+		JOIN stock s ON s.batchID = ab.batchID
+		WHERE pi.requestID = ? AND s.locationID IS NOT NULL
+		ORDER BY s.locationID
+	`, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// POST /api/transfers/replenish
+func RunReplenishment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	ids, err := replenish(tx, nil)
+	if err != nil {
+		log.Printf("Replenishment error: %v", err)
+		http.Error(w, "Failed to create replenishment tasks", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	if ids == nil {
+		ids = []int64{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"taskIds": ids})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"backend/internal/db"
+	"backend/internal/models"
 	"encoding/json"
 	"net/http"
 
@@ -26,6 +27,12 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing fields", http.StatusBadRequest)
 		return
 	}
+	switch p.Role {
+	case models.RoleCustomer, models.RoleWorker, models.RoleAdmin, models.RoleDemo:
+	default:
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(p.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"backend/internal/db"
+	"backend/internal/inventory"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -20,27 +23,43 @@ func GetWorkerTasks(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid or missing workerId", http.StatusBadRequest)
 		return
 	}
+	if !canAccessUser(r, workerID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	type TaskItem struct {
 		ProductName string  `json:"productName"`
 		BatchID     int     `json:"batchId"`
-		Quantity    float64 `json:"quantity"`
+		Quantity    float64 `json:"quantity,omitempty"` // left out of count tasks: counts are blind
+		Reason      string  `json:"reason,omitempty"`
+		BinCode     string  `json:"binCode,omitempty"`
+		ToBinCode   string  `json:"toBinCode,omitempty"`
 	}
 
+	// Unassigned tasks are listed too so workers can see the queue they
+	// can claim from.
 	type Task struct {
-		TaskID int        `json:"taskId"`
-		Type   string     `json:"type"`
-		Items  []TaskItem `json:"items"`
+		TaskID     int        `json:"taskId"`
+		Type       string     `json:"type"`
+		Unassigned bool       `json:"unassigned"`
+		WaveID     int        `json:"waveId,omitempty"`
+		Items      []TaskItem `json:"items"`
+	}
+
+	if err := releaseExpiredClaims(db.DB); err != nil {
+		http.Error(w, "DB error (claims)", http.StatusInternalServerError)
+		return
 	}
 
 	tasksMap := make(map[int]*Task)
 
 	unloadRows, err := db.DB.Query(`
-        SELECT t.id, t.type, p.productName, op.quantity
+        SELECT t.id, t.type, t.workerID IS NULL, op.productID, p.productName, op.quantity
         FROM tasks t
         JOIN orderedProducts op ON op.id = t.orderID
         JOIN products p ON p.id = op.productID
-        WHERE t.workerID = ? AND t.status = 'pending' AND t.type = 'unload'
+        WHERE (t.workerID = ? OR t.workerID IS NULL) AND t.status = 'pending' AND t.type = 'unload'
     `, workerID)
 	if err != nil {
 		http.Error(w, "DB error (unload)", http.StatusInternalServerError)
@@ -48,33 +67,60 @@ func GetWorkerTasks(w http.ResponseWriter, r *http.Request) {
 	}
 	defer unloadRows.Close()
 
+	type unload struct {
+		taskID, productID int
+		qty               float64
+	}
+	var unloads []unload
+
 	for unloadRows.Next() {
-		var taskID int
+		var taskID, productID int
 		var taskType, productName string
+		var unassigned bool
 		var qty float64
-		if err := unloadRows.Scan(&taskID, &taskType, &productName, &qty); err != nil {
+		if err := unloadRows.Scan(&taskID, &taskType, &unassigned, &productID, &productName, &qty); err != nil {
 			http.Error(w, "Scan error (unload)", http.StatusInternalServerError)
 			return
 		}
 		tasksMap[taskID] = &Task{
-			TaskID: taskID,
-			Type:   taskType,
+			TaskID:     taskID,
+			Type:       taskType,
+			Unassigned: unassigned,
 			Items: []TaskItem{
 				{ProductName: productName, BatchID: 0, Quantity: qty},
 			},
 		}
+		unloads = append(unloads, unload{taskID, productID, qty})
+	}
+	unloadRows.Close()
+
+	// Unload items carry the suggested put-away bin.
+	if len(unloads) > 0 {
+		planner, err := newPutawayPlanner(db.DB)
+		if err != nil {
+			http.Error(w, "DB error (putaway)", http.StatusInternalServerError)
+			return
+		}
+		for _, u := range unloads {
+			if loc := planner.suggest(u.productID, u.qty); loc != nil {
+				tasksMap[u.taskID].Items[0].BinCode = loc.Code
+			}
+		}
 	}
 
+	// Prepare items come back in walking order so the list doubles as the
+	// picking route.
 	prepareRows, err := db.DB.Query(`
-        SELECT t.id, t.type, p.productName, ab.batchID, ab.quantity
+        SELECT t.id, t.type, t.workerID IS NULL, COALESCE(t.waveID, 0), p.productName, ab.batchID, ab.quantity, COALESCE(l.code, '')
         FROM tasks t
         JOIN purchase_requests pr ON pr.id = t.requestID
         JOIN purchase_items pi ON pi.requestID = pr.id
         JOIN assigned_batches ab ON ab.itemID = pi.id
         JOIN stock s ON s.batchID = ab.batchID
         JOIN products p ON p.id = s.productID
-        WHERE t.workerID = ? AND t.status = 'pending' AND t.type = 'prepare' AND ab.quantity > 0
-        ORDER BY t.id
+        LEFT JOIN locations l ON l.id = s.locationID
+        WHERE (t.workerID = ? OR t.workerID IS NULL) AND t.status = 'pending' AND t.type = 'prepare' AND ab.quantity > 0
+        ORDER BY t.id, `+pickRouteOrder+`
     `, workerID)
 	if err != nil {
 		http.Error(w, "DB error (other)", http.StatusInternalServerError)
@@ -83,38 +129,222 @@ func GetWorkerTasks(w http.ResponseWriter, r *http.Request) {
 	defer prepareRows.Close()
 
 	for prepareRows.Next() {
-		var taskID, batchID int
-		var taskType, productName string
+		var taskID, waveID, batchID int
+		var taskType, productName, binCode string
+		var unassigned bool
 		var qty float64
 
-		if err := prepareRows.Scan(&taskID, &taskType, &productName, &batchID, &qty); err != nil {
+		if err := prepareRows.Scan(&taskID, &taskType, &unassigned, &waveID, &productName, &batchID, &qty, &binCode); err != nil {
 			http.Error(w, "Scan error (other)", http.StatusInternalServerError)
 			return
 		}
 		acc, exists := tasksMap[taskID]
 		if !exists {
-			acc = &Task{TaskID: taskID, Type: taskType}
+			acc = &Task{TaskID: taskID, Type: taskType, Unassigned: unassigned, WaveID: waveID}
 			tasksMap[taskID] = acc
 		}
 		acc.Items = append(acc.Items, TaskItem{
 			ProductName: productName,
 			BatchID:     batchID,
 			Quantity:    qty,
+			BinCode:     binCode,
 		})
 	}
 
-	var out []Task
+	disposeRows, err := db.DB.Query(`
+        SELECT t.id, t.type, t.workerID IS NULL, p.productName, di.batchID, di.quantity, di.reason, COALESCE(l.code, '')
+        FROM tasks t
+        JOIN dispose_items di ON di.taskID = t.id
+        JOIN stock s ON s.batchID = di.batchID
+        JOIN products p ON p.id = s.productID
+        LEFT JOIN locations l ON l.id = s.locationID
+        WHERE (t.workerID = ? OR t.workerID IS NULL) AND t.status = 'pending' AND t.type = 'dispose'
+        ORDER BY t.id, di.batchID
+    `, workerID)
+	if err != nil {
+		http.Error(w, "DB error (dispose)", http.StatusInternalServerError)
+		return
+	}
+	defer disposeRows.Close()
+
+	for disposeRows.Next() {
+		var taskID, batchID int
+		var taskType, productName, reason, binCode string
+		var unassigned bool
+		var qty float64
+
+		if err := disposeRows.Scan(&taskID, &taskType, &unassigned, &productName, &batchID, &qty, &reason, &binCode); err != nil {
+			http.Error(w, "Scan error (dispose)", http.StatusInternalServerError)
+			return
+		}
+		acc, exists := tasksMap[taskID]
+		if !exists {
+			acc = &Task{TaskID: taskID, Type: taskType, Unassigned: unassigned}
+			tasksMap[taskID] = acc
+		}
+		acc.Items = append(acc.Items, TaskItem{
+			ProductName: productName,
+			BatchID:     batchID,
+			Quantity:    qty,
+			Reason:      reason,
+			BinCode:     binCode,
+		})
+	}
+
+	transferRows, err := db.DB.Query(`
+        SELECT t.id, t.type, t.workerID IS NULL, p.productName, ti.batchID, ti.quantity, ti.reason,
+               COALESCE(src.code, ''), dst.code
+        FROM tasks t
+        JOIN transfer_items ti ON ti.taskID = t.id
+        JOIN stock s ON s.batchID = ti.batchID
+        JOIN products p ON p.id = s.productID
+        LEFT JOIN locations src ON src.id = s.locationID
+        JOIN locations dst ON dst.id = ti.toLocationID
+        WHERE (t.workerID = ? OR t.workerID IS NULL) AND t.status = 'pending' AND t.type = 'transfer'
+    `, workerID)
+	if err != nil {
+		http.Error(w, "DB error (transfer)", http.StatusInternalServerError)
+		return
+	}
+	defer transferRows.Close()
+
+	for transferRows.Next() {
+		var taskID, batchID int
+		var taskType, productName, reason, from, to string
+		var unassigned bool
+		var qty float64
+
+		if err := transferRows.Scan(&taskID, &taskType, &unassigned, &productName, &batchID, &qty, &reason, &from, &to); err != nil {
+			http.Error(w, "Scan error (transfer)", http.StatusInternalServerError)
+			return
+		}
+		tasksMap[taskID] = &Task{
+			TaskID:     taskID,
+			Type:       taskType,
+			Unassigned: unassigned,
+			Items: []TaskItem{{
+				ProductName: productName,
+				BatchID:     batchID,
+				Quantity:    qty,
+				Reason:      reason,
+				BinCode:     from,
+				ToBinCode:   to,
+			}},
+		}
+	}
+
+	countRows, err := db.DB.Query(`
+        SELECT t.id, t.type, t.workerID IS NULL, p.productName, ci.batchID, COALESCE(l.code, '')
+        FROM tasks t
+        JOIN count_items ci ON ci.taskID = t.id
+        JOIN stock s ON s.batchID = ci.batchID
+        JOIN products p ON p.id = s.productID
+        LEFT JOIN locations l ON l.id = s.locationID
+        WHERE (t.workerID = ? OR t.workerID IS NULL) AND t.status = 'pending' AND t.type = 'count'
+        ORDER BY t.id, `+pickRouteOrder+`
+    `, workerID)
+	if err != nil {
+		http.Error(w, "DB error (count)", http.StatusInternalServerError)
+		return
+	}
+	defer countRows.Close()
+
+	for countRows.Next() {
+		var taskID, batchID int
+		var taskType, productName, binCode string
+		var unassigned bool
+
+		if err := countRows.Scan(&taskID, &taskType, &unassigned, &productName, &batchID, &binCode); err != nil {
+			http.Error(w, "Scan error (count)", http.StatusInternalServerError)
+			return
+		}
+		acc, exists := tasksMap[taskID]
+		if !exists {
+			acc = &Task{TaskID: taskID, Type: taskType, Unassigned: unassigned}
+			tasksMap[taskID] = acc
+		}
+		acc.Items = append(acc.Items, TaskItem{
+			ProductName: productName,
+			BatchID:     batchID,
+			BinCode:     binCode,
+		})
+	}
+
+	out := make([]Task, 0, len(tasksMap))
 	for _, t := range tasksMap {
 		out = append(out, *t)
 	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TaskID < out[j].TaskID })
 
 	json.NewEncoder(w).Encode(out)
 }
 
+// POST /api/worker/tasks/{taskId}/start
+func StartWorkerTask(w http.ResponseWriter, r *http.Request) {
+	taskIdStr := strings.TrimPrefix(r.URL.Path, "/api/worker/tasks/")
+	taskIdStr = strings.TrimSuffix(taskIdStr, "/start")
+	taskID, err := strconv.Atoi(taskIdStr)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := takeTask(tx, taskID, r); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	var taskType string
+	var requestID sql.NullInt64
+	err = tx.QueryRow(
+		`SELECT requestID, type FROM tasks WHERE id = ?`,
+		taskID,
+	).Scan(&requestID, &taskType)
+	if err != nil {
+		http.Error(w, "Failed to load task info", http.StatusInternalServerError)
+		return
+	}
+
+	// A started task no longer times out back into the queue.
+	if _, err := tx.Exec(
+		`UPDATE tasks SET started_at = NOW(), claim_expires_at = NULL WHERE id = ?`,
+		taskID,
+	); err != nil {
+		http.Error(w, "Failed to update task", http.StatusInternalServerError)
+		return
+	}
+
+	// Once picking starts the customer can no longer cancel the request.
+	if taskType == "prepare" && requestID.Valid {
+		if err := advanceRequest(tx, int(requestID.Int64), "picking", actorID(r)); err != nil {
+			writeTransitionError(w, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
 // POST /api/worker/tasks/{taskId}/complete
 func CompleteWorkerTask(w http.ResponseWriter, r *http.Request) {
 	taskIdStr := strings.TrimPrefix(r.URL.Path, "/api/worker/tasks/")
 	taskIdStr = strings.TrimSuffix(taskIdStr, "/complete")
+	taskID, err := strconv.Atoi(taskIdStr)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
 
 	tx, err := db.DB.Begin()
 	if err != nil {
@@ -123,6 +353,25 @@ func CompleteWorkerTask(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
+	if err := takeTask(tx, taskID, r); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	// The body is optional; unload tasks may name the put-away bin and what
+	// actually arrived, count tasks carry the counted quantities.
+	var payload struct {
+		LocationID int         `json:"locationId"`
+		Counts     []countLine `json:"counts"`
+		receipt
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
 	if _, err := tx.Exec(
 		`UPDATE tasks SET status = 'completed' WHERE id = ?`,
 		taskIdStr,
@@ -157,39 +406,154 @@ func CompleteWorkerTask(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if msg := payload.receipt.validate(qty); msg != "" {
+			http.Error(w, msg, http.StatusUnprocessableEntity)
+			return
+		}
+
 		var expVal interface{}
-		if expRaw.Valid {
+		if payload.ExpirationDate != nil {
+			expVal = *payload.ExpirationDate
+		} else if expRaw.Valid {
 			expVal = expRaw.Time
 		} else {
 			expVal = nil
 		}
-		if _, err := tx.Exec(
-			`INSERT INTO stock (productID, quantity, expirationDate)
-             VALUES (?, ?, ?)`,
-			productID, qty, expVal,
-		); err != nil {
-			http.Error(w, "Failed to create stock batch", http.StatusInternalServerError)
+
+		// Damaged units are reported but never reach stock.
+		good := *payload.Received - payload.Damaged
+		if good > quantityEpsilon {
+			// Put-away: use the bin the worker chose, or suggest one.
+			locationID := payload.LocationID
+			if locationID != 0 {
+				ok, err := checkLocationRoom(tx, locationID, good)
+				if err != nil {
+					http.Error(w, "Failed to check location", http.StatusInternalServerError)
+					return
+				}
+				if !ok {
+					http.Error(w, "Location is unknown, inactive or has no room", http.StatusConflict)
+					return
+				}
+			} else {
+				loc, err := suggestPutaway(tx, productID, good)
+				if err != nil {
+					http.Error(w, "Failed to suggest location", http.StatusInternalServerError)
+					return
+				}
+				if loc != nil {
+					// The suggestion was read without locks; claim the
+					// room, or leave the stock unslotted if another
+					// put-away took it first.
+					ok, err := checkLocationRoom(tx, loc.ID, good)
+					if err != nil {
+						http.Error(w, "Failed to check location", http.StatusInternalServerError)
+						return
+					}
+					if ok {
+						locationID = loc.ID
+					}
+				}
+			}
+
+			doc := inventory.Doc{Type: "ordered_product", ID: int(orderID.Int64)}
+			batchID, err := inventory.Receive(tx, productID, good, expVal, payload.LotNumber, locationID, doc, actorID(r))
+			if err != nil {
+				http.Error(w, "Failed to create stock batch", http.StatusInternalServerError)
+				return
+			}
+
+			if err := fulfillBackorders(tx, productID, batchID, actorID(r)); err != nil {
+				log.Printf("Backorder fulfilment error: %v", err)
+				http.Error(w, "Failed to fulfil backorders", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// The delivery line stays as the record of what arrived.
+		if err := recordReceipt(tx, int(orderID.Int64), qty, payload.receipt, actorID(r)); err != nil {
+			log.Printf("Receipt error: %v", err)
+			http.Error(w, "Failed to record receipt", http.StatusInternalServerError)
 			return
 		}
 
-		if _, err := tx.Exec(
-			`DELETE FROM orderedProducts WHERE id = ?`,
-			orderID.Int64,
-		); err != nil {
-			http.Error(w, "Failed to remove delivery", http.StatusInternalServerError)
+	case "transfer":
+		err := completeTransferTask(tx, int64(taskID), actorID(r))
+		if errors.Is(err, errTransferShort) || errors.Is(err, errTransferNoRoom) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Transfer completion error: %v", err)
+			http.Error(w, "Failed to transfer stock", http.StatusInternalServerError)
+			return
+		}
+
+	case "count":
+		problems, err := completeCountTask(tx, int64(taskID), payload.Counts, actorID(r))
+		if err != nil {
+			log.Printf("Count completion error: %v", err)
+			http.Error(w, "Failed to record count", http.StatusInternalServerError)
+			return
+		}
+		if len(problems) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Invalid counts",
+				"lines": problems,
+			})
+			return
+		}
+
+	case "dispose":
+		if err := completeDisposeTask(tx, int64(taskID), actorID(r)); err != nil {
+			log.Printf("Dispose completion error: %v", err)
+			http.Error(w, "Failed to write off stock", http.StatusInternalServerError)
 			return
 		}
 
 	default:
 		if !requestID.Valid {
-			http.Error(w, "No requestID for prepare/dispose task", http.StatusBadRequest)
+			http.Error(w, "No requestID for prepare task", http.StatusBadRequest)
+			return
+		}
+
+		// Every reserved batch must have been scanned for its full quantity.
+		problems, err := unconfirmedPicks(tx, int64(taskID), int(requestID.Int64))
+		if err != nil {
+			http.Error(w, "Failed to check picks", http.StatusInternalServerError)
 			return
 		}
-		if _, err := tx.Exec(
-			`UPDATE purchase_requests SET status = 'shipped' WHERE id = ?`,
-			requestID.Int64,
-		); err != nil {
-			http.Error(w, "Failed to update request status", http.StatusInternalServerError)
+		if len(problems) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Picks not confirmed",
+				"lines": problems,
+			})
+			return
+		}
+		if err := recordPicks(tx, int(requestID.Int64), actorID(r)); err != nil {
+			http.Error(w, "Failed to record picks", http.StatusInternalServerError)
+			return
+		}
+
+		// Finishing the prepare task means the goods were picked, packed
+		// and handed over, so walk the request through each of those steps.
+		if err := advanceRequest(tx, int(requestID.Int64), "shipped", actorID(r)); err != nil {
+			writeTransitionError(w, err)
+			return
+		}
+
+		// Top up any pick face the picks drained below its minimum.
+		picked, err := requestLocations(tx, int(requestID.Int64))
+		if err == nil {
+			_, err = replenish(tx, picked)
+		}
+		if err != nil {
+			log.Printf("Replenishment error: %v", err)
+			http.Error(w, "Failed to create replenishment tasks", http.StatusInternalServerError)
 			return
 		}
 	}
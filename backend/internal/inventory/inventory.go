@@ -0,0 +1,212 @@
+package inventory
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+
+	"backend/internal/db"
+)
+
+// Reason says why a stock movement happened.
+type Reason string
+
+const (
+	Receipt     Reason = "receipt"
+	Reservation Reason = "reservation"
+	Release     Reason = "release"
+	Pick        Reason = "pick"
+	Disposal    Reason = "disposal"
+	Adjustment  Reason = "adjustment"
+	Transfer    Reason = "transfer"
+)
+
+// ErrNoBatch is returned when a movement names a batch that doesn't exist.
+var ErrNoBatch = errors.New("stock batch not found")
+
+// Doc identifies the document a movement originates from, e.g. the purchase
+// request a reservation is for or the task that disposed of stock.
+type Doc struct {
+	Type string
+	ID   int
+}
+
+// Receive creates a new stock batch in a location (0 for none) and books
+// its opening quantity. lotNumber is the supplier's lot, empty when unknown.
+func Receive(tx *sql.Tx, productID int, qty float64, expiration interface{}, lotNumber string, locationID int, doc Doc, actorID int) (int, error) {
+	var location, lot interface{}
+	if locationID != 0 {
+		location = locationID
+	}
+	if lotNumber != "" {
+		lot = lotNumber
+	}
+	res, err := tx.Exec(
+		`INSERT INTO stock (productID, quantity, expirationDate, locationID, lotNumber) VALUES (?, ?, ?, ?, ?)`,
+		productID, qty, expiration, location, lot,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	batchID := int(id)
+	return batchID, record(tx, batchID, productID, qty, qty, Receipt, doc, actorID)
+}
+
+// Reserve takes qty out of a batch for an order.
+func Reserve(tx *sql.Tx, batchID int, qty float64, doc Doc, actorID int) error {
+	return apply(tx, batchID, -qty, Reservation, doc, actorID)
+}
+
+// ReleaseReservation puts a reserved qty back into a batch.
+func ReleaseReservation(tx *sql.Tx, batchID int, qty float64, doc Doc, actorID int) error {
+	return apply(tx, batchID, qty, Release, doc, actorID)
+}
+
+// Dispose writes qty off a batch.
+func Dispose(tx *sql.Tx, batchID int, qty float64, doc Doc, actorID int) error {
+	return apply(tx, batchID, -qty, Disposal, doc, actorID)
+}
+
+// Adjust corrects a batch by a signed delta, e.g. after a count.
+func Adjust(tx *sql.Tx, batchID int, delta float64, doc Doc, actorID int) error {
+	return apply(tx, batchID, delta, Adjustment, doc, actorID)
+}
+
+// RecordPick notes that reserved qty physically left the batch. The stock
+// quantity already dropped when it was reserved, so the delta is zero.
+func RecordPick(tx *sql.Tx, batchID int, qty float64, doc Doc, actorID int) error {
+	productID, err := lockBatch(tx, batchID)
+	if err != nil {
+		return err
+	}
+	return record(tx, batchID, productID, 0, qty, Pick, doc, actorID)
+}
+
+// Move relocates a whole batch. Its quantity doesn't change, so the delta is
+// zero and the movement only notes how much was carried.
+func Move(tx *sql.Tx, batchID, locationID int, doc Doc, actorID int) error {
+	productID, err := lockBatch(tx, batchID)
+	if err != nil {
+		return err
+	}
+	var qty float64
+	if err := tx.QueryRow(`SELECT quantity FROM stock WHERE batchID = ?`, batchID).Scan(&qty); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE stock SET locationID = ? WHERE batchID = ?`, locationID, batchID); err != nil {
+		return err
+	}
+	return record(tx, batchID, productID, 0, qty, Transfer, doc, actorID)
+}
+
+// Split moves qty of a batch into a new batch at locationID that keeps the
+// product, expiration date, lot, markdown and recall block of its parent,
+// and returns the new batch ID.
+func Split(tx *sql.Tx, batchID int, qty float64, locationID int, doc Doc, actorID int) (int, error) {
+	if err := apply(tx, batchID, -qty, Transfer, doc, actorID); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`
+		INSERT INTO stock (productID, quantity, expirationDate, locationID, markdownPrice, lotNumber, parentBatchID, blocked)
+		SELECT productID, ?, expirationDate, ?, markdownPrice, lotNumber, batchID, blocked FROM stock WHERE batchID = ?
+	`, qty, locationID, batchID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	newID := int(id)
+	productID, err := lockBatch(tx, newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, record(tx, newID, productID, qty, qty, Transfer, doc, actorID)
+}
+
+func apply(tx *sql.Tx, batchID int, delta float64, reason Reason, doc Doc, actorID int) error {
+	productID, err := lockBatch(tx, batchID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE stock SET quantity = quantity + ? WHERE batchID = ?`, delta, batchID); err != nil {
+		return err
+	}
+	return record(tx, batchID, productID, delta, math.Abs(delta), reason, doc, actorID)
+}
+
+func lockBatch(tx *sql.Tx, batchID int) (int, error) {
+	var productID int
+	err := tx.QueryRow(`SELECT productID FROM stock WHERE batchID = ? FOR UPDATE`, batchID).Scan(&productID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoBatch
+	}
+	return productID, err
+}
+
+func record(tx *sql.Tx, batchID, productID int, delta, qty float64, reason Reason, doc Doc, actorID int) error {
+	var docID, actor interface{}
+	if doc.ID != 0 {
+		docID = doc.ID
+	}
+	if actorID != 0 {
+		actor = actorID
+	}
+	_, err := tx.Exec(`
+		INSERT INTO inventory_movements (batchID, productID, delta, quantity, reason, docType, docID, actorID)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, batchID, productID, delta, qty, string(reason), doc.Type, docID, actor)
+	return err
+}
+
+// Balance compares a batch's stock quantity with the sum of its ledger.
+type Balance struct {
+	BatchID   int     `json:"batchID"`
+	ProductID int     `json:"productID"`
+	Stock     float64 `json:"stockQuantity"`
+	Ledger    float64 `json:"ledgerQuantity"`
+	Diff      float64 `json:"difference"`
+}
+
+// Reconcile replays the ledger per batch and returns every batch whose
+// stock.quantity disagrees with it, including ledger entries for batches that
+// no longer exist in stock.
+func Reconcile() ([]Balance, int, error) {
+	rows, err := db.DB.Query(`
+		SELECT s.batchID, s.productID, s.quantity, COALESCE(SUM(m.delta), 0)
+		FROM stock s
+		LEFT JOIN inventory_movements m ON m.batchID = s.batchID
+		GROUP BY s.batchID, s.productID, s.quantity
+		UNION ALL
+		SELECT m.batchID, MIN(m.productID), 0, SUM(m.delta)
+		FROM inventory_movements m
+		LEFT JOIN stock s ON s.batchID = m.batchID
+		WHERE s.batchID IS NULL
+		GROUP BY m.batchID
+		ORDER BY 1
+	`)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var out []Balance
+	checked := 0
+	for rows.Next() {
+		var b Balance
+		if err := rows.Scan(&b.BatchID, &b.ProductID, &b.Stock, &b.Ledger); err != nil {
+			return nil, 0, err
+		}
+		checked++
+		b.Diff = b.Stock - b.Ledger
+		if math.Abs(b.Diff) > 1e-3 { // FLOAT columns lose precision past that
+			out = append(out, b)
+		}
+	}
+	return out, checked, rows.Err()
+}
@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LogMailer is the local development transport. It writes each message to
+// Dir as an .eml file, or to the server log when Dir is empty.
+type LogMailer struct {
+	Dir string
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	if m.Dir == "" {
+		log.Printf("MAIL to=%s subject=%q attachments=%d\n%s", msg.To, msg.Subject, len(msg.Attachments), msg.Text)
+		return nil
+	}
+
+	body, err := buildMIME("noreply@localhost", msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(m.Dir, name), body, 0644)
+}
@@ -0,0 +1,182 @@
+package mail
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	defer func(b, a string) { baseURL, appURL = b, a }(baseURL, appURL)
+	baseURL, appURL = "https://api.example.com", "https://shop.example.com"
+
+	tests := []struct {
+		name    string
+		data    map[string]interface{}
+		subject string
+		links   []string // in both the text and the HTML part
+	}{
+		{
+			name:    "verification",
+			data:    map[string]interface{}{"Token": "abc", "Expires": "24h"},
+			subject: "Verify your account",
+			links:   []string{"https://api.example.com/api/verify?token=abc"},
+		},
+		{
+			name:    "password_reset",
+			data:    map[string]interface{}{"Token": "r1", "Expires": "1h"},
+			subject: "Reset your password",
+			links:   []string{"https://shop.example.com/reset-password?token=r1"},
+		},
+		{
+			name:    "shipment",
+			data:    map[string]interface{}{"RequestID": 42, "Invoice": "INV-7", "Total": "12.50"},
+			subject: "Your order #42 has shipped",
+			links:   []string{"https://shop.example.com/order-history"},
+		},
+		{
+			name: "expiry_alert",
+			data: map[string]interface{}{"Alerts": []map[string]interface{}{
+				{"ProductName": "Milk", "BatchID": 7, "BinCode": "A-01", "Quantity": 3.5,
+					"Kind": "near_expiry", "ExpirationDate": "2026-10-20", "MarkdownPrice": "0.99"},
+			}},
+			subject: "Expiry alert: 1 batch(es) need attention",
+			links:   []string{"https://shop.example.com/admin/expiry-alerts"},
+		},
+		{
+			name: "recall_notice",
+			data: map[string]interface{}{"ProductName": "Milk", "LotNumber": "L1", "Reason": "Contamination",
+				"Requests": []int{3, 4}},
+			subject: "Product recall: Milk",
+		},
+	}
+	for _, tt := range tests {
+		msg, err := Render(tt.name, "a@example.com", tt.data)
+		if err != nil {
+			t.Errorf("Render(%s): %v", tt.name, err)
+			continue
+		}
+		if msg.To != "a@example.com" || msg.Subject != tt.subject {
+			t.Errorf("Render(%s) to %q with subject %q, want subject %q", tt.name, msg.To, msg.Subject, tt.subject)
+		}
+		if msg.Text == "" || msg.HTML == "" {
+			t.Errorf("Render(%s) is missing a text or HTML part", tt.name)
+		}
+		if strings.Contains(msg.Text, "subject") || !strings.HasSuffix(msg.Text, "\n") {
+			t.Errorf("Render(%s) text = %q", tt.name, msg.Text)
+		}
+		for _, link := range tt.links {
+			if !strings.Contains(msg.Text, link) || !strings.Contains(msg.HTML, link) {
+				t.Errorf("Render(%s) doesn't link %s", tt.name, link)
+			}
+		}
+	}
+
+	if _, err := Render("no_such_template", "a@example.com", nil); err == nil {
+		t.Error("Render of a missing template succeeded")
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	msg, err := Render("recall_notice", "a@example.com", map[string]interface{}{
+		"ProductName": "<b>Milk</b>", "Reason": "x", "Requests": []int{1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(msg.HTML, "<b>Milk</b>") || !strings.Contains(msg.Text, "<b>Milk</b>") {
+		t.Error("product name should be escaped in HTML only")
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	defer func(m Mailer) { transport = m }(transport)
+	mem := &MemoryMailer{}
+	SetMailer(mem)
+
+	msg, err := Render("shipment", "c@example.com", map[string]interface{}{"RequestID": 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Attachments = []Attachment{{Filename: "packing-slip-9.pdf", ContentType: "application/pdf", Data: []byte("%PDF")}}
+	if err := transport.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := mem.Sent()
+	if len(sent) != 1 || sent[0].To != "c@example.com" || len(sent[0].Attachments) != 1 {
+		t.Fatalf("sent = %+v", sent)
+	}
+	sent[0].To = "changed"
+	if mem.Sent()[0].To != "c@example.com" {
+		t.Error("Sent returned the mailer's own slice")
+	}
+	mem.Reset()
+	if len(mem.Sent()) != 0 {
+		t.Error("Reset kept messages")
+	}
+}
+
+// execRecorder is an Execer that records statements instead of running them.
+type execRecorder struct {
+	queries []string
+	args    [][]interface{}
+}
+
+func (e *execRecorder) Exec(query string, args ...interface{}) (sql.Result, error) {
+	e.queries = append(e.queries, query)
+	e.args = append(e.args, args)
+	return fakeResult(len(e.queries)), nil
+}
+
+type fakeResult int64
+
+func (r fakeResult) LastInsertId() (int64, error) { return int64(r), nil }
+func (r fakeResult) RowsAffected() (int64, error) { return 1, nil }
+
+func TestEnqueue(t *testing.T) {
+	ex := &execRecorder{}
+	err := Enqueue(ex, "shipment", "c@example.com", map[string]interface{}{"RequestID": 5},
+		Attachment{Filename: "packing-slip-5.pdf", ContentType: "application/pdf", Data: []byte("a")},
+		Attachment{Filename: "invoice-5.pdf", ContentType: "application/pdf", Data: []byte("b")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.queries) != 3 {
+		t.Fatalf("%d statements, want the message and two attachments", len(ex.queries))
+	}
+	if !strings.Contains(ex.queries[0], "INSERT INTO mail_outbox ") || ex.args[0][0] != "c@example.com" || ex.args[0][4] != "shipment" {
+		t.Errorf("outbox insert = %q %v", ex.queries[0], ex.args[0])
+	}
+	for i, name := range []string{"packing-slip-5.pdf", "invoice-5.pdf"} {
+		args := ex.args[i+1]
+		if !strings.Contains(ex.queries[i+1], "mail_outbox_attachments") || args[0] != int64(1) || args[1] != name {
+			t.Errorf("attachment insert %d = %v", i, args)
+		}
+	}
+
+	if err := Enqueue(&execRecorder{}, "no_such_template", "c@example.com", nil); err == nil {
+		t.Error("Enqueue of a missing template succeeded")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{8, 64 * time.Minute},
+		{12, maxBackoff},
+		{50, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
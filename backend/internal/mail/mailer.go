@@ -0,0 +1,77 @@
+package mail
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// Message is a fully rendered email ready to hand to a transport.
+type Message struct {
+	To          string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with a message.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mailer delivers a single message. Implementations must be safe for use by
+// the outbox worker goroutine.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var (
+	transport Mailer = &LogMailer{}
+	baseURL          = "http://localhost:8080"
+	appURL           = "http://localhost:3000"
+)
+
+// Init picks the transport from MAIL_TRANSPORT (smtp, log or memory), the
+// public backend URL used in API links from PUBLIC_BASE_URL and the frontend
+// URL used in links to pages from APP_BASE_URL.
+func Init() {
+	if v := os.Getenv("PUBLIC_BASE_URL"); v != "" {
+		baseURL = strings.TrimRight(v, "/")
+	}
+	if v := os.Getenv("APP_BASE_URL"); v != "" {
+		appURL = strings.TrimRight(v, "/")
+	}
+
+	switch os.Getenv("MAIL_TRANSPORT") {
+	case "smtp":
+		transport = &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			From:     os.Getenv("EMAIL_FROM"),
+			Password: os.Getenv("EMAIL_PASSWORD"),
+		}
+	case "memory":
+		transport = &MemoryMailer{}
+	default:
+		transport = &LogMailer{Dir: os.Getenv("MAIL_LOG_DIR")}
+	}
+	log.Printf("Mail transport: %T, base URL %s, app URL %s", transport, baseURL, appURL)
+}
+
+// SetMailer replaces the active transport, e.g. with a MemoryMailer.
+func SetMailer(m Mailer) {
+	transport = m
+}
+
+// BaseURL is the public backend URL API links in emails are built from.
+func BaseURL() string {
+	return baseURL
+}
+
+// AppURL is the frontend URL links to pages in emails are built from.
+func AppURL() string {
+	return appURL
+}
@@ -0,0 +1,161 @@
+package mail
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"backend/internal/db"
+)
+
+const (
+	maxAttempts  = 8
+	pollInterval = 5 * time.Second
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = 6 * time.Hour
+)
+
+// Execer is satisfied by both *sql.DB and *sql.Tx, so callers can queue a
+// message inside the transaction that creates the data it refers to.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Enqueue renders the named template and stores it in the outbox together
+// with any attachments. Nothing is sent here; the outbox worker delivers it
+// after the caller commits.
+func Enqueue(ex Execer, name, to string, data map[string]interface{}, attachments ...Attachment) error {
+	msg, err := Render(name, to, data)
+	if err != nil {
+		return err
+	}
+	res, err := ex.Exec(
+		`INSERT INTO mail_outbox (recipient, subject, body_text, body_html, template)
+		 VALUES (?, ?, ?, ?, ?)`,
+		msg.To, msg.Subject, msg.Text, msg.HTML, name,
+	)
+	if err != nil || len(attachments) == 0 {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		if _, err := ex.Exec(
+			`INSERT INTO mail_outbox_attachments (outboxID, filename, contentType, data) VALUES (?, ?, ?, ?)`,
+			id, a.Filename, a.ContentType, a.Data,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadAttachments(tx *sql.Tx, outboxID int64) ([]Attachment, error) {
+	rows, err := tx.Query(
+		`SELECT filename, contentType, data FROM mail_outbox_attachments WHERE outboxID = ? ORDER BY id`,
+		outboxID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Attachment
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.Filename, &a.ContentType, &a.Data); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// StartOutbox launches the background worker that drains the outbox.
+func StartOutbox() {
+	go func() {
+		for {
+			for deliverNext() {
+			}
+			time.Sleep(pollInterval)
+		}
+	}()
+}
+
+// deliverNext sends the oldest due message and reports whether there was one.
+func deliverNext() bool {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Printf("Outbox: begin failed: %v", err)
+		return false
+	}
+	defer tx.Rollback()
+
+	var (
+		id       int64
+		attempts int
+		msg      Message
+	)
+	err = tx.QueryRow(`
+		SELECT id, recipient, subject, body_text, COALESCE(body_html, ''), attempts
+		FROM mail_outbox
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`).Scan(&id, &msg.To, &msg.Subject, &msg.Text, &msg.HTML, &attempts)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		log.Printf("Outbox: query failed: %v", err)
+		return false
+	}
+	if msg.Attachments, err = loadAttachments(tx, id); err != nil {
+		log.Printf("Outbox: attachments of message %d failed: %v", id, err)
+		return false
+	}
+
+	if sendErr := transport.Send(msg); sendErr != nil {
+		attempts++
+		status := "pending"
+		if attempts >= maxAttempts {
+			status = "failed"
+		}
+		log.Printf("Outbox: message %d to %s failed (attempt %d): %v", id, msg.To, attempts, sendErr)
+		_, err = tx.Exec(`
+			UPDATE mail_outbox
+			   SET attempts = ?, status = ?, last_error = ?, next_attempt_at = ?
+			 WHERE id = ?
+		`, attempts, status, sendErr.Error(), time.Now().Add(backoff(attempts)), id)
+	} else {
+		_, err = tx.Exec(`
+			UPDATE mail_outbox
+			   SET attempts = attempts + 1, status = 'sent', sent_at = NOW(), last_error = NULL
+			 WHERE id = ?
+		`, id)
+	}
+	if err != nil {
+		log.Printf("Outbox: update of message %d failed: %v", id, err)
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Outbox: commit failed: %v", err)
+		return false
+	}
+	return true
+}
+
+// backoff doubles the delay with every failed attempt, capped at maxBackoff.
+func backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
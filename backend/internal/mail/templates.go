@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+// Render builds a message from the named template pair templates/<name>.txt
+// and templates/<name>.html. The text template must define "subject".
+// BaseURL (the backend, for API links) and AppURL (the frontend, for links
+// to pages) are added to data automatically.
+func Render(name, to string, data map[string]interface{}) (Message, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["BaseURL"] = baseURL
+	data["AppURL"] = appURL
+
+	txt, err := texttemplate.ParseFS(templateFS, "templates/"+name+".txt")
+	if err != nil {
+		return Message{}, err
+	}
+	var subject, text bytes.Buffer
+	if err := txt.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := txt.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}
+
+	if html, err := htmltemplate.ParseFS(templateFS, "templates/"+name+".html"); err == nil {
+		var out bytes.Buffer
+		if err := html.Execute(&out, data); err != nil {
+			return Message{}, err
+		}
+		msg.HTML = out.String()
+	}
+	return msg, nil
+}
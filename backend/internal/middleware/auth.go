@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"backend/internal/session"
+)
+
+// SessionCookie is the cookie LoginHandler stores the session token in.
+const SessionCookie = "session"
+
+type ctxKey struct{}
+
+// WithAuth rejects callers without a valid session with 401 and callers
+// whose role is not listed with 403. With no roles, any signed-in user passes.
+func WithAuth(handler http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := TokenFromRequest(r)
+		if token == "" {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := session.Lookup(token)
+		if err == session.ErrInvalid {
+			http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		if len(roles) > 0 && !hasRole(id.Role, roles) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, id)))
+	}
+}
+
+// CurrentUser returns the identity WithAuth attached to the request.
+func CurrentUser(r *http.Request) (session.Identity, bool) {
+	id, ok := r.Context().Value(ctxKey{}).(session.Identity)
+	return id, ok
+}
+
+// TokenFromRequest reads the session token from the Authorization header,
+// falling back to the session cookie used by the browser frontend.
+func TokenFromRequest(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func hasRole(role string, allowed []string) bool {
+	for _, a := range allowed {
+		if a == role {
+			return true
+		}
+	}
+	return false
+}
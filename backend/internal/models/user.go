@@ -8,3 +8,10 @@ type Credentials struct {
 type Response struct {
 	Message string `json:"message"`
 }
+
+const (
+	RoleCustomer = "customer"
+	RoleWorker   = "worker"
+	RoleAdmin    = "admin"
+	RoleDemo     = "demo"
+)
@@ -0,0 +1,167 @@
+// Package money does exact arithmetic on prices. Amounts are whole cents,
+// so sums and products never pick up float rounding; they are read from and
+// written to DECIMAL(10,2) columns as text.
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount is a sum of money in cents.
+type Amount int64
+
+// Rate is a percentage in hundredths of a percent, e.g. 8.25% is 825.
+type Rate int64
+
+var errSyntax = errors.New("money: invalid amount")
+
+// parseFixed reads a decimal with at most two fractional digits as an
+// integer count of hundredths.
+func parseFixed(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || len(frac) > 2 {
+		return 0, errSyntax
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	w, err := strconv.ParseUint(whole, 10, 62)
+	if err != nil {
+		return 0, errSyntax
+	}
+	f, err := strconv.ParseUint(frac, 10, 8)
+	if err != nil {
+		return 0, errSyntax
+	}
+	v := int64(w*100 + f)
+	if neg {
+		v = -v
+	}
+	return v, nil
+}
+
+// Parse reads an amount such as "12", "12.5" or "12.50".
+func Parse(s string) (Amount, error) {
+	v, err := parseFixed(s)
+	return Amount(v), err
+}
+
+// ParseRate reads a percentage such as "21" or "8.25".
+func ParseRate(s string) (Rate, error) {
+	v, err := parseFixed(s)
+	return Rate(v), err
+}
+
+// Times multiplies the amount by a whole quantity.
+func (a Amount) Times(qty int) Amount {
+	return a * Amount(qty)
+}
+
+// TimesQty multiplies the amount by a quantity that may be fractional, such
+// as a weight, rounded half away from zero to the cent.
+func (a Amount) TimesQty(qty float64) Amount {
+	return Amount(math.Round(float64(a) * qty))
+}
+
+// Of returns the rate applied to a, rounded half away from zero to the cent.
+func (r Rate) Of(a Amount) Amount {
+	v := int64(a) * int64(r)
+	if v < 0 {
+		return -Amount((-v + 5000) / 10000)
+	}
+	return Amount((v + 5000) / 10000)
+}
+
+// String formats the amount with two decimals, e.g. "12.50".
+func (a Amount) String() string {
+	return fixed(int64(a))
+}
+
+// String formats the rate as a percentage without the sign, e.g. "8.25".
+func (r Rate) String() string {
+	return fixed(int64(r))
+}
+
+func fixed(v int64) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// MarshalJSON writes the amount as a JSON number with two decimals.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// MarshalJSON writes the rate as a JSON number.
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Value stores the amount as decimal text.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Value stores the rate as decimal text.
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+// Scan reads a DECIMAL column. NULL is an error; scan nullable columns
+// into a NullAmount.
+func (a *Amount) Scan(src interface{}) error {
+	v, err := scanFixed(src)
+	*a = Amount(v)
+	return err
+}
+
+// Scan reads a DECIMAL column holding a percentage.
+func (r *Rate) Scan(src interface{}) error {
+	v, err := scanFixed(src)
+	*r = Rate(v)
+	return err
+}
+
+func scanFixed(src interface{}) (int64, error) {
+	switch v := src.(type) {
+	case []byte:
+		return parseFixed(string(v))
+	case string:
+		return parseFixed(v)
+	case int64:
+		return v * 100, nil
+	case float64:
+		return int64(math.Round(v * 100)), nil
+	}
+	return 0, fmt.Errorf("money: can't scan %T", src)
+}
+
+// NullAmount is an Amount that may be NULL.
+type NullAmount struct {
+	Amount Amount
+	Valid  bool
+}
+
+// Scan reads a nullable DECIMAL column.
+func (n *NullAmount) Scan(src interface{}) error {
+	if src == nil {
+		n.Amount, n.Valid = 0, false
+		return nil
+	}
+	n.Valid = true
+	return n.Amount.Scan(src)
+}
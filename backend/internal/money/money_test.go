@@ -0,0 +1,191 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+		ok   bool
+	}{
+		{"12", 1200, true},
+		{"12.5", 1250, true},
+		{"12.50", 1250, true},
+		{"0.05", 5, true},
+		{".5", 50, true},
+		{"5.", 500, true},
+		{" 7.25 ", 725, true},
+		{"+3", 300, true},
+		{"-3.10", -310, true},
+		{"99999999.99", 9999999999, true},
+		{"", 0, false},
+		{".", 0, false},
+		{"1.234", 0, false}, // more than cents
+		{"1,50", 0, false},
+		{"1e3", 0, false},
+		{"--1", 0, false},
+		{"1.-5", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err == nil) != tt.ok || tt.ok && got != tt.want {
+			t.Errorf("Parse(%q) = %d, %v; want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rate
+	}{
+		{"21", 2100},
+		{"8.25", 825},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		if got, err := ParseRate(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestTimes(t *testing.T) {
+	tests := []struct {
+		a    Amount
+		qty  int
+		want Amount
+	}{
+		{199, 3, 597},
+		{199, 0, 0},
+		{-250, 2, -500},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Times(tt.qty); got != tt.want {
+			t.Errorf("%d.Times(%d) = %d, want %d", tt.a, tt.qty, got, tt.want)
+		}
+	}
+}
+
+func TestTimesQty(t *testing.T) {
+	tests := []struct {
+		a    Amount
+		qty  float64
+		want Amount
+	}{
+		{1000, 2, 2000},
+		{1000, 0.25, 250},
+		{333, 1.5, 500},    // 499.5 rounds up
+		{-333, 1.5, -500},  // and away from zero
+		{1999, 0.333, 666}, // 665.667
+		{1999, 0.332, 664}, // 663.668
+		{105, 0.5, 53},     // 52.5
+		{1, 0.004, 0},
+		{450, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.a.TimesQty(tt.qty); got != tt.want {
+			t.Errorf("%d.TimesQty(%v) = %d, want %d", tt.a, tt.qty, got, tt.want)
+		}
+	}
+}
+
+func TestRateOf(t *testing.T) {
+	tests := []struct {
+		r    Rate
+		a    Amount
+		want Amount
+	}{
+		{2100, 1000, 210},
+		{825, 1000, 83},   // 82.5 rounds up
+		{825, -1000, -83}, // and away from zero
+		{2100, 999, 210},  // 209.79
+		{825, 1, 0},       // 0.0825
+		{0, 12345, 0},
+		{10000, 12345, 12345},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Of(tt.a); got != tt.want {
+			t.Errorf("Rate(%d).Of(%d) = %d, want %d", tt.r, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		a    Amount
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{1250, "12.50"},
+		{-5, "-0.05"},
+		{-123456, "-1234.56"},
+	}
+	for _, tt := range tests {
+		if got := tt.a.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", tt.a, got, tt.want)
+		}
+	}
+	if got := Rate(825).String(); got != "8.25" {
+		t.Errorf("Rate(825).String() = %q", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	out, err := json.Marshal(map[string]interface{}{"total": Amount(1250), "taxRate": Rate(2100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"taxRate":21.00,"total":12.50}`; string(out) != want {
+		t.Errorf("json = %s, want %s", out, want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want Amount
+		ok   bool
+	}{
+		{[]byte("12.34"), 1234, true},
+		{"0.10", 10, true},
+		{int64(5), 500, true},
+		{float64(19.99), 1999, true},
+		{nil, 0, false},
+		{true, 0, false},
+	}
+	for _, tt := range tests {
+		var a Amount
+		err := a.Scan(tt.src)
+		if (err == nil) != tt.ok || tt.ok && a != tt.want {
+			t.Errorf("Scan(%#v) = %d, %v; want %d, ok %v", tt.src, a, err, tt.want, tt.ok)
+		}
+	}
+
+	var r Rate
+	if err := r.Scan([]byte("8.25")); err != nil || r != 825 {
+		t.Errorf("Rate.Scan = %d, %v", r, err)
+	}
+
+	n := NullAmount{Amount: 99, Valid: true}
+	if err := n.Scan(nil); err != nil || n.Valid || n.Amount != 0 {
+		t.Errorf("NullAmount.Scan(nil) = %+v, %v", n, err)
+	}
+	if err := n.Scan([]byte("3.50")); err != nil || !n.Valid || n.Amount != 350 {
+		t.Errorf("NullAmount.Scan(3.50) = %+v, %v", n, err)
+	}
+}
+
+func TestValue(t *testing.T) {
+	if v, err := Amount(-1250).Value(); err != nil || v != "-12.50" {
+		t.Errorf("Amount.Value() = %v, %v", v, err)
+	}
+	if v, err := Rate(825).Value(); err != nil || v != "8.25" {
+		t.Errorf("Rate.Value() = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,137 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Invoice 42", "Invoice 42"},
+		{"(draft)", `\(draft\)`},
+		{`C:\tmp`, `C:\\tmp`},
+		{"€ 5", `\200 5`},
+		{"Café", `Caf\351`},
+		{"½ kg", `\275 kg`},
+		{"日本", "??"},
+		{"tab\t", "tab?"},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNum(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0"},
+		{12, "12"},
+		{12.5, "12.5"},
+		{595.28, "595.28"},
+		{1.005, "1"},
+		{-3.25, "-3.25"},
+		{MM(25.4), "72"},
+	}
+	for _, tt := range tests {
+		if got := num(tt.in); got != tt.want {
+			t.Errorf("num(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	tests := []struct {
+		font Font
+		size float64
+		s    string
+		want float64
+	}{
+		{Regular, 10, "", 0},
+		{Regular, 10, "Hello", 22.78}, // 722+556+222+222+556
+		{Bold, 10, "Hello", 24.45},    // 722+556+278+278+611
+		{Regular, 12, "0", 6.672},
+		{Regular, 10, "é", 5.56}, // outside ASCII counts as an average glyph
+	}
+	for _, tt := range tests {
+		if got := TextWidth(tt.font, tt.size, tt.s); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("TextWidth(%d, %v, %q) = %v, want %v", tt.font, tt.size, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestTextRight(t *testing.T) {
+	d := New()
+	p := d.AddPage(A4Width, A4Height)
+	p.TextRight(100, 50, Regular, 10, "Hello")
+	if want := "BT /F1 10 Tf 77.22 50 Td (Hello) Tj ET\n"; p.content.String() != want {
+		t.Errorf("content = %q, want %q", p.content.String(), want)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	d := New()
+	p := d.AddPage(A4Width, A4Height)
+	p.Text(MM(20), MM(270), Bold, 14, "Invoice (copy)")
+	p.Line(0, 10, 100, 10, 0.5)
+	p.Rect(10, 20, 30, 40)
+	d.AddPage(MM(100), MM(50))
+	out := d.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("missing header or trailer")
+	}
+
+	// Every xref entry points at the start of its object.
+	m := regexp.MustCompile(`(?s)\nxref\n0 (\d+)\n0000000000 65535 f \n(.*?)trailer\n<< /Size (\d+) /Root 1 0 R >>\nstartxref\n(\d+)\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no cross-reference table")
+	}
+	count, _ := strconv.Atoi(string(m[1]))
+	if count != 9 || string(m[3]) != "9" {
+		t.Fatalf("xref has %s entries and trailer size %s, want 9 (4 fixed objects and 2 per page)", m[1], m[3])
+	}
+	startxref, _ := strconv.Atoi(string(m[4]))
+	if !bytes.HasPrefix(out[startxref:], []byte("xref\n")) {
+		t.Errorf("startxref %d doesn't point at the xref table", startxref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(m[2], -1)
+	if len(entries) != count-1 {
+		t.Fatalf("%d xref entries, want %d", len(entries), count-1)
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(out[off:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q", i+1, out[off:off+10])
+		}
+	}
+
+	if !bytes.Contains(out, []byte("/Kids [5 0 R 7 0 R] /Count 2")) {
+		t.Error("page tree doesn't list both pages")
+	}
+	if !bytes.Contains(out, []byte("/MediaBox [0 0 283.46 141.73]")) {
+		t.Error("second page has the wrong size")
+	}
+
+	// Stream lengths match their content.
+	streams := regexp.MustCompile(`(?s)<< /Length (\d+) >>\nstream\n(.*?)endstream`).FindAllSubmatch(out, -1)
+	if len(streams) != 2 {
+		t.Fatalf("%d content streams, want 2", len(streams))
+	}
+	for _, s := range streams {
+		if n, _ := strconv.Atoi(string(s[1])); n != len(s[2]) {
+			t.Errorf("stream /Length %d, content is %d bytes", n, len(s[2]))
+		}
+	}
+	want := "BT /F2 14 Tf 56.69 765.35 Td (Invoice \\(copy\\)) Tj ET\n0.5 w 0 10 m 100 10 l S\n10 20 30 40 re f\n"
+	if string(streams[0][2]) != want {
+		t.Errorf("first page content = %q, want %q", streams[0][2], want)
+	}
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/internal/db"
+)
+
+// ErrInvalid is returned by Lookup when the token is unknown or expired.
+var ErrInvalid = errors.New("invalid or expired session")
+
+// Identity is the authenticated caller attached to a request.
+type Identity struct {
+	UserID int
+	Email  string
+	Role   string
+}
+
+// Create issues a new opaque session token for the user. Only a SHA-256
+// hash of the token is stored, so a leaked sessions table can't be replayed.
+func Create(userID int) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(raw)
+	expires := time.Now().Add(ttl())
+
+	_, err := db.DB.Exec(
+		`INSERT INTO sessions (tokenHash, userID, expires_at) VALUES (?, ?, ?)`,
+		HashToken(token), userID, expires,
+	)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// Lookup resolves a token to the user that owns it.
+func Lookup(token string) (Identity, error) {
+	var id Identity
+	err := db.DB.QueryRow(`
+		SELECT u.id, u.email, u.role
+		FROM sessions s
+		JOIN users u ON u.id = s.userID
+		WHERE s.tokenHash = ? AND s.expires_at > NOW()
+	`, HashToken(token)).Scan(&id.UserID, &id.Email, &id.Role)
+	if err == sql.ErrNoRows {
+		return id, ErrInvalid
+	}
+	return id, err
+}
+
+// Delete revokes a single session token.
+func Delete(token string) error {
+	_, err := db.DB.Exec(`DELETE FROM sessions WHERE tokenHash = ?`, HashToken(token))
+	return err
+}
+
+// DeleteForUser revokes every session of a user.
+func DeleteForUser(userID int) error {
+	_, err := db.DB.Exec(`DELETE FROM sessions WHERE userID = ?`, userID)
+	return err
+}
+
+// HashToken is the SHA-256 hex digest under which single-use tokens are
+// stored, so a leaked table can't be replayed.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func ttl() time.Duration {
+	if h, err := strconv.Atoi(os.Getenv("SESSION_TTL_HOURS")); err == nil && h > 0 {
+		return time.Duration(h) * time.Hour
+	}
+	return 24 * time.Hour
+}
@@ -0,0 +1,223 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestColumn(t *testing.T) {
+	tests := []struct {
+		i    int
+		want string
+	}{
+		{0, "A"},
+		{1, "B"},
+		{25, "Z"},
+		{26, "AA"},
+		{27, "AB"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+	}
+	for _, tt := range tests {
+		if got := column(tt.i); got != tt.want {
+			t.Errorf("column(%d) = %q, want %q", tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain", "plain"},
+		{`a < b & "c"`, "a &lt; b &amp; &#34;c&#34;"},
+		{"bell\x07", "bell"},
+		{"line\nbreak", "line&#xA;break"},
+	}
+	for _, tt := range tests {
+		if got := text(tt.in); got != tt.want {
+			t.Errorf("text(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Stock value", "Stock value"},
+		{"Q1/Q2 [draft]?", "Q1-Q2 -draft--"},
+		{strings.Repeat("é", 40), strings.Repeat("é", 31)},
+	}
+	for _, tt := range tests {
+		if got := New(tt.in).name; got != tt.want {
+			t.Errorf("New(%q) sheet name %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+type worksheet struct {
+	Panes []struct {
+		YSplit string `xml:"ySplit,attr"`
+		State  string `xml:"state,attr"`
+	} `xml:"sheetViews>sheetView>pane"`
+	Cols []struct {
+		Min   int    `xml:"min,attr"`
+		Width string `xml:"width,attr"`
+	} `xml:"cols>col"`
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R      string `xml:"r,attr"`
+			S      int    `xml:"s,attr"`
+			T      string `xml:"t,attr"`
+			V      string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readParts(t *testing.T, s *Sheet) map[string][]byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string][]byte)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = body
+	}
+	return parts
+}
+
+func TestWrite(t *testing.T) {
+	s := New("Stock & value")
+	s.SetWidths(28, 14.5)
+	s.AddRow(Text("Product").Styled(Bold), Text("Cost").Styled(Bold))
+	s.AddRow(Text("Milk <1L>"), Number(12.5).Styled(Decimal))
+	s.AddRow(Text("Bread"), Empty(), Number(3))
+	s.AddRow(Text("Total").Styled(Bold), Number(1234.56).Styled(BoldDecimal))
+
+	parts := readParts(t, s)
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml",
+		"xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		body, ok := parts[name]
+		if !ok {
+			t.Errorf("missing part %s", name)
+			continue
+		}
+		// Every part is well-formed XML.
+		d := xml.NewDecoder(bytes.NewReader(body))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("part %s: %v", name, err)
+				break
+			}
+		}
+	}
+
+	var wb struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(parts["xl/workbook.xml"], &wb); err != nil {
+		t.Fatal(err)
+	}
+	if len(wb.Sheets) != 1 || wb.Sheets[0].Name != "Stock & value" {
+		t.Errorf("workbook sheets = %+v", wb.Sheets)
+	}
+
+	var styles struct {
+		Xfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+			FontID   int `xml:"fontId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := xml.Unmarshal(parts["xl/styles.xml"], &styles); err != nil {
+		t.Fatal(err)
+	}
+	wantXfs := map[Style][2]int{Plain: {0, 0}, Bold: {0, 1}, Decimal: {4, 0}, BoldDecimal: {4, 1}}
+	for style, want := range wantXfs {
+		if got := styles.Xfs[style]; got.NumFmtID != want[0] || got.FontID != want[1] {
+			t.Errorf("style %d is numFmt %d font %d, want %v", style, got.NumFmtID, got.FontID, want)
+		}
+	}
+
+	var ws worksheet
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &ws); err != nil {
+		t.Fatal(err)
+	}
+	if len(ws.Panes) != 1 || ws.Panes[0].YSplit != "1" || ws.Panes[0].State != "frozen" {
+		t.Errorf("heading row isn't frozen: %+v", ws.Panes)
+	}
+	if len(ws.Cols) != 2 || ws.Cols[0].Width != "28" || ws.Cols[1].Min != 2 || ws.Cols[1].Width != "14.5" {
+		t.Errorf("cols = %+v", ws.Cols)
+	}
+
+	type cell struct {
+		ref   string
+		style int
+		typ   string
+		value string
+	}
+	want := [][]cell{
+		{{"A1", 1, "inlineStr", "Product"}, {"B1", 1, "inlineStr", "Cost"}},
+		{{"A2", 0, "inlineStr", "Milk <1L>"}, {"B2", 2, "", "12.5"}},
+		{{"A3", 0, "inlineStr", "Bread"}, {"C3", 0, "", "3"}}, // the empty cell is left out
+		{{"A4", 1, "inlineStr", "Total"}, {"B4", 3, "", "1234.56"}},
+	}
+	if len(ws.Rows) != len(want) {
+		t.Fatalf("%d rows, want %d", len(ws.Rows), len(want))
+	}
+	for i, row := range ws.Rows {
+		if row.R != i+1 || len(row.Cells) != len(want[i]) {
+			t.Errorf("row %d = %+v", i+1, row)
+			continue
+		}
+		for j, c := range row.Cells {
+			got := cell{c.R, c.S, c.T, c.V}
+			if c.T == "inlineStr" {
+				got.value = c.Inline
+			}
+			if got != want[i][j] {
+				t.Errorf("cell %s = %+v, want %+v", c.R, got, want[i][j])
+			}
+		}
+	}
+}
+
+func TestWriteSingleRow(t *testing.T) {
+	s := New("Empty")
+	s.AddRow(Text("Heading"), Empty().Styled(Bold))
+	parts := readParts(t, s)
+
+	var ws worksheet
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &ws); err != nil {
+		t.Fatal(err)
+	}
+	if len(ws.Panes) != 0 || len(ws.Cols) != 0 {
+		t.Errorf("a lone heading needs no frozen pane or widths: %+v %+v", ws.Panes, ws.Cols)
+	}
+	// A styled empty cell is kept so the style shows.
+	if cells := ws.Rows[0].Cells; len(cells) != 2 || cells[1].R != "B1" || cells[1].S != int(Bold) || cells[1].V != "" {
+		t.Errorf("cells = %+v", cells)
+	}
+}
@@ -7,24 +7,117 @@ CREATE TABLE IF NOT EXISTS users (
     email VARCHAR(255) NOT NULL UNIQUE,
     password VARCHAR(255) NOT NULL,
     verification_token VARCHAR(64),
+    verification_expires_at DATETIME NULL,
+    reset_token_hash CHAR(64) NULL,
+    reset_expires_at DATETIME NULL,
     verified BOOLEAN DEFAULT FALSE,
     role ENUM('customer', 'worker', 'admin', 'demo') NOT NULL DEFAULT 'customer'
 );
 
+CREATE TABLE IF NOT EXISTS sessions (
+    tokenHash CHAR(64) PRIMARY KEY,
+    userID INT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS mail_outbox (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    recipient VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    body_text TEXT NOT NULL,
+    body_html TEXT NULL,
+    template VARCHAR(64) NOT NULL,
+    status ENUM('pending', 'sent', 'failed') NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    next_attempt_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    sent_at DATETIME NULL,
+    INDEX idx_mail_outbox_due (status, next_attempt_at)
+);
+
+CREATE TABLE IF NOT EXISTS mail_outbox_attachments (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    outboxID INT NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    contentType VARCHAR(100) NOT NULL,
+    data MEDIUMBLOB NOT NULL,
+    FOREIGN KEY (outboxID) REFERENCES mail_outbox(id) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS suppliers (
     id INT AUTO_INCREMENT PRIMARY KEY,
-    supplierName VARCHAR(255) NOT NULL
+    supplierName VARCHAR(255) NOT NULL,
+    contactEmail VARCHAR(255) NULL,
+    contactPhone VARCHAR(64) NULL,
+    address TEXT NULL,
+    leadTimeDays INT NOT NULL DEFAULT 7,
+    minOrderValue DECIMAL(10,2) NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE IF NOT EXISTS categories (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(128) NOT NULL,
+    parentID INT NULL,
+    UNIQUE KEY uq_category_name (parentID, name),
+    FOREIGN KEY (parentID) REFERENCES categories(id) ON DELETE RESTRICT
 );
 
 CREATE TABLE IF NOT EXISTS products (
     id INT AUTO_INCREMENT PRIMARY KEY,
+    sku VARCHAR(64) NULL UNIQUE,
     productName VARCHAR(255) NOT NULL,
+    description TEXT NULL,
     unitType VARCHAR(50) NOT NULL,
     supplierID INT NOT NULL,
+    categoryID INT NULL,
     shortExpirationDate INT DEFAULT NULL,
     image VARCHAR(255) DEFAULT NULL,
     price DECIMAL(10,2) NOT NULL,
-    FOREIGN KEY (supplierID) REFERENCES suppliers(id) ON DELETE CASCADE
+    weightKg FLOAT NULL,
+    lengthCm FLOAT NULL,
+    widthCm FLOAT NULL,
+    heightCm FLOAT NULL,
+    reorderPoint FLOAT NULL,
+    safetyStock FLOAT NOT NULL DEFAULT 0,
+    reorderQuantity FLOAT NULL,
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    archived_at DATETIME NULL,
+    FOREIGN KEY (supplierID) REFERENCES suppliers(id) ON DELETE CASCADE,
+    FOREIGN KEY (categoryID) REFERENCES categories(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS product_barcodes (
+    barcode VARCHAR(64) PRIMARY KEY,
+    productID INT NOT NULL,
+    symbology ENUM('ean13', 'upc', 'code128') NOT NULL,
+    FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS supplier_products (
+    supplierID INT NOT NULL,
+    productID INT NOT NULL,
+    supplierSku VARCHAR(64) NOT NULL,
+    costPrice DECIMAL(10,2) NOT NULL,
+    packSize FLOAT NOT NULL DEFAULT 1,
+    PRIMARY KEY (supplierID, productID),
+    UNIQUE KEY uq_supplier_sku (supplierID, supplierSku),
+    FOREIGN KEY (supplierID) REFERENCES suppliers(id) ON DELETE CASCADE,
+    FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS purchase_orders (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  supplierID INT NOT NULL,
+  status ENUM('open', 'partially_received', 'received') NOT NULL DEFAULT 'open',
+  expectedDate DATE DEFAULT NULL,
+  createdBy INT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (supplierID) REFERENCES suppliers(id) ON DELETE CASCADE,
+  FOREIGN KEY (createdBy) REFERENCES users(id) ON DELETE SET NULL
 );
 
 CREATE TABLE IF NOT EXISTS orderedProducts (
@@ -32,8 +125,28 @@ CREATE TABLE IF NOT EXISTS orderedProducts (
   productID INT NOT NULL,
   quantity FLOAT NOT NULL,
   expirationDate DATE DEFAULT NULL,
+  purchaseOrderID INT NULL,
+  receivedQuantity FLOAT NULL,
+  damagedQuantity FLOAT NULL,
+  received_at DATETIME NULL,
   created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-  FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE
+  FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE,
+  FOREIGN KEY (purchaseOrderID) REFERENCES purchase_orders(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS locations (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    warehouse VARCHAR(32) NOT NULL,
+    zone VARCHAR(32) NOT NULL,
+    aisle VARCHAR(32) NOT NULL,
+    shelf VARCHAR(32) NOT NULL,
+    bin VARCHAR(32) NOT NULL,
+    code VARCHAR(64) NOT NULL UNIQUE,
+    capacity FLOAT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    productID INT NULL,
+    minQuantity FLOAT NULL,
+    FOREIGN KEY (productID) REFERENCES products(id) ON DELETE SET NULL
 );
 
 CREATE TABLE IF NOT EXISTS stock (
@@ -41,15 +154,82 @@ CREATE TABLE IF NOT EXISTS stock (
     productID INT NOT NULL,
     quantity FLOAT NOT NULL,
     expirationDate DATE NULL,
-    FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE
+    locationID INT NULL,
+    markdownPrice DECIMAL(10,2) NULL,
+    lotNumber VARCHAR(64) NULL,
+    parentBatchID INT NULL,
+    blocked BOOLEAN NOT NULL DEFAULT FALSE,
+    INDEX (lotNumber),
+    FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (locationID) REFERENCES locations(id) ON DELETE SET NULL,
+    FOREIGN KEY (parentBatchID) REFERENCES stock(batchID) ON DELETE SET NULL
 );
 
+-- Raised by the expiry monitor, once per batch and kind.
+CREATE TABLE IF NOT EXISTS expiry_alerts (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    batchID INT NOT NULL,
+    productID INT NOT NULL,
+    kind ENUM('near_expiry', 'expired') NOT NULL,
+    expirationDate DATE NOT NULL,
+    quantity FLOAT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    notified_at DATETIME NULL,
+    acknowledged_at DATETIME NULL,
+    acknowledgedBy INT NULL,
+    UNIQUE KEY uq_expiry_alert (batchID, kind),
+    FOREIGN KEY (batchID) REFERENCES stock(batchID) ON DELETE CASCADE,
+    FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (acknowledgedBy) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- Append-only ledger of every stock change. No foreign key on batchID so the
+-- history survives even if a batch row is removed.
+CREATE TABLE IF NOT EXISTS inventory_movements (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    batchID INT NOT NULL,
+    productID INT NOT NULL,
+    delta FLOAT NOT NULL,
+    quantity FLOAT NOT NULL,
+    reason ENUM('receipt', 'reservation', 'release', 'pick', 'disposal', 'adjustment', 'transfer') NOT NULL,
+    docType VARCHAR(32) NOT NULL,
+    docID INT NULL,
+    actorID INT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_movements_batch (batchID),
+    INDEX idx_movements_product (productID)
+);
+
+CREATE TRIGGER inventory_movements_no_update BEFORE UPDATE ON inventory_movements
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'inventory_movements is append-only';
+
+CREATE TRIGGER inventory_movements_no_delete BEFORE DELETE ON inventory_movements
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'inventory_movements is append-only';
+
 CREATE TABLE IF NOT EXISTS purchase_requests (
     id INT AUTO_INCREMENT PRIMARY KEY,
     userID INT NOT NULL,
-    status ENUM('pending', 'accepted', 'denied', 'shipped') DEFAULT 'pending',
+    status ENUM('pending', 'accepted', 'picking', 'packed', 'shipped', 'delivered', 'cancelled', 'denied') DEFAULT 'pending',
+    parentRequestID INT NULL,
+    subtotal DECIMAL(10,2) NULL,
+    taxRate DECIMAL(5,2) NULL,
+    tax DECIMAL(10,2) NULL,
+    total DECIMAL(10,2) NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (userID) REFERENCES users(id) ON DELETE CASCADE
+    FOREIGN KEY (userID) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (parentRequestID) REFERENCES purchase_requests(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS purchase_request_events (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    requestID INT NOT NULL,
+    fromStatus VARCHAR(20) NULL,
+    toStatus VARCHAR(20) NOT NULL,
+    actorID INT NULL,
+    note VARCHAR(255) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (requestID) REFERENCES purchase_requests(id) ON DELETE CASCADE,
+    FOREIGN KEY (actorID) REFERENCES users(id) ON DELETE SET NULL
 );
 
 CREATE TABLE IF NOT EXISTS purchase_items (
@@ -57,10 +237,60 @@ CREATE TABLE IF NOT EXISTS purchase_items (
     requestID INT NOT NULL,
     productID INT NOT NULL,
     quantity INT NOT NULL,
+    unitPrice DECIMAL(10,2) NULL,
+    backorderID INT NULL,
     FOREIGN KEY (requestID) REFERENCES purchase_requests(id) ON DELETE CASCADE,
     FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE
 );
 
+-- The next invoice number. It is a single row locked while an invoice is
+-- issued, so numbers have no gaps even when a transaction rolls back.
+CREATE TABLE IF NOT EXISTS invoice_sequence (
+    id TINYINT PRIMARY KEY,
+    nextNumber INT NOT NULL
+);
+
+INSERT IGNORE INTO invoice_sequence (id, nextNumber) VALUES (1, 1);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    requestID INT NOT NULL UNIQUE,
+    number VARCHAR(32) NOT NULL UNIQUE,
+    subtotal DECIMAL(10,2) NOT NULL,
+    taxRate DECIMAL(5,2) NOT NULL,
+    tax DECIMAL(10,2) NOT NULL,
+    total DECIMAL(10,2) NOT NULL,
+    issued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (requestID) REFERENCES purchase_requests(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    userID INT NOT NULL,
+    productID INT NOT NULL,
+    quantity INT NOT NULL,
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (userID, productID),
+    FOREIGN KEY (userID) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS backorders (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    requestID INT NOT NULL,
+    itemID INT NOT NULL,
+    productID INT NOT NULL,
+    quantity FLOAT NOT NULL,
+    fulfilled FLOAT NOT NULL DEFAULT 0,
+    status ENUM('open', 'fulfilled', 'cancelled') NOT NULL DEFAULT 'open',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (requestID) REFERENCES purchase_requests(id) ON DELETE CASCADE,
+    FOREIGN KEY (itemID) REFERENCES purchase_items(id) ON DELETE CASCADE,
+    FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE
+);
+
+ALTER TABLE purchase_items
+    ADD FOREIGN KEY (backorderID) REFERENCES backorders(id) ON DELETE SET NULL;
+
 CREATE TABLE IF NOT EXISTS assigned_batches (
     id INT AUTO_INCREMENT PRIMARY KEY,
     itemID INT NOT NULL,
@@ -70,20 +300,130 @@ CREATE TABLE IF NOT EXISTS assigned_batches (
     FOREIGN KEY (batchID) REFERENCES stock(batchID) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS waves (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    workerID INT NULL,
+    createdBy INT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (workerID) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (createdBy) REFERENCES users(id) ON DELETE SET NULL
+);
+
 CREATE TABLE IF NOT EXISTS tasks (
     id INT AUTO_INCREMENT PRIMARY KEY,
     requestID INT NULL UNIQUE,
     orderID INT NULL UNIQUE,
-    workerID INT NOT NULL,
+    workerID INT NULL,
+    waveID INT NULL,
     status ENUM('pending', 'completed') NOT NULL DEFAULT 'pending',
-    type ENUM('unload', 'prepare', 'dispose') NOT NULL,
+    type ENUM('unload', 'prepare', 'dispose', 'transfer', 'count') NOT NULL,
+    assigned_at DATETIME NULL,
+    claimed_at DATETIME NULL,
+    claim_expires_at DATETIME NULL,
+    started_at DATETIME NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (requestID) REFERENCES purchase_requests(id) ON DELETE CASCADE,
-    FOREIGN KEY (workerID) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (workerID) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (waveID) REFERENCES waves(id) ON DELETE SET NULL,
     FOREIGN KEY (orderID) REFERENCES orderedProducts(id) ON DELETE CASCADE
 
 );
 
+-- Batches a worker has scanned while picking a prepare task; the task can
+-- only be completed once these match the reservations.
+CREATE TABLE IF NOT EXISTS pick_confirmations (
+    taskID INT NOT NULL,
+    batchID INT NOT NULL,
+    quantity FLOAT NOT NULL,
+    confirmedBy INT NULL,
+    confirmed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (taskID, batchID),
+    FOREIGN KEY (taskID) REFERENCES tasks(id) ON DELETE CASCADE,
+    FOREIGN KEY (batchID) REFERENCES stock(batchID) ON DELETE CASCADE,
+    FOREIGN KEY (confirmedBy) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS dispose_items (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    taskID INT NOT NULL,
+    batchID INT NOT NULL,
+    quantity FLOAT NOT NULL,
+    reason ENUM('expired', 'damaged', 'recalled') NOT NULL,
+    FOREIGN KEY (taskID) REFERENCES tasks(id) ON DELETE CASCADE,
+    FOREIGN KEY (batchID) REFERENCES stock(batchID) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS transfer_items (
+    taskID INT PRIMARY KEY,
+    batchID INT NOT NULL,
+    toLocationID INT NOT NULL,
+    quantity FLOAT NOT NULL,
+    reason ENUM('manual', 'replenishment') NOT NULL DEFAULT 'manual',
+    FOREIGN KEY (taskID) REFERENCES tasks(id) ON DELETE CASCADE,
+    FOREIGN KEY (batchID) REFERENCES stock(batchID) ON DELETE CASCADE,
+    FOREIGN KEY (toLocationID) REFERENCES locations(id)
+);
+
+CREATE TABLE IF NOT EXISTS count_items (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    taskID INT NOT NULL,
+    batchID INT NOT NULL,
+    expected FLOAT NULL,
+    counted FLOAT NULL,
+    variance FLOAT NULL,
+    posted FLOAT NOT NULL DEFAULT 0,
+    status ENUM('open', 'review', 'posted', 'rejected') NOT NULL DEFAULT 'open',
+    reasonCode ENUM('count_correction', 'damage', 'theft', 'found', 'data_entry', 'other') NULL,
+    countedBy INT NULL,
+    counted_at DATETIME NULL,
+    reviewedBy INT NULL,
+    reviewed_at DATETIME NULL,
+    FOREIGN KEY (taskID) REFERENCES tasks(id) ON DELETE CASCADE,
+    FOREIGN KEY (batchID) REFERENCES stock(batchID) ON DELETE CASCADE,
+    FOREIGN KEY (countedBy) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (reviewedBy) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS write_offs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    batchID INT NOT NULL,
+    productID INT NOT NULL,
+    quantity FLOAT NOT NULL,
+    reason ENUM('expired', 'damaged', 'recalled') NOT NULL,
+    taskID INT NULL,
+    actorID INT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (batchID) REFERENCES stock(batchID) ON DELETE CASCADE,
+    FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (taskID) REFERENCES tasks(id) ON DELETE SET NULL,
+    FOREIGN KEY (actorID) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS recalls (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    productID INT NOT NULL,
+    lotNumber VARCHAR(64) NULL,
+    reason TEXT NOT NULL,
+    status ENUM('open', 'closed') NOT NULL DEFAULT 'open',
+    disposeTaskID INT NULL,
+    createdBy INT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    closed_at DATETIME NULL,
+    FOREIGN KEY (productID) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (disposeTaskID) REFERENCES tasks(id) ON DELETE SET NULL,
+    FOREIGN KEY (createdBy) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS recall_batches (
+    recallID INT NOT NULL,
+    batchID INT NOT NULL,
+    quantity FLOAT NOT NULL,
+    released FLOAT NOT NULL DEFAULT 0,
+    PRIMARY KEY (recallID, batchID),
+    FOREIGN KEY (recallID) REFERENCES recalls(id) ON DELETE CASCADE,
+    FOREIGN KEY (batchID) REFERENCES stock(batchID) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS rapports (
   id INT AUTO_INCREMENT PRIMARY KEY,
   workerID INT NOT NULL,
@@ -105,38 +445,74 @@ INSERT INTO users (email, password, verified, role) VALUES
   ('admin',  '$2b$12$sYCLRq0dRtRZcqZvA2H/bOWhPEe5kNWDoldQluxmNVyozdOkpizd2', TRUE,  'admin');
 
 
-INSERT INTO suppliers (supplierName) VALUES 
-('Global Supply Co.'),
-('FreshFarm Ltd.'),
-('TechParts Inc.'),
-('Daily Needs Wholesale');
-
-INSERT INTO products (productName, unitType, supplierID, shortExpirationDate, image, price) VALUES 
-('Apples 1KG', 'kg', 2, 10, 'assets/images/products/Apples.jpg', 3.50),
-('Bananas 1KG', 'kg', 2, 4, 'assets/images/products/Bananas.jpg', 2.20),
-('USB Cable', 'unit', 3, NULL, 'assets/images/products/USB Cable.jpg', 10.00),
-('Rice 1KG', 'unit', 1, 30, 'assets/images/products/Rice 1KG.jpg', 1.80),
-('Milk 1L', 'unit', 2, 3, 'assets/images/products/Milk 1L.jpg', 1.40),
-('Toilet Paper 12-rolls', 'unit', 4, 30, 'assets/images/products/Toilet Paper 12-rolls.jpg', 3.00),
-('Eggs Dozen', 'unit', 2, 7, 'assets/images/products/Eggs Dozen.jpg', 3.20);
-
-INSERT INTO stock (productID, quantity, expirationDate) VALUES
-(1, 120, '2025-12-01'),
-(2, 200, '2025-11-15'),
-(4, 300, '2026-03-01'),
-
-(3, 500, NULL),
-(6, 1000, '2030-01-05'),
-
-(1, 80, '2025-06-01'),
-(1, 60, '2025-07-10'),
-(2, 150, '2025-08-15'),
-(2, 90, '2025-09-01'),
-(4, 250, '2026-01-01'),
-(4, 400, '2026-04-20'),
-(5, 200, '2025-06-15'),
-(5, 120, '2025-07-01'),
-(7, 210, '2025-06-25'),
-(7, 150, '2025-07-10');
+INSERT INTO suppliers (supplierName, contactEmail, contactPhone, address, leadTimeDays, minOrderValue) VALUES
+('Global Supply Co.', 'orders@globalsupply.example', '+1 555 0100', '1 Harbour Road, Portsville', 10, 200.00),
+('FreshFarm Ltd.', 'sales@freshfarm.example', '+1 555 0101', '42 Orchard Lane, Greenfield', 2, 50.00),
+('TechParts Inc.', 'b2b@techparts.example', '+1 555 0102', '7 Circuit Ave, Silicon Park', 14, 100.00),
+('Daily Needs Wholesale', 'trade@dailyneeds.example', '+1 555 0103', '90 Market Street, Midtown', 5, 0.00);
+
+INSERT INTO categories (name, parentID) VALUES
+('Food', NULL),
+('Household', NULL),
+('Electronics', NULL),
+('Fresh Produce', 1),
+('Dairy & Eggs', 1),
+('Pantry', 1);
+
+INSERT INTO products (sku, productName, unitType, supplierID, categoryID, shortExpirationDate, image, price, weightKg) VALUES 
+('FRU-APL-1KG', 'Apples 1KG', 'kg', 2, 4, 10, 'assets/images/products/Apples.jpg', 3.50, 1),
+('FRU-BAN-1KG', 'Bananas 1KG', 'kg', 2, 4, 4, 'assets/images/products/Bananas.jpg', 2.20, 1),
+('ELE-USB-C', 'USB Cable', 'unit', 3, 3, NULL, 'assets/images/products/USB Cable.jpg', 10.00, 0.05),
+('PAN-RICE-1KG', 'Rice 1KG', 'unit', 1, 6, 30, 'assets/images/products/Rice 1KG.jpg', 1.80, 1),
+('DAI-MLK-1L', 'Milk 1L', 'unit', 2, 5, 3, 'assets/images/products/Milk 1L.jpg', 1.40, 1.03),
+('HOU-TP-12', 'Toilet Paper 12-rolls', 'unit', 4, 2, 30, 'assets/images/products/Toilet Paper 12-rolls.jpg', 3.00, 1.2),
+('DAI-EGG-12', 'Eggs Dozen', 'unit', 2, 5, 7, 'assets/images/products/Eggs Dozen.jpg', 3.20, 0.7);
+
+INSERT INTO product_barcodes (barcode, productID, symbology) VALUES
+('4006381333931', 1, 'ean13'),
+('5901234123457', 2, 'ean13'),
+('036000291452', 3, 'upc'),
+('8711000530085', 4, 'ean13'),
+('4002103248118', 5, 'ean13'),
+('HOU-TP-12', 6, 'code128'),
+('0012345678905', 7, 'ean13');
+
+INSERT INTO supplier_products (supplierID, productID, supplierSku, costPrice, packSize) VALUES
+(2, 1, 'FF-APL-1', 2.10, 10),
+(2, 2, 'FF-BAN-1', 1.30, 10),
+(3, 3, 'TP-USB-C1', 5.50, 25),
+(1, 4, 'GS-RICE-1', 1.05, 20),
+(2, 5, 'FF-MLK-1L', 0.80, 12),
+(4, 6, 'DN-TP-12', 1.70, 6),
+(2, 7, 'FF-EGG-12', 1.90, 10),
+(1, 6, 'GS-TP-12', 1.85, 8);
+
+INSERT INTO locations (warehouse, zone, aisle, shelf, bin, code, capacity) VALUES
+('WH1', 'A', '01', '1', '01', 'WH1-A-01-1-01', 1000),
+('WH1', 'A', '01', '1', '02', 'WH1-A-01-1-02', 1000),
+('WH1', 'A', '02', '1', '01', 'WH1-A-02-1-01', 1000),
+('WH1', 'B', '01', '1', '01', 'WH1-B-01-1-01', 1500),
+('WH1', 'B', '02', '1', '01', 'WH1-B-02-1-01', 1500);
+
+INSERT INTO stock (productID, quantity, expirationDate, locationID, lotNumber) VALUES
+(1, 120, '2025-12-01', 1, 'FF-APL-2511'),
+(2, 200, '2025-11-15', 2, 'FF-BAN-2511'),
+(4, 300, '2026-03-01', 4, 'GS-R-0301'),
+
+(3, 500, NULL, 5, 'TP-24-118'),
+(6, 1000, '2030-01-05', 5, NULL),
 
+(1, 80, '2025-06-01', 1, 'FF-APL-2505'),
+(1, 60, '2025-07-10', 1, 'FF-APL-2507'),
+(2, 150, '2025-08-15', 2, 'FF-BAN-2508'),
+(2, 90, '2025-09-01', 2, 'FF-BAN-2508'),
+(4, 250, '2026-01-01', 4, 'GS-R-0101'),
+(4, 400, '2026-04-20', 4, 'GS-R-0420'),
+(5, 200, '2025-06-15', 3, 'FF-MLK-0615'),
+(5, 120, '2025-07-01', 3, 'FF-MLK-0701'),
+(7, 210, '2025-06-25', 3, 'FF-EGG-0625'),
+(7, 150, '2025-07-10', 3, 'FF-EGG-0710');
 
+-- Opening balances for the seeded batches.
+INSERT INTO inventory_movements (batchID, productID, delta, quantity, reason, docType)
+SELECT batchID, productID, quantity, quantity, 'receipt', 'seed' FROM stock;
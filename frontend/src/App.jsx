@@ -11,11 +11,13 @@ import HomeCustomer from "./pages/HomeCustomer";
 import HomeWorker from "./pages/HomeWorker";
 import LoginForm from "./components/LoginForm";
 import RegisterForm from "./components/RegisterForm";
+import ResetPasswordForm from "./components/ResetPasswordForm";
 import Cart from "./pages/Cart";
 import AdminLayout from "./layouts/AdminLayout";
 import AdminDashboard from "./pages/AdminDashboard";
 import AdminReports from "./pages/AdminReports";
 import AdminPanel from "./pages/AdminPanel";
+import AdminExpiryAlerts from "./pages/AdminExpiryAlerts";
 import OrderHistory from "./pages/OrderHistory";
 import RapportHistory from "./pages/RapportHistory";
 
@@ -35,6 +37,7 @@ function AppContent() {
         <Route path="/" element={<h2>Welcome to the homepage</h2>} />
         <Route path="/login" element={<LoginForm />} />
         <Route path="/register" element={<RegisterForm />} />
+        <Route path="/reset-password" element={<ResetPasswordForm />} />
 
         {/* Protected routes based on role */}
         <Route
@@ -63,6 +66,7 @@ function AppContent() {
           <Route path="dashboard" element={<AdminDashboard />} />
           <Route path="reports" element={<AdminReports />} />
           <Route path="panel" element={<AdminPanel />} />
+          <Route path="expiry-alerts" element={<AdminExpiryAlerts />} />
         </Route>
         <Route
           path="/cart"
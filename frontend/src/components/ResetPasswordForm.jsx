@@ -0,0 +1,88 @@
+import React, { useState } from "react";
+import { TextField, Button, Typography, Box, Toolbar } from "@mui/material";
+import { useNavigate, useSearchParams } from "react-router-dom";
+
+export default function ResetPasswordForm() {
+  const navigate = useNavigate();
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get("token") || "";
+  const [password, setPassword] = useState("");
+  const [confirm, setConfirm] = useState("");
+
+  const handleReset = async () => {
+    if (password !== confirm) {
+      alert("Passwords do not match");
+      return;
+    }
+    try {
+      const res = await fetch("/api/password/reset", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token, password }),
+      });
+
+      if (!res.ok) {
+        const text = await res.text();
+        throw new Error(`Reset failed: ${text}`);
+      }
+
+      const data = await res.json();
+      alert(data.message);
+      navigate("/login");
+    } catch (err) {
+      alert(err.message);
+    }
+  };
+
+  if (!token) {
+    return (
+      <>
+        <Toolbar />
+        <Box sx={{ mt: 2 }}>
+          <Typography variant="h5">Reset password</Typography>
+          <Typography>
+            This reset link is incomplete. Use the link from the email again.
+          </Typography>
+        </Box>
+      </>
+    );
+  }
+
+  return (
+    <>
+      <Toolbar />
+      <Box sx={{ mt: 2 }}>
+        <Typography variant="h5">Choose a new password</Typography>
+        <TextField
+          fullWidth
+          margin="normal"
+          label="New password"
+          value={password}
+          onChange={(e) => setPassword(e.target.value)}
+          type="password"
+          autoComplete="new-password"
+          required
+        />
+        <TextField
+          fullWidth
+          margin="normal"
+          label="Repeat new password"
+          value={confirm}
+          onChange={(e) => setConfirm(e.target.value)}
+          type="password"
+          autoComplete="new-password"
+          required
+        />
+        <Button
+          onClick={handleReset}
+          variant="contained"
+          color="primary"
+          component="button"
+          disabled={!password}
+        >
+          Reset password
+        </Button>
+      </Box>
+    </>
+  );
+}
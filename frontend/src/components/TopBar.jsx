@@ -48,6 +48,7 @@ const TopBar = () => {
   }, [role]);
 
   const handleLogout = () => {
+    fetch("/api/logout", { method: "POST" }).catch(() => {});
     setRole(null);
     localStorage.removeItem("role");
     localStorage.removeItem("userId");
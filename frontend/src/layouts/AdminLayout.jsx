@@ -6,6 +6,7 @@ const adminPages = [
   { label: "Dashboard", path: "/admin/dashboard" },
   { label: "Reports", path: "/admin/reports" },
   { label: "Admin Panel", path: "/admin/panel" },
+  { label: "Expiry Alerts", path: "/admin/expiry-alerts" },
 ];
 
 export default function AdminLayout() {
@@ -0,0 +1,101 @@
+import React, { useEffect, useState } from "react";
+import {
+  Box,
+  Typography,
+  Paper,
+  Button,
+  List,
+  ToggleButton,
+  ToggleButtonGroup,
+} from "@mui/material";
+
+export default function AdminExpiryAlerts() {
+  const [alerts, setAlerts] = useState([]);
+  const [status, setStatus] = useState("open");
+
+  useEffect(() => {
+    fetch(`/api/expiry-alerts?status=${status}`)
+      .then((res) => res.json())
+      .then((data) => setAlerts(Array.isArray(data) ? data : []))
+      .catch((err) => {
+        console.error("Error fetching expiry alerts:", err);
+        setAlerts([]);
+      });
+  }, [status]);
+
+  const acknowledge = async (alert) => {
+    try {
+      const res = await fetch(`/api/expiry-alerts/${alert.id}/acknowledge`, {
+        method: "POST",
+      });
+      if (!res.ok) throw new Error(await res.text());
+      setAlerts((prev) =>
+        status === "open"
+          ? prev.filter((a) => a.id !== alert.id)
+          : prev.map((a) =>
+              a.id === alert.id
+                ? { ...a, acknowledgedAt: new Date().toISOString() }
+                : a
+            )
+      );
+    } catch (err) {
+      console.error(err);
+      window.alert("Failed to acknowledge alert");
+    }
+  };
+
+  return (
+    <Box sx={{ p: 3 }}>
+      <Typography variant="h4" gutterBottom>
+        Expiry Alerts
+      </Typography>
+
+      <ToggleButtonGroup
+        value={status}
+        exclusive
+        size="small"
+        onChange={(e, value) => value && setStatus(value)}
+        sx={{ mb: 2 }}
+      >
+        <ToggleButton value="open">Open</ToggleButton>
+        <ToggleButton value="acknowledged">Acknowledged</ToggleButton>
+        <ToggleButton value="all">All</ToggleButton>
+      </ToggleButtonGroup>
+
+      {alerts.length === 0 && (
+        <Typography color="text.secondary">No alerts.</Typography>
+      )}
+
+      <List>
+        {alerts.map((a) => (
+          <Paper key={a.id} sx={{ p: 2, mb: 2 }}>
+            <Typography variant="subtitle1">
+              {a.productName}, batch {a.batchId}
+              {a.binCode ? ` in ${a.binCode}` : ""}
+            </Typography>
+            <Typography variant="body2">
+              {a.quantity} on hand,{" "}
+              {a.kind === "expired" ? "expired" : "expires"}{" "}
+              {a.expirationDate}
+              {a.markdownPrice ? `, marked down to ${a.markdownPrice}` : ""}
+            </Typography>
+            {a.acknowledgedAt ? (
+              <Typography variant="caption" color="text.secondary">
+                Acknowledged {new Date(a.acknowledgedAt).toLocaleString()}
+              </Typography>
+            ) : (
+              <Button
+                size="small"
+                variant="outlined"
+                sx={{ mt: 1 }}
+                onClick={() => acknowledge(a)}
+              >
+                Acknowledge
+              </Button>
+            )}
+          </Paper>
+        ))}
+      </List>
+    </Box>
+  );
+}
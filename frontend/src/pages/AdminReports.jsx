@@ -63,7 +63,7 @@ export default function AdminReports() {
         await fetch("/api/tasks", {
           method: "POST",
           headers: { "Content-Type": "application/json" },
-          body: JSON.stringify({ type: "unload", orderId, workerId: 1 }),
+          body: JSON.stringify({ type: "unload", orderId }),
         });
 
         setDeliveries((ds) => ds.filter((d) => d.id !== orderId));